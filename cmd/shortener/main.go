@@ -1,14 +1,46 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
 	"github.com/vadim-ivlev/url-shortener/internal/app"
 	"github.com/vadim-ivlev/url-shortener/internal/server"
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run - запускает сервис и возвращает код завершения процесса.
+func run() int {
+	// Прочитать конфигурацию
+	app.InitConfig()
+
+	// Выполнить подкоманду, если она указана после параметров
+	if flag.NArg() > 0 {
+		if err := app.RunCommand(flag.Args(), os.Stdout); err != nil {
+			log.Error().Err(err).Msg("Command failed")
+			return 1
+		}
+		return 0
+	}
+
+	// Остановить сервер по SIGINT или SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Инициализировать приложение
-	app.InitApp()
+	a := app.NewFromConfig()
 
 	// Запустить сервер
-	server.ServeChi()
+	if err := server.ServeChi(ctx, a); err != nil {
+		log.Error().Err(err).Msg("Server stopped with error")
+		return 1
+	}
+	return 0
 }
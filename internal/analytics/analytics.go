@@ -0,0 +1,186 @@
+// Description: Статистика переходов по коротким URL.
+// Каждый переход записывается как событие Click.
+// Агрегированная статистика хранится в памяти: общее количество переходов,
+// уникальные посетители (по паре огрубленный IP + User-Agent) и количество переходов по дням.
+
+package analytics
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DateLayout - формат даты в гистограмме переходов по дням.
+const DateLayout = "2006-01-02"
+
+// Click - событие перехода по короткому URL.
+type Click struct {
+	ShortID   string    `json:"short_id" db:"short_id"`
+	Time      time.Time `json:"time" db:"clicked_at"`
+	Referrer  string    `json:"referrer,omitempty" db:"referrer"`
+	UserAgent string    `json:"user_agent,omitempty" db:"user_agent"`
+	ClientIP  string    `json:"client_ip,omitempty" db:"client_ip"`
+}
+
+// DayClicks - количество переходов за день.
+type DayClicks struct {
+	Date   string `json:"date" db:"date"`
+	Clicks int64  `json:"clicks" db:"clicks"`
+}
+
+// Stats - статистика переходов по короткому URL.
+type Stats struct {
+	TotalClicks    int64       `json:"total_clicks"`
+	UniqueVisitors int64       `json:"unique_visitors"`
+	Daily          []DayClicks `json:"daily"`
+}
+
+// NewClick создает событие перехода по короткому URL shortID из HTTP-запроса.
+func NewClick(shortID string, r *http.Request) Click {
+	return Click{
+		ShortID:   shortID,
+		Time:      time.Now().UTC(),
+		Referrer:  r.Referer(),
+		UserAgent: r.UserAgent(),
+		ClientIP:  CoarseIP(ClientIP(r.RemoteAddr, r.Header.Get("X-Real-IP"))),
+	}
+}
+
+// trustedProxies - сети обратных прокси, которым доверяется заголовок X-Real-IP (см. SetTrustedProxies).
+var trustedProxies []*net.IPNet
+
+// SetTrustedProxies задает обратные прокси, которым доверяется адрес клиента в X-Real-IP.
+// list - список адресов или сетей в нотации CIDR через запятую. Пустой список означает,
+// что X-Real-IP не учитывается и адресом клиента считается адрес соединения.
+// Вызывается при запуске приложения до обработки запросов.
+func SetTrustedProxies(list string) error {
+	nets := make([]*net.IPNet, 0)
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return fmt.Errorf("trusted proxies: invalid address %q", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return fmt.Errorf("trusted proxies: %w", err)
+		}
+		nets = append(nets, ipNet)
+	}
+	trustedProxies = nets
+	return nil
+}
+
+// isTrustedProxy - проверяет, входит ли адрес ip в сети доверенных обратных прокси.
+func isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range trustedProxies {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP возвращает IP-адрес клиента по адресу соединения remoteAddr (host:port или host)
+// и значению заголовка X-Real-IP realIP. realIP учитывается, только если соединение
+// установлено доверенным обратным прокси (см. SetTrustedProxies), иначе его может подделать любой клиент.
+func ClientIP(remoteAddr, realIP string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	if realIP = strings.TrimSpace(realIP); realIP != "" && isTrustedProxy(host) {
+		return realIP
+	}
+	return host
+}
+
+// CoarseIP огрубляет IP-адрес, чтобы не хранить адрес посетителя целиком:
+// у IPv4 обнуляется последний октет (/24), у IPv6 остаются первые 48 бит (/48).
+// Если адрес не удалось разобрать, возвращается пустая строка.
+func CoarseIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	switch {
+	case parsed == nil:
+		return ""
+	case parsed.To4() != nil:
+		return parsed.Mask(net.CIDRMask(24, 32)).String()
+	default:
+		return parsed.Mask(net.CIDRMask(48, 128)).String()
+	}
+}
+
+// urlStats - статистика переходов по одному короткому URL.
+type urlStats struct {
+	total    int64
+	visitors map[string]struct{}
+	daily    map[string]int64
+}
+
+// Aggregator - статистика переходов по коротким URL в памяти.
+type Aggregator struct {
+	stats map[string]*urlStats
+	mutex sync.Mutex
+}
+
+// NewAggregator создает пустую статистику переходов.
+func NewAggregator() *Aggregator {
+	return &Aggregator{stats: make(map[string]*urlStats)}
+}
+
+// Add учитывает переходы в статистике.
+func (a *Aggregator) Add(clicks ...Click) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for _, c := range clicks {
+		s, ok := a.stats[c.ShortID]
+		if !ok {
+			s = &urlStats{visitors: make(map[string]struct{}), daily: make(map[string]int64)}
+			a.stats[c.ShortID] = s
+		}
+		s.total++
+		s.visitors[c.ClientIP+"|"+c.UserAgent] = struct{}{}
+		s.daily[c.Time.UTC().Format(DateLayout)]++
+	}
+}
+
+// Get возвращает статистику переходов по короткому URL.
+// Гистограмма по дням упорядочена по возрастанию даты.
+func (a *Aggregator) Get(shortID string) Stats {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	result := Stats{Daily: make([]DayClicks, 0)}
+	s, ok := a.stats[shortID]
+	if !ok {
+		return result
+	}
+	result.TotalClicks = s.total
+	result.UniqueVisitors = int64(len(s.visitors))
+	for date, clicks := range s.daily {
+		result.Daily = append(result.Daily, DayClicks{Date: date, Clicks: clicks})
+	}
+	sort.Slice(result.Daily, func(i, j int) bool { return result.Daily[i].Date < result.Daily[j].Date })
+	return result
+}
@@ -0,0 +1,96 @@
+package analytics
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoarseIP(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   string
+		want string
+	}{
+		{name: "ipv4", ip: "192.168.10.42", want: "192.168.10.0"},
+		{name: "ipv6", ip: "2001:db8:abcd:12::1", want: "2001:db8:abcd::"},
+		{name: "garbage", ip: "not an ip", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, CoarseIP(tt.ip))
+		})
+	}
+}
+
+func TestNewClick(t *testing.T) {
+	r := httptest.NewRequest("GET", "/abc", nil)
+	r.RemoteAddr = "10.1.2.3:5555"
+	r.Header.Set("Referer", "https://ref.example.com")
+	r.Header.Set("User-Agent", "test-agent")
+
+	c := NewClick("abc", r)
+	assert.Equal(t, "abc", c.ShortID)
+	assert.Equal(t, "https://ref.example.com", c.Referrer)
+	assert.Equal(t, "test-agent", c.UserAgent)
+	assert.Equal(t, "10.1.2.0", c.ClientIP)
+
+	// Адрес от недоверенного клиента не учитывается
+	r.Header.Set("X-Real-IP", "172.16.5.6")
+	assert.Equal(t, "10.1.2.0", NewClick("abc", r).ClientIP)
+
+	// Адрес от доверенного обратного прокси
+	assert.NoError(t, SetTrustedProxies("10.1.0.0/16"))
+	t.Cleanup(func() { SetTrustedProxies("") })
+	assert.Equal(t, "172.16.5.0", NewClick("abc", r).ClientIP)
+}
+
+func TestClientIP(t *testing.T) {
+	assert.Error(t, SetTrustedProxies("10.0.0.0/33"))
+	assert.Error(t, SetTrustedProxies("proxy"))
+	assert.NoError(t, SetTrustedProxies(" 10.0.0.0/8, 192.168.1.1 ,2001:db8::/32"))
+	t.Cleanup(func() { SetTrustedProxies("") })
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		realIP     string
+		want       string
+	}{
+		{name: "trusted network", remoteAddr: "10.2.3.4:5555", realIP: "203.0.113.7", want: "203.0.113.7"},
+		{name: "trusted address", remoteAddr: "192.168.1.1:5555", realIP: "203.0.113.7", want: "203.0.113.7"},
+		{name: "trusted ipv6", remoteAddr: "[2001:db8::1]:5555", realIP: "203.0.113.7", want: "203.0.113.7"},
+		{name: "untrusted", remoteAddr: "192.168.1.2:5555", realIP: "203.0.113.7", want: "192.168.1.2"},
+		{name: "no header", remoteAddr: "10.2.3.4:5555", want: "10.2.3.4"},
+		{name: "no port", remoteAddr: "192.168.1.2", realIP: "203.0.113.7", want: "192.168.1.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ClientIP(tt.remoteAddr, tt.realIP))
+		})
+	}
+}
+
+func TestAggregator(t *testing.T) {
+	a := NewAggregator()
+
+	day1 := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	day2 := day1.Add(24 * time.Hour)
+	a.Add(
+		Click{ShortID: "abc", Time: day2, ClientIP: "10.0.0.0", UserAgent: "a"},
+		Click{ShortID: "abc", Time: day1, ClientIP: "10.0.0.0", UserAgent: "a"},
+		Click{ShortID: "abc", Time: day1, ClientIP: "10.0.0.0", UserAgent: "b"},
+		Click{ShortID: "xyz", Time: day1, ClientIP: "10.0.1.0", UserAgent: "a"},
+	)
+
+	s := a.Get("abc")
+	assert.Equal(t, int64(3), s.TotalClicks)
+	assert.Equal(t, int64(2), s.UniqueVisitors)
+	assert.Equal(t, []DayClicks{{Date: "2024-06-01", Clicks: 2}, {Date: "2024-06-02", Clicks: 1}}, s.Daily)
+
+	empty := a.Get("unknown")
+	assert.Equal(t, int64(0), empty.TotalClicks)
+	assert.Empty(t, empty.Daily)
+}
@@ -1,23 +1,119 @@
 // Description: Бизнес логика приложения.
 // Определенные здесь фунции связывают в себе вызовы функций различных пакетов.
 // Цель  -  понизить связанность (coupling) между пакетами.
+// Все зависимости приложения (хранилище, генератор коротких id) хранятся в структуре App
+// и передаются ей при создании, поэтому в одном процессе можно запустить несколько независимых приложений.
 
 package app
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/rs/zerolog/log"
+	"github.com/vadim-ivlev/url-shortener/internal/analytics"
+	"github.com/vadim-ivlev/url-shortener/internal/auth"
 	"github.com/vadim-ivlev/url-shortener/internal/config"
 	"github.com/vadim-ivlev/url-shortener/internal/db"
 	"github.com/vadim-ivlev/url-shortener/internal/filestorage"
 	"github.com/vadim-ivlev/url-shortener/internal/logger"
+	"github.com/vadim-ivlev/url-shortener/internal/metrics"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+	"github.com/vadim-ivlev/url-shortener/internal/shortener"
 	"github.com/vadim-ivlev/url-shortener/internal/storage"
+	"github.com/vadim-ivlev/url-shortener/migrations"
 )
 
-// InitApp инициализирует приложение.
-func InitApp() {
+// App - приложение сокращения URL.
+type App struct {
+	// Repo - хранилище коротких URL
+	Repo repository.Repository
+	// Generator - генератор коротких id
+	Generator shortener.Generator
+	// BaseURL - префикс коротких URL
+	BaseURL string
+
+	// deleteMutex - защищает очередь удаления от отправки в нее во время закрытия
+	deleteMutex sync.RWMutex
+	// deleteQueue - очередь запросов на удаление
+	deleteQueue chan deleteTask
+	// deleteClosed - очередь удаления закрыта, новые запросы отклоняются
+	deleteClosed bool
+	// deleterDone - закрывается, когда фоновый обработчик удаления завершил работу
+	deleterDone chan struct{}
+
+	// sweeperStop - закрывается для остановки фонового удаления просроченных URL
+	sweeperStop chan struct{}
+	// sweeperDone - закрывается, когда фоновое удаление просроченных URL завершилось
+	sweeperDone chan struct{}
+
+	// clickMutex - защищает буфер событий перехода от отправки в него во время закрытия
+	clickMutex sync.RWMutex
+	// clickQueue - буфер событий перехода
+	clickQueue chan analytics.Click
+	// clickClosed - буфер событий закрыт, новые события отбрасываются
+	clickClosed bool
+	// clickRecorderDone - закрывается, когда фоновая запись переходов завершила работу
+	clickRecorderDone chan struct{}
+}
+
+// New создает приложение с хранилищем repo и генератором коротких id generator.
+// Фоновые обработчики запускаются методом Start.
+func New(repo repository.Repository, generator shortener.Generator, baseURL string) *App {
+	return &App{Repo: repo, Generator: generator, BaseURL: baseURL}
+}
+
+// Start запускает фоновые обработчики приложения.
+func (a *App) Start() {
+	// Запустить фоновое удаление коротких URL
+	a.StartDeleter()
+	// Запустить фоновое удаление просроченных коротких URL
+	a.StartSweeper()
+	// Запустить фоновую запись переходов
+	a.StartClickRecorder()
+}
+
+// Stop останавливает фоновые обработчики приложения, дождавшись обработки поставленных в очереди задач.
+func (a *App) Stop() {
+	a.StopDeleter()
+	a.StopSweeper()
+	a.StopClickRecorder()
+}
+
+// Shutdown останавливает фоновые обработчики приложения, дождавшись обработки поставленных в очереди задач,
+// и закрывает хранилище, сбрасывая его данные на диск.
+// Задачи, поставленные после начала остановки, отклоняются (см. QueueDelete и RecordClick).
+// Если фоновые обработчики не остановились до отмены ctx, то хранилище не закрывается,
+// потому что обработчики еще пишут в него, и возвращается ошибка.
+func (a *App) Shutdown(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		a.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		return fmt.Errorf("background workers did not stop, storage is left open: %w", ctx.Err())
+	}
+	if err := a.Repo.Close(); err != nil {
+		return fmt.Errorf("close storage: %w", err)
+	}
+	return nil
+}
+
+// InitApp инициализирует приложение в соответствии с конфигурацией и запускает его фоновые обработчики.
+func InitApp() *App {
+	InitConfig()
+	return NewFromConfig()
+}
+
+// InitConfig инициализирует логгер и читает конфигурацию из командной строки и переменных окружения.
+func InitConfig() {
 	// Инициализировать логгер
 	logger.InitializeLogger()
 
@@ -25,82 +121,183 @@ func InitApp() {
 	config.ParseCommandLine()
 	// Разобрать переменные окружения
 	config.ParseEnv()
+	// Уточнить значения по умолчанию, зависящие от других параметров
+	config.ResolveDefaults()
 	// Вывести параметры конфигурации в лог
 	config.PrintParams()
+}
 
-	// Создать хранилище в памяти
-	storage.Create()
-
-	// Подключиться к базе данных с 1-й попытки
-	db.TryToConnect(1)
-	// Выполнить миграции базы данных
-	db.MigrateUp("./migrations")
+// NewFromConfig создает приложение в соответствии с прочитанной конфигурацией и запускает его фоновые обработчики.
+func NewFromConfig() *App {
+	// Инициализировать ключ подписи токенов пользователей
+	auth.Init()
+	// Задать обратные прокси, которым доверяется адрес клиента в X-Real-IP
+	if err := analytics.SetTrustedProxies(config.Params.TrustedProxies); err != nil {
+		log.Fatal().Err(err).Msg("Cannot parse trusted proxies")
+	}
 
-	// Загрузить данные из базы данных или из файлового хранилища в storage
-	err := LoadDataToStorage(context.Background())
+	// Создать хранилище и загрузить в него данные
+	repo, err := NewRepository(context.Background())
 	if err != nil {
-		log.Warn().Err(err).Msg("Cannot load data to storage")
+		log.Fatal().Err(err).Msg("Cannot create storage")
+	}
+	// Метрики хранилища
+	if err := metrics.RegisterRepository(repo); err != nil {
+		log.Warn().Err(err).Msg("Cannot register storage metrics")
 	}
 	// Печать содержимого хранилища в лог
-	storage.PrintContent(0)
-}
+	if m, ok := repo.(interface{ Map() *storage.DoubleMap }); ok {
+		m.Map().PrintContent(0)
+	}
 
-// Получить короткий URL из shortID
-func ShortURL(shortID string) string {
-	return config.Params.BaseURL + "/" + shortID
-}
+	// Выбрать генератор коротких id
+	generator, err := NewGenerator(repo)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Cannot create short ID generator")
+	}
 
-// Получить shortID из shortURL
-func ShortID(shortURL string) string {
-	return strings.TrimPrefix(shortURL, config.Params.BaseURL+"/")
+	a := New(repo, generator, config.Params.BaseURL)
+	a.Start()
+	return a
 }
 
-// LoadDataToStorage - загружает данные из базы данных или из файлового хранилища в storage.
-// Если указана DatabaseDSN в конфигурации, то загрузить данные из базы данных.
-// В противном случае, если указан FileStoragePath в конфигурации, то загрузить данные из файлового хранилища.
-// Если ни один из параметров не указан, то ничего не загружать.
+// NewRepository - создает хранилище в соответствии с конфигурацией.
+// Если указана DatabaseDSN в конфигурации, то создается хранилище в базе данных:
+// в SQLite для DSN вида sqlite:///path/db.sqlite, в PostgreSQL для остальных DSN.
+// В противном случае, если указан FileStoragePath в конфигурации, то создается файловое хранилище.
+// Если ни один из параметров не указан, то данные хранятся только в памяти.
+// В режиме двойной записи (DualWrite) изменения повторяются в файловом хранилище (см. storage.DualWrite).
 // Параметры:
 // - ctx - контекст
-// Возвращает ошибку, если загрузка данных не удалась.
-func LoadDataToStorage(ctx context.Context) (err error) {
+// Возвращает ошибку, если хранилище не удалось открыть.
+func NewRepository(ctx context.Context) (repository.Repository, error) {
+	if config.Params.DualWrite {
+		return newDualWriteRepository(ctx)
+	}
+	return newSingleRepository(ctx)
+}
+
+// newDualWriteRepository - создает хранилище с двойной записью: основное хранилище - база данных,
+// вторичное - файловое хранилище.
+func newDualWriteRepository(ctx context.Context) (repository.Repository, error) {
+	if config.Params.DatabaseDSN == "" || config.Params.FileStoragePath == "" {
+		return nil, errors.New("dual write requires both database DSN (-d) and file storage path (-f)")
+	}
+	primary, err := newSingleRepository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	secondary, err := newFileRepository()
+	if err != nil {
+		primary.Close()
+		return nil, err
+	}
+	log.Info().Msg("Dual write: database is primary, file storage is secondary")
+	return storage.NewDualWrite(primary, secondary), nil
+}
+
+// newSingleRepository - создает одно хранилище в соответствии с конфигурацией (см. NewRepository).
+func newSingleRepository(ctx context.Context) (repository.Repository, error) {
+	sqlitePath, isSQLite := db.SQLitePath(config.Params.DatabaseDSN)
 	switch {
+	case isSQLite:
+		return newSQLiteRepository(ctx, sqlitePath)
 	case config.Params.DatabaseDSN != "":
-		err = LoadDBDataToStorage(ctx)
+		return newPostgresRepository(ctx)
 	case config.Params.FileStoragePath != "":
-		err = LoadFileDataToStorage()
+		return newFileRepository()
 	default:
-		log.Info().Msg("LoadData(). No persistent data store specified")
+		log.Info().Msg("NewRepository(). No persistent data store specified")
+		return storage.NewMemory(), nil
 	}
-	return err
 }
 
-// AddToStore сохраняет короткий и оригинальный URL в базу данных или в файловое хранилище.
-// Если указана DatabaseDSN в конфигурации, то сохранять данные в базу данных.
-// В противном случае, если указан FileStoragePath в конфигурации, то сохранять данные в файловое хранилище.
-// Если ни один из параметров не указан, то ничего не сохранять.
-// Параметры:
-// - ctx - контекст
-// - shortID - короткий ID
-// - originalURL - оригинальный URL
-// Возвращает ошибку, если сохранение не удалось.
-func AddToStore(ctx context.Context, shortID, originalURL string) (err error) {
-	switch {
-	case config.Params.DatabaseDSN != "":
-		// сохранить shortID и оригинальный URL в базу данных
-		err = db.Store(ctx, shortID, originalURL)
-		if err != nil {
-			log.Warn().Err(err).Msg("Cannot save shortID in the database")
-			return err
+// newPostgresRepository - создает пул соединений с базой данных и хранилище
+// в режиме кеша config.Params.CacheMode:
+// - preload - все записи загружаются в кеш в памяти при запуске;
+// - lru - записи читаются из базы данных через кеш емкостью config.Params.CacheSize записей.
+// Соединение отслеживает db.Supervisor. При каждом подключении выполняются миграции.
+// Если база данных недоступна, то хранилище все равно создается, и сервер может быть запущен.
+// Пока соединения нет, запись в хранилище завершается ошибкой repository.ErrUnavailable.
+func newPostgresRepository(ctx context.Context) (repository.Repository, error) {
+	mode := config.Params.CacheMode
+	if mode != "" && mode != "preload" && mode != "lru" {
+		return nil, fmt.Errorf("unknown cache mode %q", mode)
+	}
+	conn, err := db.OpenPostgres(config.Params.DatabaseDSN, db.PoolConfig{
+		MaxOpenConns:    config.Params.DBMaxOpenConns,
+		MaxIdleConns:    config.Params.DBMaxIdleConns,
+		ConnMaxLifetime: config.Params.DBConnMaxLifetime,
+		ConnMaxIdleTime: config.Params.DBConnMaxIdleTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+	supervisor := db.NewSupervisor(conn, func() {
+		// Выполнить миграции базы данных
+		if err := db.MigrateUp(conn, migrations.FS); err != nil {
+			log.Warn().Err(err).Msg("Cannot migrate DB")
 		}
-	case config.Params.FileStoragePath != "":
-		// сохранить shortID и оригинальный URL в файловое хранилище
-		err := filestorage.Store(ShortURL(shortID), originalURL)
-		if err != nil {
-			log.Warn().Err(err).Msg("Cannot save shortened url in the filestorage")
-			return err
+	})
+	supervisor.Start()
+
+	// Кеши экземпляров сервиса согласуются через уведомления об изменениях таблицы urls
+	if mode == "lru" {
+		repo := db.NewPostgresReadThrough(conn, config.Params.CacheSize)
+		repo.SetSupervisor(supervisor)
+		repo.Listen(config.Params.DatabaseDSN)
+		return repo, nil
+	}
+	repo := db.NewPostgres(conn)
+	repo.SetSupervisor(supervisor)
+	repo.Listen(config.Params.DatabaseDSN)
+	// Загрузить записи в кеш. Если база данных недоступна, то слушатель загрузит их после подключения.
+	if supervisor.Connected() {
+		if err := repo.LoadCache(ctx); err != nil {
+			log.Warn().Err(err).Msg("Cannot load data from DB")
 		}
-	default:
-		log.Info().Msg("AddToStore(). No persistent data store specified")
 	}
-	return nil
+	return repo, nil
+}
+
+// newFileRepository - открывает файловое хранилище с политикой сброса на диск config.Params.FileSync.
+// Если файл хранилища используется другим процессом, возвращает ошибку filestorage.ErrLocked.
+func newFileRepository() (repository.Repository, error) {
+	policy, err := filestorage.ParseSyncPolicy(config.Params.FileSync)
+	if err != nil {
+		return nil, err
+	}
+	return filestorage.Open(config.Params.FileStoragePath, filestorage.Options{
+		Sync:         policy,
+		SyncInterval: config.Params.FileSyncInterval,
+		ReadOnly:     config.Params.FileReadOnly,
+	})
+}
+
+// newSQLiteRepository - открывает базу данных SQLite, выполняет миграции и загружает записи в кеш.
+func newSQLiteRepository(ctx context.Context, path string) (repository.Repository, error) {
+	conn, err := db.OpenSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.MigrateUp(conn, migrations.FS); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	repo := db.NewSQLite(conn)
+	if err := repo.LoadCache(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return repo, nil
+}
+
+// ShortURL - получить короткий URL из shortID
+func (a *App) ShortURL(shortID string) string {
+	return a.BaseURL + "/" + shortID
+}
+
+// ShortID - получить shortID из shortURL
+func (a *App) ShortID(shortURL string) string {
+	return strings.TrimPrefix(shortURL, a.BaseURL+"/")
 }
@@ -0,0 +1,94 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vadim-ivlev/url-shortener/internal/analytics"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+	"github.com/vadim-ivlev/url-shortener/internal/shortener"
+	"github.com/vadim-ivlev/url-shortener/internal/storage"
+)
+
+// closeRecorder - хранилище в памяти, запоминающее вызов Close.
+type closeRecorder struct {
+	*storage.Memory
+	closed atomic.Bool
+}
+
+func (r *closeRecorder) Close() error {
+	r.closed.Store(true)
+	return nil
+}
+
+// blockingRecorder - хранилище, удаление в котором ждет закрытия release.
+type blockingRecorder struct {
+	closeRecorder
+	release chan struct{}
+}
+
+func (r *blockingRecorder) Delete(ctx context.Context, userID string, shortIDs []string) error {
+	<-r.release
+	return r.Memory.Delete(ctx, userID, shortIDs)
+}
+
+// TestShutdown - остановка выполняет поставленные в очередь удаления и закрывает хранилище.
+func TestShutdown(t *testing.T) {
+	ctx := context.Background()
+	repo := &closeRecorder{Memory: storage.NewMemory()}
+	_, _, err := repo.Save(ctx, repository.Record{ShortID: "AAAA", OriginalURL: "https://a.com", UserID: "user-1"})
+	assert.NoError(t, err)
+
+	a := New(repo, shortener.HashGenerator{}, "http://localhost:8080")
+	a.Start()
+	assert.NoError(t, a.QueueDelete(ctx, "user-1", []string{"AAAA"}))
+
+	assert.NoError(t, a.Shutdown(ctx))
+	assert.True(t, repo.closed.Load())
+	record, err := repo.Get(ctx, "AAAA")
+	assert.NoError(t, err)
+	assert.True(t, record.IsDeleted)
+}
+
+// TestShutdownTimeout - хранилище не закрывается, пока фоновые обработчики пишут в него.
+func TestShutdownTimeout(t *testing.T) {
+	repo := &blockingRecorder{closeRecorder: closeRecorder{Memory: storage.NewMemory()}, release: make(chan struct{})}
+	a := New(repo, shortener.HashGenerator{}, "http://localhost:8080")
+	a.Start()
+	assert.NoError(t, a.QueueDelete(context.Background(), "user-1", []string{"AAAA"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, a.Shutdown(ctx), context.DeadlineExceeded)
+	assert.False(t, repo.closed.Load())
+	close(repo.release)
+}
+
+// TestSendAfterStop - задачи, поставленные во время и после остановки, отклоняются без паники.
+func TestSendAfterStop(t *testing.T) {
+	a := New(storage.NewMemory(), shortener.HashGenerator{}, "http://localhost:8080")
+	a.Start()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				a.RecordClick(analytics.Click{ShortID: "AAAA"})
+				a.QueueDelete(context.Background(), "user-1", []string{"AAAA"})
+			}
+		}()
+	}
+	a.Stop()
+	wg.Wait()
+
+	assert.ErrorIs(t, a.QueueDelete(context.Background(), "user-1", []string{"AAAA"}), ErrDeleterStopped)
+	a.RecordClick(analytics.Click{ShortID: "AAAA"})
+	// Повторная остановка ничего не делает
+	a.Stop()
+}
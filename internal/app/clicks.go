@@ -0,0 +1,114 @@
+// Description: Асинхронная запись переходов по коротким URL.
+// Обработчик перенаправления отправляет событие в буферизованный канал и не ждет записи.
+// Фоновый обработчик сохраняет события пачками в хранилище.
+
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vadim-ivlev/url-shortener/internal/analytics"
+)
+
+// Параметры фоновой записи переходов
+const (
+	// clickQueueSize - емкость буфера событий. При переполнении события отбрасываются.
+	clickQueueSize = 4096
+	// clickBatchSize - количество событий, при накоплении которого пачка записывается сразу
+	clickBatchSize = 500
+	// clickFlushInterval - период записи неполной пачки
+	clickFlushInterval = time.Second
+)
+
+// StartClickRecorder запускает фоновую запись переходов.
+func (a *App) StartClickRecorder() {
+	a.clickMutex.Lock()
+	defer a.clickMutex.Unlock()
+
+	a.clickQueue = make(chan analytics.Click, clickQueueSize)
+	a.clickClosed = false
+	a.clickRecorderDone = make(chan struct{})
+	go a.runClickRecorder(a.clickQueue, a.clickRecorderDone)
+}
+
+// StopClickRecorder закрывает буфер событий и дожидается записи уже поставленных в него событий.
+// События, поступившие после закрытия, отбрасываются.
+func (a *App) StopClickRecorder() {
+	a.clickMutex.Lock()
+	if a.clickQueue == nil || a.clickClosed {
+		a.clickMutex.Unlock()
+		return
+	}
+	close(a.clickQueue)
+	a.clickClosed = true
+	done := a.clickRecorderDone
+	a.clickMutex.Unlock()
+
+	<-done
+}
+
+// RecordClick ставит событие перехода в очередь на запись, не блокируя вызывающего.
+// Если очередь переполнена, не запущена или уже закрыта, событие отбрасывается.
+func (a *App) RecordClick(click analytics.Click) {
+	a.clickMutex.RLock()
+	defer a.clickMutex.RUnlock()
+
+	if a.clickQueue == nil || a.clickClosed {
+		log.Warn().Str("short_id", click.ShortID).Msg("RecordClick(). Click queue is stopped, click dropped")
+		return
+	}
+	select {
+	case a.clickQueue <- click:
+	default:
+		log.Warn().Str("short_id", click.ShortID).Msg("RecordClick(). Click queue is full, click dropped")
+	}
+}
+
+// runClickRecorder - читает события из очереди и записывает их пачками.
+// Завершается после закрытия очереди, записав оставшуюся пачку.
+func (a *App) runClickRecorder(queue <-chan analytics.Click, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(clickFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]analytics.Click, 0, clickBatchSize)
+	for {
+		select {
+		case click, ok := <-queue:
+			if !ok {
+				a.storeClicks(batch)
+				return
+			}
+			batch = append(batch, click)
+			if len(batch) >= clickBatchSize {
+				a.storeClicks(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			a.storeClicks(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// storeClicks - сохраняет пачку событий в хранилище.
+func (a *App) storeClicks(clicks []analytics.Click) {
+	if len(clicks) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := a.Repo.SaveClicks(ctx, clicks); err != nil {
+		log.Error().Err(err).Int("count", len(clicks)).Msg("storeClicks(). Cannot persist clicks")
+	}
+}
+
+// ClickStats - возвращает статистику переходов по короткому URL.
+// В режиме базы данных статистика учитывает переходы на всех экземплярах сервиса.
+func (a *App) ClickStats(ctx context.Context, shortID string) (analytics.Stats, error) {
+	return a.Repo.ClickStats(ctx, shortID)
+}
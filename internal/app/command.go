@@ -0,0 +1,56 @@
+// Description: Подкоманды сервиса, которые выполняются вместо запуска сервера.
+// Использование:
+//   shortener [параметры] migrate ...     - управление миграциями базы данных (см. migrate.go)
+//   shortener [параметры] upgrade-file    - перевод файлового хранилища (-f) в текущий формат записей
+//   shortener [параметры] export FILE     - выгрузка записей хранилища в файл (см. transfer.go)
+//   shortener [параметры] import FILE     - загрузка записей из файла в хранилище
+
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/vadim-ivlev/url-shortener/internal/config"
+	"github.com/vadim-ivlev/url-shortener/internal/filestorage"
+)
+
+// ErrUsage - неверные аргументы подкоманды.
+var ErrUsage = errors.New("usage: migrate up | migrate down [N] | migrate status | upgrade-file | export FILE | import FILE")
+
+// RunCommand выполняет подкоманду args и выводит результат в out.
+// Поддерживаются подкоманды migrate, upgrade-file, export и import.
+func RunCommand(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return ErrUsage
+	}
+	switch args[0] {
+	case "migrate":
+		return runMigrate(args[1:], out)
+	case "upgrade-file":
+		if len(args) > 1 {
+			return ErrUsage
+		}
+		return runUpgradeFile(out)
+	case "export":
+		return runExport(args[1:], out)
+	case "import":
+		return runImport(args[1:], out)
+	default:
+		return ErrUsage
+	}
+}
+
+// runUpgradeFile - переводит файловое хранилище config.Params.FileStoragePath в текущий формат записей.
+func runUpgradeFile(out io.Writer) error {
+	if config.Params.FileStoragePath == "" {
+		return errors.New("file storage path is not specified")
+	}
+	n, err := filestorage.Upgrade(config.Params.FileStoragePath)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s: %d records upgraded\n", config.Params.FileStoragePath, n)
+	return nil
+}
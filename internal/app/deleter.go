@@ -0,0 +1,132 @@
+// Description: Асинхронное удаление коротких URL пользователей.
+// Запросы на удаление от всех обработчиков поступают в один канал (fan-in).
+// Фоновый обработчик накапливает короткие id и помечает их удаленными пачками:
+// когда пачка заполнена или по таймеру.
+
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Параметры фонового удаления
+const (
+	// deleteQueueSize - емкость очереди запросов на удаление
+	deleteQueueSize = 1024
+	// deleteBatchSize - количество коротких id, при накоплении которого пачка удаляется сразу
+	deleteBatchSize = 100
+	// deleteFlushInterval - период удаления неполной пачки
+	deleteFlushInterval = time.Second
+)
+
+// ErrDeleterStopped - очередь удаления не запущена или уже остановлена.
+var ErrDeleterStopped = errors.New("delete queue is not running")
+
+// deleteTask - запрос пользователя на удаление коротких URL.
+type deleteTask struct {
+	userID   string
+	shortIDs []string
+}
+
+// StartDeleter запускает фоновый обработчик удаления коротких URL.
+func (a *App) StartDeleter() {
+	a.deleteMutex.Lock()
+	defer a.deleteMutex.Unlock()
+
+	a.deleteQueue = make(chan deleteTask, deleteQueueSize)
+	a.deleteClosed = false
+	a.deleterDone = make(chan struct{})
+	go a.runDeleter(a.deleteQueue, a.deleterDone)
+}
+
+// StopDeleter закрывает очередь удаления и дожидается обработки уже поставленных в нее запросов.
+// Запросы, поступившие после закрытия, отклоняются с ошибкой ErrDeleterStopped.
+func (a *App) StopDeleter() {
+	// Закрытие ждет, пока отправители, ожидающие места в очереди, не поставят в нее свои запросы
+	a.deleteMutex.Lock()
+	if a.deleteQueue == nil || a.deleteClosed {
+		a.deleteMutex.Unlock()
+		return
+	}
+	close(a.deleteQueue)
+	a.deleteClosed = true
+	done := a.deleterDone
+	a.deleteMutex.Unlock()
+
+	<-done
+}
+
+// QueueDelete ставит в очередь запрос пользователя userID на удаление коротких URL с id shortIDs.
+// Удаляются только короткие URL, принадлежащие пользователю, остальные id игнорируются.
+// Если очередь не запущена или уже закрыта, возвращает ErrDeleterStopped.
+// Параметры:
+// - ctx - контекст, ограничивающий ожидание места в очереди
+// - userID - идентификатор пользователя
+// - shortIDs - короткие id
+func (a *App) QueueDelete(ctx context.Context, userID string, shortIDs []string) error {
+	a.deleteMutex.RLock()
+	defer a.deleteMutex.RUnlock()
+
+	if a.deleteQueue == nil || a.deleteClosed {
+		return ErrDeleterStopped
+	}
+	select {
+	case a.deleteQueue <- deleteTask{userID: userID, shortIDs: shortIDs}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runDeleter - читает запросы из очереди и удаляет короткие URL пачками.
+// Пачка группирует короткие id по пользователям.
+// Завершается после закрытия очереди, удалив оставшуюся пачку.
+func (a *App) runDeleter(queue <-chan deleteTask, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(deleteFlushInterval)
+	defer ticker.Stop()
+
+	batch := make(map[string][]string)
+	size := 0
+	flush := func() {
+		a.deleteBatch(batch)
+		batch = make(map[string][]string)
+		size = 0
+	}
+	for {
+		select {
+		case task, ok := <-queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch[task.userID] = append(batch[task.userID], task.shortIDs...)
+			size += len(task.shortIDs)
+			if size >= deleteBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// deleteBatch - помечает удаленными короткие URL пачки в хранилище.
+// batch - короткие id, сгруппированные по пользователям.
+func (a *App) deleteBatch(batch map[string][]string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for userID, shortIDs := range batch {
+		if err := a.Repo.Delete(ctx, userID, shortIDs); err != nil {
+			log.Error().Err(err).Int("count", len(shortIDs)).Msg("deleteBatch(). Cannot persist deleted URLs")
+			continue
+		}
+		log.Info().Int("count", len(shortIDs)).Msg("URLs deleted")
+	}
+}
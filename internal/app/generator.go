@@ -0,0 +1,39 @@
+// Description: Выбор стратегии генерации коротких id в соответствии с конфигурацией.
+
+package app
+
+import (
+	"fmt"
+
+	"github.com/vadim-ivlev/url-shortener/internal/config"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+	"github.com/vadim-ivlev/url-shortener/internal/shortener"
+)
+
+// NewGenerator - создает генератор коротких id, указанный в config.Params.IDGenerator.
+// Генераторы на основе счетчика используют счетчик хранилища repo.
+// Возвращает ошибку, если генератор неизвестен или его параметры некорректны.
+func NewGenerator(repo repository.Repository) (shortener.Generator, error) {
+	switch config.Params.IDGenerator {
+	case "", "hash":
+		return shortener.HashGenerator{}, nil
+	case "counter":
+		return shortener.CounterGenerator{Counter: newCounter(repo)}, nil
+	case "random":
+		return shortener.NewRandomGenerator(config.Params.IDLength, config.Params.IDAlphabet)
+	case "sqids":
+		return shortener.NewSqidsGenerator(newCounter(repo), config.Params.IDLength, config.Params.IDAlphabet)
+	default:
+		return nil, fmt.Errorf("unknown short ID generator %q", config.Params.IDGenerator)
+	}
+}
+
+// newCounter - возвращает счетчик для генераторов counter и sqids.
+// Если хранилище предоставляет счетчик (последовательность в базе данных, файл счетчика),
+// то используется он. В противном случае используется счетчик в памяти.
+func newCounter(repo repository.Repository) shortener.Counter {
+	if seq, ok := repo.(repository.Sequencer); ok {
+		return shortener.CounterFunc(seq.NextSequence)
+	}
+	return &shortener.MemoryCounter{}
+}
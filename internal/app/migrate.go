@@ -0,0 +1,96 @@
+// Description: Подкоманда migrate управляет миграциями базы данных, указанной параметром -d (DATABASE_DSN).
+// Использование:
+//   shortener [параметры] migrate up        - применить непримененные миграции
+//   shortener [параметры] migrate down [N]  - откатить N последних миграций (по умолчанию 1)
+//   shortener [параметры] migrate status    - вывести состояние миграций
+
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/vadim-ivlev/url-shortener/internal/config"
+	"github.com/vadim-ivlev/url-shortener/internal/db"
+	"github.com/vadim-ivlev/url-shortener/migrations"
+)
+
+// runMigrate выполняет подкоманду migrate с аргументами args и выводит состояние миграций в out.
+func runMigrate(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return ErrUsage
+	}
+
+	n := 1
+	switch args[0] {
+	case "up", "status":
+		if len(args) > 1 {
+			return ErrUsage
+		}
+	case "down":
+		if len(args) > 2 {
+			return ErrUsage
+		}
+		if len(args) == 2 {
+			var err error
+			if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+				return ErrUsage
+			}
+		}
+	default:
+		return ErrUsage
+	}
+
+	conn, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	switch args[0] {
+	case "up":
+		err = db.MigrateUp(conn, migrations.FS)
+	case "down":
+		err = db.MigrateDown(conn, migrations.FS, n)
+	}
+	if err != nil {
+		return err
+	}
+	return printMigrationStatus(conn, out)
+}
+
+// openDatabase - открывает базу данных, указанную в конфигурации, без выполнения миграций.
+func openDatabase() (*sqlx.DB, error) {
+	if config.Params.DatabaseDSN == "" {
+		return nil, errors.New("database DSN is not specified")
+	}
+	if path, ok := db.SQLitePath(config.Params.DatabaseDSN); ok {
+		return db.OpenSQLite(path)
+	}
+	return db.CreatePool(config.Params.DatabaseDSN)
+}
+
+// printMigrationStatus - выводит в out состояние миграций.
+func printMigrationStatus(conn *sqlx.DB, out io.Writer) error {
+	states, err := db.MigrationStatus(conn, migrations.FS)
+	if err != nil {
+		return err
+	}
+	for _, s := range states {
+		status := "pending"
+		switch {
+		case s.Missing:
+			status = "missing"
+		case s.Modified:
+			status = "modified"
+		case s.Applied:
+			status = "applied " + s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(out, "%02d_%-20s %s\n", s.Version, s.Name, status)
+	}
+	return nil
+}
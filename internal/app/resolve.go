@@ -0,0 +1,30 @@
+// Description: Получение оригинального URL по короткому id для перехода по короткому URL.
+
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/vadim-ivlev/url-shortener/internal/metrics"
+)
+
+// ErrGone - ошибка, возвращаемая, если короткий URL удален владельцем или истек срок его действия.
+var ErrGone = errors.New("URL deleted or expired")
+
+// Resolve - возвращает оригинальный URL короткого id shortID для перехода по короткому URL
+// и учитывает переход в метриках. Переход в статистике записывается вызывающим (см. RecordClick),
+// так как данные о клиенте зависят от протокола.
+// Возвращает repository.ErrNotFound, если короткого id нет, и ErrGone, если он удален или просрочен.
+func (a *App) Resolve(ctx context.Context, shortID string) (originalURL string, err error) {
+	record, err := a.Repo.Get(ctx, shortID)
+	if err != nil {
+		return "", err
+	}
+	if record.IsDeleted || record.IsExpired(time.Now()) {
+		return "", ErrGone
+	}
+	metrics.ObserveRedirect()
+	return record.OriginalURL, nil
+}
@@ -0,0 +1,58 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vadim-ivlev/url-shortener/internal/metrics"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+	"github.com/vadim-ivlev/url-shortener/internal/shortener"
+	"github.com/vadim-ivlev/url-shortener/internal/storage"
+)
+
+// counterValue - возвращает значение счетчика name реестра метрик с меткой result, если она задана.
+func counterValue(t *testing.T, name, result string) float64 {
+	families, err := metrics.Registry.Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			if result == "" || (len(m.GetLabel()) > 0 && m.GetLabel()[0].GetValue() == result) {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+// TestResolveMetrics - сокращение URL по одному и пачкой и переходы учитываются в метриках.
+func TestResolveMetrics(t *testing.T) {
+	ctx := context.Background()
+	a := New(storage.NewMemory(), shortener.HashGenerator{}, "http://localhost:8080")
+	created := counterValue(t, "shortener_shorten_requests_total", "new")
+	conflicts := counterValue(t, "shortener_shorten_requests_total", "conflict")
+	redirects := counterValue(t, "shortener_redirects_total", "")
+
+	shortURL, _, err := a.Shorten(ctx, "https://a.com", "", time.Time{})
+	assert.NoError(t, err)
+	_, _, err = a.Shorten(ctx, "https://a.com", "", time.Time{})
+	assert.NoError(t, err)
+	_, err = a.ShortenBatch(ctx, []BatchItem{{OriginalURL: "https://a.com"}, {OriginalURL: "https://b.com"}})
+	assert.NoError(t, err)
+	assert.Equal(t, created+2, counterValue(t, "shortener_shorten_requests_total", "new"))
+	assert.Equal(t, conflicts+2, counterValue(t, "shortener_shorten_requests_total", "conflict"))
+
+	originalURL, err := a.Resolve(ctx, a.ShortID(shortURL))
+	assert.NoError(t, err)
+	assert.Equal(t, "https://a.com", originalURL)
+	_, err = a.Resolve(ctx, "missing")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	assert.NoError(t, a.Repo.Delete(ctx, "", []string{a.ShortID(shortURL)}))
+	_, err = a.Resolve(ctx, a.ShortID(shortURL))
+	assert.ErrorIs(t, err, ErrGone)
+	assert.Equal(t, redirects+1, counterValue(t, "shortener_redirects_total", ""))
+}
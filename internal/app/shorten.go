@@ -0,0 +1,274 @@
+// Description: Сохранение оригинальных URL под сгенерированными короткими id или под алиасами.
+
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vadim-ivlev/url-shortener/internal/auth"
+	"github.com/vadim-ivlev/url-shortener/internal/metrics"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+)
+
+// maxCollisionAttempts - максимальное количество попыток сгенерировать уникальный короткий id.
+const maxCollisionAttempts = 10
+
+// ErrTooManyCollisions - ошибка, возвращаемая, если не удалось подобрать свободный короткий id.
+var ErrTooManyCollisions = errors.New("cannot generate unique short id: too many collisions")
+
+// ErrAliasTaken - ошибка, возвращаемая, если алиас уже занят другим URL.
+var ErrAliasTaken = errors.New("alias is already taken")
+
+// ErrURLShortened - ошибка, возвращаемая, если URL, сохраняемый под алиасом, уже сокращен под другим коротким id.
+// Возвращается обернутой в сообщение с существующим коротким URL.
+var ErrURLShortened = errors.New("original url is already shortened")
+
+// Shorten - сохраняет оригинальный URL под алиасом, если он задан,
+// или под сгенерированным коротким id в противном случае.
+// Алиас должен быть предварительно проверен shortener.ValidateAlias.
+// Владельцем нового короткого URL становится пользователь из контекста (см. auth.UserID).
+// Срок действия и владелец существующего короткого URL не меняются.
+// Параметры:
+// ctx - контекст
+// originalURL - оригинальный URL.
+// alias - короткий id, выбранный пользователем, или пустая строка.
+// expiresAt - момент, после которого короткий URL перестает работать. Нулевое значение - бессрочно.
+// Возвращает:
+// shortURL - короткий URL
+// aNewOne -  флаг, новый ли это короткий URL. Если true, то это новый короткий URL.
+// err - ошибка. ErrAliasTaken, если алиас уже занят другим URL.
+// ErrURLShortened, если URL уже сокращен под другим коротким id.
+// Если URL одновременно сохранен другим экземпляром сервиса, то возвращается его короткий URL,
+// aNewOne == false и ошибка, для которой errors.Is(err, repository.ErrConflict).
+func (a *App) Shorten(ctx context.Context, originalURL, alias string, expiresAt time.Time) (shortURL string, aNewOne bool, err error) {
+	userID, _ := auth.UserID(ctx)
+	record := repository.Record{OriginalURL: originalURL, UserID: userID, ExpiresAt: expiresAt}
+	if alias != "" {
+		shortURL, aNewOne, err = a.saveWithAlias(ctx, alias, record)
+	} else {
+		shortURL, aNewOne, err = a.generateAndSave(ctx, record)
+	}
+	if err == nil || errors.Is(err, repository.ErrConflict) {
+		metrics.ObserveShorten(aNewOne)
+	}
+	return shortURL, aNewOne, err
+}
+
+// ParseExpiry - определяет срок действия короткого URL по необязательным полям запроса HTTP или gRPC.
+// Можно указать только одно из полей. Если не указано ни одно, возвращается нулевое время (бессрочно).
+// Параметры:
+// expiresIn - срок действия в секундах, должен быть положительным.
+// expiresAt - момент окончания действия, должен быть в будущем.
+func ParseExpiry(expiresIn *int64, expiresAt *time.Time) (time.Time, error) {
+	switch {
+	case expiresIn != nil && expiresAt != nil:
+		return time.Time{}, errors.New("only one of expires_in and expires_at may be specified")
+	case expiresIn != nil:
+		if *expiresIn <= 0 {
+			return time.Time{}, errors.New("expires_in must be a positive number of seconds")
+		}
+		return time.Now().Add(time.Duration(*expiresIn) * time.Second), nil
+	case expiresAt != nil:
+		if !expiresAt.After(time.Now()) {
+			return time.Time{}, errors.New("expires_at must be in the future")
+		}
+		return *expiresAt, nil
+	default:
+		return time.Time{}, nil
+	}
+}
+
+// generateAndSave - генерирует короткий id и сохраняет запись в хранилище.
+// Если сгенерированный id уже занят другим URL (коллизия),
+// то генерируется новый id со следующим номером попытки.
+func (a *App) generateAndSave(ctx context.Context, record repository.Record) (shortURL string, aNewOne bool, err error) {
+	for attempt := 0; attempt < maxCollisionAttempts; attempt++ {
+		// Сгенерировать короткий id
+		record.ShortID, err = a.Generator.Generate(ctx, record.OriginalURL, attempt)
+		if err != nil {
+			return "", false, err
+		}
+		saved, aNewOne, err := a.Repo.Save(ctx, record)
+		if errors.Is(err, repository.ErrShortIDTaken) {
+			continue
+		}
+		if err != nil {
+			return a.conflictShortURL(err), false, err
+		}
+		return a.ShortURL(saved.ShortID), aNewOne, nil
+	}
+	return "", false, ErrTooManyCollisions
+}
+
+// saveWithAlias - сохраняет запись под выбранным пользователем коротким id (алиасом).
+// Если оригинальный URL уже был сокращен под этим алиасом, то возвращается существующий короткий URL и aNewOne == false.
+// Если оригинальный URL уже сокращен под другим коротким id, то возвращается ошибка ErrURLShortened,
+// а алиас не сохраняется.
+func (a *App) saveWithAlias(ctx context.Context, alias string, record repository.Record) (shortURL string, aNewOne bool, err error) {
+	record.ShortID = alias
+	saved, aNewOne, err := a.Repo.Save(ctx, record)
+	if errors.Is(err, repository.ErrShortIDTaken) {
+		return "", false, ErrAliasTaken
+	}
+	var conflict *repository.ConflictError
+	if errors.As(err, &conflict) {
+		saved = conflict.Existing
+	} else if err != nil {
+		return "", false, err
+	}
+	if saved.ShortID != alias {
+		return "", false, urlShortenedError(a.ShortURL(saved.ShortID))
+	}
+	return a.ShortURL(saved.ShortID), aNewOne, err
+}
+
+// urlShortenedError - возвращает ErrURLShortened с существующим коротким URL в сообщении.
+func urlShortenedError(shortURL string) error {
+	return fmt.Errorf("%w as %s", ErrURLShortened, shortURL)
+}
+
+// conflictShortURL - возвращает короткий URL ранее сохраненной записи, если err - *repository.ConflictError,
+// и пустую строку в противном случае.
+func (a *App) conflictShortURL(err error) string {
+	var conflict *repository.ConflictError
+	if errors.As(err, &conflict) {
+		return a.ShortURL(conflict.Existing.ShortID)
+	}
+	return ""
+}
+
+// BatchItem - URL пачки для сокращения.
+type BatchItem struct {
+	// OriginalURL - оригинальный URL
+	OriginalURL string
+	// Alias - короткий id, выбранный пользователем, или пустая строка.
+	// Должен быть предварительно проверен shortener.ValidateAlias.
+	Alias string
+}
+
+// BatchResult - результат сокращения URL пачки.
+type BatchResult struct {
+	// ShortURL - короткий URL
+	ShortURL string
+	// IsNew - новый ли это короткий URL
+	IsNew bool
+	// Err - ErrAliasTaken, если алиас занят другим URL,
+	// или ErrURLShortened, если URL с алиасом уже сокращен под другим коротким id
+	Err error
+}
+
+// ShortenBatch - сохраняет пачку URL так же, как Shorten, но записи сохраняются в хранилище пачкой
+// (в базе данных - в одной транзакции, в файле - одной операцией записи).
+// Результаты возвращаются в порядке items.
+// Алиасы проверяются до сохранения. Если хотя бы один алиас занят, то ничего не сохраняется,
+// возвращается ошибка ErrAliasTaken, а у результатов с занятыми алиасами Err == ErrAliasTaken.
+// Так же, с ошибкой ErrURLShortened, пачка не сохраняется, если URL с алиасом уже сокращен
+// под другим коротким id или встречается в пачке раньше с другим алиасом или без него.
+// Записи, сгенерированные id которых оказались заняты другими URL (коллизии),
+// сохраняются следующей пачкой с id, сгенерированными со следующим номером попытки.
+func (a *App) ShortenBatch(ctx context.Context, items []BatchItem) (results []BatchResult, err error) {
+	userID, _ := auth.UserID(ctx)
+	results = make([]BatchResult, len(items))
+
+	// Проверить алиасы, в том числе повторяющиеся в пачке с разными URL,
+	// и URL с алиасами, уже сокращенные под другими короткими id
+	aliases := make(map[string]string, len(items))
+	// Алиас первой записи пачки с этим URL. Пустая строка - генерируемый id.
+	urls := make(map[string]string, len(items))
+	for i, item := range items {
+		first, seen := urls[item.OriginalURL]
+		if !seen {
+			urls[item.OriginalURL] = item.Alias
+		}
+		if item.Alias == "" {
+			continue
+		}
+		taken := false
+		if url, ok := aliases[item.Alias]; ok {
+			taken = url != item.OriginalURL
+		} else if record, err := a.Repo.Get(ctx, item.Alias); err == nil {
+			taken = record.OriginalURL != item.OriginalURL
+		} else if !errors.Is(err, repository.ErrNotFound) {
+			return nil, err
+		}
+		aliases[item.Alias] = item.OriginalURL
+		if taken {
+			results[i].Err = ErrAliasTaken
+			err = ErrAliasTaken
+			continue
+		}
+		if seen && first != item.Alias {
+			results[i].Err = fmt.Errorf("%w earlier in the batch", ErrURLShortened)
+		} else if record, getErr := a.Repo.GetByOriginal(ctx, item.OriginalURL); getErr == nil {
+			if record.ShortID != item.Alias && !record.IsExpired(time.Now()) {
+				results[i].Err = urlShortenedError(a.ShortURL(record.ShortID))
+			}
+		} else if !errors.Is(getErr, repository.ErrNotFound) {
+			return nil, getErr
+		}
+		if results[i].Err != nil && err == nil {
+			err = ErrURLShortened
+		}
+	}
+	if err != nil {
+		return results, err
+	}
+
+	// Индексы несохраненных записей
+	pending := make([]int, len(items))
+	for i := range items {
+		pending[i] = i
+	}
+	for attempt := 0; attempt < maxCollisionAttempts && len(pending) > 0; attempt++ {
+		records := make([]repository.Record, 0, len(pending))
+		for _, i := range pending {
+			record := repository.Record{ShortID: items[i].Alias, OriginalURL: items[i].OriginalURL, UserID: userID}
+			if record.ShortID == "" {
+				if record.ShortID, err = a.Generator.Generate(ctx, record.OriginalURL, attempt); err != nil {
+					return nil, err
+				}
+			}
+			records = append(records, record)
+		}
+		saved, err := a.Repo.SaveBatch(ctx, records)
+		if err != nil {
+			return nil, err
+		}
+
+		// Ошибка алиаса, возникшая после проверки
+		var aliasErr error
+		collided := pending[:0]
+		for j, i := range pending {
+			switch {
+			case saved[j].Err == nil && items[i].Alias != "" && saved[j].Record.ShortID != items[i].Alias:
+				// URL сократили под другим id после проверки
+				results[i].Err = urlShortenedError(a.ShortURL(saved[j].Record.ShortID))
+				aliasErr = ErrURLShortened
+			case saved[j].Err == nil:
+				results[i] = BatchResult{ShortURL: a.ShortURL(saved[j].Record.ShortID), IsNew: saved[j].IsNew}
+			case !errors.Is(saved[j].Err, repository.ErrShortIDTaken):
+				return nil, saved[j].Err
+			case items[i].Alias != "":
+				// Алиас заняли после проверки
+				results[i].Err = ErrAliasTaken
+				aliasErr = ErrAliasTaken
+			default:
+				collided = append(collided, i)
+			}
+		}
+		if aliasErr != nil {
+			return results, aliasErr
+		}
+		pending = collided
+	}
+	if len(pending) > 0 {
+		return nil, ErrTooManyCollisions
+	}
+	for _, result := range results {
+		metrics.ObserveShorten(result.IsNew)
+	}
+	return results, nil
+}
@@ -0,0 +1,57 @@
+// Description: Фоновое удаление коротких URL с истекшим сроком действия.
+// Просроченные записи удаляются из памяти (в ней остается только пометка об удалении)
+// и помечаются удаленными в базе данных или в файловом хранилище.
+
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// sweepInterval - период поиска просроченных коротких URL.
+const sweepInterval = time.Minute
+
+// StartSweeper запускает периодическое удаление просроченных коротких URL.
+func (a *App) StartSweeper() {
+	a.sweeperStop = make(chan struct{})
+	a.sweeperDone = make(chan struct{})
+	go func(stop <-chan struct{}, done chan<- struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(sweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				a.SweepExpired(context.Background())
+			case <-stop:
+				return
+			}
+		}
+	}(a.sweeperStop, a.sweeperDone)
+}
+
+// StopSweeper останавливает периодическое удаление просроченных коротких URL.
+func (a *App) StopSweeper() {
+	if a.sweeperStop == nil {
+		return
+	}
+	close(a.sweeperStop)
+	<-a.sweeperDone
+	a.sweeperStop = nil
+}
+
+// SweepExpired удаляет короткие URL с истекшим сроком действия.
+// Возвращает количество удаленных коротких URL.
+func (a *App) SweepExpired(ctx context.Context) int {
+	n, err := a.Repo.DeleteExpired(ctx, time.Now())
+	if err != nil {
+		log.Error().Err(err).Msg("SweepExpired(). Cannot persist expired URLs")
+	}
+	if n > 0 {
+		log.Info().Int("count", n).Msg("Expired URLs purged")
+	}
+	return n
+}
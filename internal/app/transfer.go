@@ -0,0 +1,242 @@
+// Description: Подкоманды export и import переносят записи между хранилищами через файл JSON Lines.
+// Хранилище выбирается так же, как при запуске сервера (параметры -d и -f), поэтому перенос
+// из файла в PostgreSQL выглядит так:
+//   shortener -f ./data/file-storage.txt export dump.jsonl
+//   shortener -d postgres://... import dump.jsonl
+// Экспортируются неудаленные и непросроченные записи. Импорт сохраняет записи пачками,
+// пропуская уже сохраненные оригинальные URL, поэтому его можно повторять.
+// После импорта счетчик хранилища продвигается за импортированные id генератора на основе счетчика.
+// Пример строки файла переноса:
+// {"short_id":"4rSPg8ap","original_url":"http://yandex.ru","user_id":"u1","expires_at":"2030-01-01T00:00:00Z"}
+
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vadim-ivlev/url-shortener/internal/config"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+	"github.com/vadim-ivlev/url-shortener/internal/shortener"
+)
+
+// Параметры переноса
+const (
+	// importBatchSize - количество записей, сохраняемых одной пачкой
+	importBatchSize = 1000
+	// exportProgressEvery - период вывода хода экспорта в записях
+	exportProgressEvery = 10000
+)
+
+// transferRecord - строка файла переноса.
+type transferRecord struct {
+	ShortID     string `json:"short_id"`
+	OriginalURL string `json:"original_url"`
+	UserID      string `json:"user_id,omitempty"`
+	// ExpiresAt - срок действия. nil означает бессрочную запись.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// ImportStats - итоги импорта.
+type ImportStats struct {
+	// Read - количество прочитанных записей
+	Read int
+	// Created - количество новых записей
+	Created int
+	// Existing - количество записей, уже сохраненных под тем же коротким id
+	Existing int
+	// Conflicts - количество записей, которые не сохранены, потому что их короткий id занят другим URL
+	// или их оригинальный URL сохранен под другим коротким id
+	Conflicts int
+}
+
+// String возвращает итоги импорта для вывода.
+func (s ImportStats) String() string {
+	return fmt.Sprintf("%d read, %d new, %d existing, %d conflicts", s.Read, s.Created, s.Existing, s.Conflicts)
+}
+
+// ExportRecords записывает записи хранилища repo в w в формате JSON Lines
+// и выводит ход экспорта в progress.
+// Возвращает количество записанных записей. Если хранилище не поддерживает перечисление записей, возвращает ошибку.
+func ExportRecords(ctx context.Context, repo repository.Repository, w io.Writer, progress io.Writer) (n int, err error) {
+	exporter, ok := repo.(repository.Exporter)
+	if !ok {
+		return 0, errors.New("storage does not support export")
+	}
+	encoder := json.NewEncoder(w)
+	err = exporter.Export(ctx, func(record repository.Record) error {
+		line := transferRecord{ShortID: record.ShortID, OriginalURL: record.OriginalURL, UserID: record.UserID}
+		if !record.ExpiresAt.IsZero() {
+			line.ExpiresAt = &record.ExpiresAt
+		}
+		if err := encoder.Encode(line); err != nil {
+			return err
+		}
+		n++
+		if n%exportProgressEvery == 0 {
+			fmt.Fprintf(progress, "exported %d records\n", n)
+		}
+		return nil
+	})
+	return n, err
+}
+
+// ImportRecords сохраняет в хранилище repo записи, прочитанные из r в формате JSON Lines,
+// пачками по importBatchSize записей и выводит ход импорта в progress.
+// Записи, оригинальный URL которых уже сохранен, не изменяются.
+// Конфликтующие записи пропускаются и выводятся в progress.
+// Если decoder не nil, то после импорта счетчик хранилища (см. repository.SequenceAdvancer)
+// продвигается за наибольшее значение, которое decoder получает из коротких id прочитанных записей.
+func ImportRecords(ctx context.Context, repo repository.Repository, decoder shortener.Decoder, r io.Reader, progress io.Writer) (stats ImportStats, err error) {
+	// Наибольшее значение счетчика среди коротких id прочитанных записей
+	var maxSequence uint64
+	batch := make([]repository.Record, 0, importBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		results, err := repo.SaveBatch(ctx, batch)
+		if err != nil {
+			return err
+		}
+		for i, result := range results {
+			switch {
+			case result.Err != nil:
+				stats.Conflicts++
+				fmt.Fprintf(progress, "skipped %s %s: %v\n", batch[i].ShortID, batch[i].OriginalURL, result.Err)
+			case result.IsNew:
+				stats.Created++
+			case result.Record.ShortID != batch[i].ShortID:
+				stats.Conflicts++
+				fmt.Fprintf(progress, "skipped %s %s: already shortened as %s\n", batch[i].ShortID, batch[i].OriginalURL, result.Record.ShortID)
+			default:
+				stats.Existing++
+			}
+		}
+		batch = batch[:0]
+		fmt.Fprintf(progress, "imported: %s\n", stats)
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var line transferRecord
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return stats, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if line.ShortID == "" || line.OriginalURL == "" {
+			return stats, fmt.Errorf("line %d: short_id and original_url are required", lineNo)
+		}
+		record := repository.Record{ShortID: line.ShortID, OriginalURL: line.OriginalURL, UserID: line.UserID}
+		if line.ExpiresAt != nil {
+			record.ExpiresAt = *line.ExpiresAt
+		}
+		batch = append(batch, record)
+		stats.Read++
+		if decoder != nil {
+			if n, ok := decoder.Decode(record.ShortID); ok {
+				maxSequence = max(maxSequence, n)
+			}
+		}
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return stats, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return stats, err
+	}
+	if err := flush(); err != nil {
+		return stats, err
+	}
+	if seq, ok := repo.(repository.SequenceAdvancer); ok && maxSequence > 0 {
+		if err := seq.AdvanceSequence(ctx, maxSequence); err != nil {
+			return stats, err
+		}
+		fmt.Fprintf(progress, "sequence advanced to %d\n", maxSequence)
+	}
+	return stats, nil
+}
+
+// runExport - выполняет подкоманду export: записывает записи хранилища из конфигурации в файл args[0].
+func runExport(args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return ErrUsage
+	}
+	repo, err := openTransferRepository()
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	file, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	n, err := ExportRecords(context.Background(), repo, w, out)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "exported %d records to %s\n", n, args[0])
+	return nil
+}
+
+// runImport - выполняет подкоманду import: сохраняет записи из файла args[0] в хранилище из конфигурации.
+func runImport(args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return ErrUsage
+	}
+	file, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	repo, err := openTransferRepository()
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	// Счетчик продвигается за id генератора из конфигурации, если он основан на счетчике
+	generator, err := NewGenerator(repo)
+	if err != nil {
+		return err
+	}
+	decoder, _ := generator.(shortener.Decoder)
+
+	stats, err := ImportRecords(context.Background(), repo, decoder, file, out)
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("Import finished: %s", stats)
+	return nil
+}
+
+// openTransferRepository - открывает постоянное хранилище из конфигурации.
+// Хранилище в памяти не подходит для переноса, поэтому, если постоянное хранилище не указано, возвращает ошибку.
+func openTransferRepository() (repository.Repository, error) {
+	if config.Params.DatabaseDSN == "" && config.Params.FileStoragePath == "" {
+		return nil, errors.New("no persistent storage specified: use -d or -f")
+	}
+	return NewRepository(context.Background())
+}
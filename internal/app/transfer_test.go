@@ -0,0 +1,95 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vadim-ivlev/url-shortener/internal/filestorage"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+	"github.com/vadim-ivlev/url-shortener/internal/shortener"
+	"github.com/vadim-ivlev/url-shortener/internal/storage"
+)
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	source := storage.NewMemory()
+	expiresAt := time.Now().Add(time.Hour).UTC()
+	_, _, err := source.Save(ctx, repository.Record{ShortID: "AAAA", OriginalURL: "https://a.com", UserID: "user-1", ExpiresAt: expiresAt})
+	assert.NoError(t, err)
+	_, _, err = source.Save(ctx, repository.Record{ShortID: "BBBB", OriginalURL: "https://b.com"})
+	assert.NoError(t, err)
+	_, _, err = source.Save(ctx, repository.Record{ShortID: "CCCC", OriginalURL: "https://c.com"})
+	assert.NoError(t, err)
+
+	var dump bytes.Buffer
+	n, err := ExportRecords(ctx, source, &dump, io.Discard)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	// В целевом хранилище короткий id CCCC занят другим URL
+	target := storage.NewMemory()
+	_, _, err = target.Save(ctx, repository.Record{ShortID: "CCCC", OriginalURL: "https://other.com"})
+	assert.NoError(t, err)
+
+	var progress bytes.Buffer
+	stats, err := ImportRecords(ctx, target, nil, bytes.NewReader(dump.Bytes()), &progress)
+	assert.NoError(t, err)
+	assert.Equal(t, ImportStats{Read: 3, Created: 2, Conflicts: 1}, stats)
+	assert.Contains(t, progress.String(), "skipped CCCC")
+
+	record, err := target.Get(ctx, "AAAA")
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", record.UserID)
+	assert.True(t, record.ExpiresAt.Equal(expiresAt))
+
+	// Повторный импорт ничего не меняет
+	stats, err = ImportRecords(ctx, target, nil, bytes.NewReader(dump.Bytes()), io.Discard)
+	assert.NoError(t, err)
+	assert.Equal(t, ImportStats{Read: 3, Existing: 2, Conflicts: 1}, stats)
+
+	_, err = ImportRecords(ctx, target, nil, strings.NewReader("{not json}\n"), io.Discard)
+	assert.ErrorContains(t, err, "line 1")
+}
+
+func TestImportAdvancesSequence(t *testing.T) {
+	ctx := context.Background()
+	f, err := filestorage.Open(t.TempDir()+"/file-storage.txt", filestorage.Options{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+	_, err = f.NextSequence(ctx)
+	assert.NoError(t, err)
+
+	// Наибольший id счетчика - "api" (40008). Id "a-b" и "0api" генератор counter не выдает.
+	dump := `{"short_id":"10","original_url":"https://a.com"}
+{"short_id":"api","original_url":"https://b.com"}
+{"short_id":"a-b","original_url":"https://c.com"}
+{"short_id":"0api","original_url":"https://d.com"}
+`
+	_, err = ImportRecords(ctx, f, shortener.CounterGenerator{}, strings.NewReader(dump), io.Discard)
+	assert.NoError(t, err)
+	n, err := f.NextSequence(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(40009), n)
+
+	// Счетчик, уже превысивший импортированные id, не уменьшается
+	_, err = ImportRecords(ctx, f, shortener.CounterGenerator{}, strings.NewReader(`{"short_id":"1","original_url":"https://e.com"}`), io.Discard)
+	assert.NoError(t, err)
+	n, err = f.NextSequence(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(40010), n)
+
+	// Счетчик в памяти продвигается так же
+	m := storage.NewMemory()
+	_, err = ImportRecords(ctx, m, shortener.CounterGenerator{}, strings.NewReader(dump), io.Discard)
+	assert.NoError(t, err)
+	n, err = m.NextSequence(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(40009), n)
+}
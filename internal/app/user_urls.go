@@ -0,0 +1,68 @@
+// Description: Получение списка коротких URL пользователя с постраничным чтением.
+
+package app
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+)
+
+// Ограничения на размер страницы списка коротких URL пользователя
+const (
+	DefaultPageLimit = 1000
+	MaxPageLimit     = 10000
+)
+
+// ErrInvalidCursor - курсор постраничного чтения не удалось разобрать.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// UserURL - короткий id пользователя и соответствующий ему оригинальный URL.
+type UserURL struct {
+	ShortID     string
+	OriginalURL string
+}
+
+// EncodeCursor - кодирует короткий id последней записи страницы в непрозрачный курсор.
+func EncodeCursor(shortID string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(shortID))
+}
+
+// DecodeCursor - восстанавливает короткий id из курсора. Пустой курсор означает начало списка.
+func DecodeCursor(cursor string) (shortID string, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", ErrInvalidCursor
+	}
+	return string(b), nil
+}
+
+// ListUserURLs - возвращает страницу неудаленных и непросроченных коротких URL пользователя, упорядоченных по короткому id.
+// Параметры:
+// - ctx - контекст
+// - userID - идентификатор пользователя
+// - cursor - курсор, полученный с предыдущей страницей, или пустая строка для первой страницы
+// - limit - максимальное количество записей на странице
+// Возвращает записи страницы и курсор следующей страницы (пустой, если страница последняя).
+func (a *App) ListUserURLs(ctx context.Context, userID, cursor string, limit int) (urls []UserURL, nextCursor string, err error) {
+	after, err := DecodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Читаем на одну запись больше, чтобы узнать, есть ли следующая страница
+	records, err := a.Repo.ListByUser(ctx, userID, after, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	urls = make([]UserURL, 0, len(records))
+	for _, r := range records {
+		urls = append(urls, UserURL{ShortID: r.ShortID, OriginalURL: r.OriginalURL})
+	}
+
+	if len(urls) > limit {
+		urls = urls[:limit]
+		nextCursor = EncodeCursor(urls[limit-1].ShortID)
+	}
+	return urls, nextCursor, nil
+}
@@ -0,0 +1,111 @@
+// Description: Аутентификация пользователей с помощью подписанных JWT.
+// Токен содержит идентификатор пользователя и подписывается алгоритмом HS256
+// секретным ключом config.Params.SecretKey.
+// Идентификатор пользователя передается обработчикам через контекст запроса.
+
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/vadim-ivlev/url-shortener/internal/config"
+)
+
+// CookieName - имя cookie, в которой хранится токен пользователя.
+const CookieName = "token"
+
+// TokenTTL - время жизни токена.
+const TokenTTL = 365 * 24 * time.Hour
+
+// ErrInvalidToken - токен не прошел проверку подписи или не содержит идентификатор пользователя.
+var ErrInvalidToken = errors.New("invalid token")
+
+// claims - утверждения JWT с идентификатором пользователя.
+type claims struct {
+	jwt.RegisteredClaims
+	UserID string `json:"user_id"`
+}
+
+// secretKey - ключ для подписи токенов.
+var secretKey []byte
+
+// Init инициализирует ключ подписи токенов из config.Params.SecretKey.
+// Если ключ не задан, то генерируется случайный ключ, и токены,
+// выданные до перезапуска сервера, перестают быть действительными.
+func Init() {
+	if config.Params.SecretKey != "" {
+		secretKey = []byte(config.Params.SecretKey)
+		return
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatal().Err(err).Msg("Cannot generate secret key")
+	}
+	secretKey = []byte(hex.EncodeToString(key))
+	log.Warn().Msg("Secret key is not set. Using a random key, tokens will be invalidated on restart")
+}
+
+// NewUserID генерирует новый идентификатор пользователя.
+func NewUserID() string {
+	return uuid.NewString()
+}
+
+// BuildToken создает подписанный токен для пользователя userID.
+func BuildToken(userID string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(TokenTTL)),
+		},
+		UserID: userID,
+	})
+	return token.SignedString(secretKey)
+}
+
+// ParseToken проверяет подпись токена и возвращает идентификатор пользователя.
+// Возвращает ErrInvalidToken, если токен недействителен.
+func ParseToken(tokenString string) (userID string, err error) {
+	c := &claims{}
+	token, err := jwt.ParseWithClaims(tokenString, c, func(t *jwt.Token) (interface{}, error) {
+		return secretKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !token.Valid || c.UserID == "" {
+		return "", ErrInvalidToken
+	}
+	return c.UserID, nil
+}
+
+// contextKey - тип ключей контекста пакета, чтобы избежать пересечений с другими пакетами.
+type contextKey int
+
+const (
+	userIDKey contextKey = iota
+	newUserKey
+)
+
+// WithUserID возвращает контекст с идентификатором пользователя.
+// isNew - true, если идентификатор только что выдан и у запроса не было действительного токена.
+func WithUserID(ctx context.Context, userID string, isNew bool) context.Context {
+	ctx = context.WithValue(ctx, userIDKey, userID)
+	return context.WithValue(ctx, newUserKey, isNew)
+}
+
+// UserID возвращает идентификатор пользователя из контекста.
+// ok - false, если пользователь в контексте не задан.
+func UserID(ctx context.Context) (userID string, ok bool) {
+	userID, ok = ctx.Value(userIDKey).(string)
+	return userID, ok && userID != ""
+}
+
+// IsNewUser возвращает true, если идентификатор пользователя был выдан в текущем запросе,
+// то есть запрос пришел без действительного токена.
+func IsNewUser(ctx context.Context) bool {
+	isNew, _ := ctx.Value(newUserKey).(bool)
+	return isNew
+}
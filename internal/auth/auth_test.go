@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vadim-ivlev/url-shortener/internal/config"
+)
+
+func TestMain(m *testing.M) {
+	config.Params.SecretKey = "test-secret"
+	Init()
+	os.Exit(m.Run())
+}
+
+func TestBuildAndParseToken(t *testing.T) {
+	userID := NewUserID()
+	token, err := BuildToken(userID)
+	assert.NoError(t, err)
+
+	got, err := ParseToken(token)
+	assert.NoError(t, err)
+	assert.Equal(t, userID, got)
+
+	// Испорченная подпись
+	_, err = ParseToken(token + "x")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// Мусор вместо токена
+	_, err = ParseToken("garbage")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// Токен, подписанный другим ключом
+	secretKey = []byte("another-secret")
+	foreign, err := BuildToken(userID)
+	assert.NoError(t, err)
+	Init()
+	_, err = ParseToken(foreign)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestUserIDContext(t *testing.T) {
+	_, ok := UserID(context.Background())
+	assert.False(t, ok)
+	assert.False(t, IsNewUser(context.Background()))
+
+	ctx := WithUserID(context.Background(), "user-1", true)
+	userID, ok := UserID(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "user-1", userID)
+	assert.True(t, IsNewUser(ctx))
+}
@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/rs/zerolog/log"
 
@@ -16,6 +19,46 @@ type config struct {
 	BaseURL         string `env:"BASE_URL"`
 	FileStoragePath string `env:"FILE_STORAGE_PATH"`
 	DatabaseDSN     string `env:"DATABASE_DSN"`
+	IDGenerator     string `env:"ID_GENERATOR"`
+	IDLength        int    `env:"ID_LENGTH"`
+	IDAlphabet      string `env:"ID_ALPHABET"`
+	SecretKey       string `env:"SECRET_KEY" json:"-"`
+	CacheMode       string `env:"CACHE_MODE"`
+	CacheSize       int    `env:"CACHE_SIZE"`
+	// Ограничения пула соединений с PostgreSQL
+	DBMaxOpenConns    int           `env:"DB_MAX_OPEN_CONNS"`
+	DBMaxIdleConns    int           `env:"DB_MAX_IDLE_CONNS"`
+	DBConnMaxLifetime time.Duration `env:"DB_CONN_MAX_LIFETIME"`
+	DBConnMaxIdleTime time.Duration `env:"DB_CONN_MAX_IDLE_TIME"`
+	// Сброс файлового хранилища на диск
+	FileSync         string        `env:"FILE_SYNC"`
+	FileSyncInterval time.Duration `env:"FILE_SYNC_INTERVAL"`
+	// FileReadOnly - открыть файловое хранилище только для чтения вместе с другими читателями
+	FileReadOnly bool `env:"FILE_READ_ONLY"`
+	// DualWrite - писать изменения и в базу данных (-d), и в файловое хранилище (-f)
+	DualWrite bool `env:"DUAL_WRITE"`
+	// ShutdownTimeout - максимальное время завершения запросов при остановке сервера и, отдельно, остановки фоновых обработчиков
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT"`
+	// EnableHTTPS - обслуживать запросы по HTTPS
+	EnableHTTPS bool `env:"ENABLE_HTTPS"`
+	// TLSCertFile, TLSKeyFile - файлы сертификата и ключа. Если не указаны, создается самоподписанный сертификат
+	TLSCertFile string `env:"TLS_CERT_FILE"`
+	TLSKeyFile  string `env:"TLS_KEY_FILE"`
+	// TLSCacheDir - директория для самоподписанного сертификата
+	TLSCacheDir string `env:"TLS_CACHE_DIR"`
+	// Таймауты HTTP сервера
+	ReadHeaderTimeout time.Duration `env:"READ_HEADER_TIMEOUT"`
+	ReadTimeout       time.Duration `env:"READ_TIMEOUT"`
+	WriteTimeout      time.Duration `env:"WRITE_TIMEOUT"`
+	IdleTimeout       time.Duration `env:"IDLE_TIMEOUT"`
+	// Ограничения размера запроса
+	MaxHeaderBytes    int   `env:"MAX_HEADER_BYTES"`
+	MaxBodyBytes      int64 `env:"MAX_BODY_BYTES"`
+	MaxBatchBodyBytes int64 `env:"MAX_BATCH_BODY_BYTES"`
+	// GRPCAddress - адрес gRPC сервера. Пустая строка - gRPC сервер не запускается
+	GRPCAddress string `env:"GRPC_ADDRESS"`
+	// TrustedProxies - адреса и сети (CIDR) обратных прокси через запятую, которым доверяется заголовок X-Real-IP
+	TrustedProxies string `env:"TRUSTED_PROXIES"`
 }
 
 // Params - переменная для хранения параметров приложения
@@ -26,7 +69,35 @@ func ParseCommandLine() {
 	flag.StringVar(&Params.ServerAddress, "a", "localhost:8080", "HTTP server address")
 	flag.StringVar(&Params.BaseURL, "b", "http://localhost:8080", "Base URL")
 	flag.StringVar(&Params.FileStoragePath, "f", "./data/file-storage.txt", "File storage path")
-	flag.StringVar(&Params.DatabaseDSN, "d", "", "Database DSN")
+	flag.StringVar(&Params.DatabaseDSN, "d", "", "Database DSN: postgres://... or sqlite:///path/db.sqlite")
+	flag.StringVar(&Params.IDGenerator, "g", "hash", "Short ID generator: hash, counter, random, sqids")
+	flag.IntVar(&Params.IDLength, "id-length", 8, "Short ID length (random) or minimal length (sqids)")
+	flag.StringVar(&Params.IDAlphabet, "id-alphabet", "", "Short ID alphabet (random, sqids). Empty means default")
+	flag.StringVar(&Params.SecretKey, "k", "", "Secret key for signing auth cookies. Empty means random key")
+	flag.StringVar(&Params.CacheMode, "cache-mode", "preload", "PostgreSQL cache mode: preload (whole table in memory), lru (bounded read-through cache)")
+	flag.IntVar(&Params.CacheSize, "cache-size", 100000, "Maximal number of records in the lru cache")
+	flag.IntVar(&Params.DBMaxOpenConns, "db-max-open-conns", 25, "Maximal number of open PostgreSQL connections. 0 means unlimited")
+	flag.IntVar(&Params.DBMaxIdleConns, "db-max-idle-conns", 10, "Maximal number of idle PostgreSQL connections")
+	flag.DurationVar(&Params.DBConnMaxLifetime, "db-conn-max-lifetime", 30*time.Minute, "Maximal lifetime of a PostgreSQL connection. 0 means unlimited")
+	flag.DurationVar(&Params.DBConnMaxIdleTime, "db-conn-max-idle-time", 5*time.Minute, "Maximal idle time of a PostgreSQL connection. 0 means unlimited")
+	flag.StringVar(&Params.FileSync, "file-sync", "always", "File storage fsync policy: always, interval, never")
+	flag.DurationVar(&Params.FileSyncInterval, "file-sync-interval", time.Second, "File storage fsync period for the interval policy")
+	flag.BoolVar(&Params.FileReadOnly, "file-read-only", false, "Open the file storage read-only, sharing it with other read-only instances")
+	flag.BoolVar(&Params.DualWrite, "dual-write", false, "Write to both the database (-d) and the file storage (-f), reading from the database")
+	flag.DurationVar(&Params.ShutdownTimeout, "shutdown-timeout", 10*time.Second, "Maximal time to drain requests, and then background workers, on shutdown")
+	flag.BoolVar(&Params.EnableHTTPS, "s", false, "Enable HTTPS")
+	flag.StringVar(&Params.TLSCertFile, "tls-cert", "", "TLS certificate file. Empty means a self-signed certificate")
+	flag.StringVar(&Params.TLSKeyFile, "tls-key", "", "TLS private key file. Empty means a self-signed certificate")
+	flag.StringVar(&Params.TLSCacheDir, "tls-cache-dir", "./data/tls", "Directory for the self-signed TLS certificate")
+	flag.DurationVar(&Params.ReadHeaderTimeout, "read-header-timeout", 5*time.Second, "Maximal time to read request headers")
+	flag.DurationVar(&Params.ReadTimeout, "read-timeout", 15*time.Second, "Maximal time to read a whole request")
+	flag.DurationVar(&Params.WriteTimeout, "write-timeout", 30*time.Second, "Maximal time to write a response")
+	flag.DurationVar(&Params.IdleTimeout, "idle-timeout", 2*time.Minute, "Maximal time to keep an idle keep-alive connection")
+	flag.IntVar(&Params.MaxHeaderBytes, "max-header-bytes", 1<<20, "Maximal size of request headers")
+	flag.Int64Var(&Params.MaxBodyBytes, "max-body-bytes", 64<<10, "Maximal request body size. 0 means unlimited")
+	flag.Int64Var(&Params.MaxBatchBodyBytes, "max-batch-body-bytes", 10<<20, "Maximal request body size for batch requests. 0 means unlimited")
+	flag.StringVar(&Params.GRPCAddress, "grpc-address", "", "gRPC server address. Empty means the gRPC server is disabled")
+	flag.StringVar(&Params.TrustedProxies, "trusted-proxies", "", "Comma-separated addresses or CIDR networks of reverse proxies trusted to set X-Real-IP. Empty means X-Real-IP is ignored")
 	flag.Parse()
 }
 
@@ -38,6 +109,30 @@ func ParseEnv() {
 	}
 }
 
+// ResolveDefaults - уточняет значения по умолчанию, зависящие от других параметров.
+// Вызывается после ParseCommandLine и ParseEnv.
+// Если включен HTTPS, а базовый URL не задан явно (параметром -b или BASE_URL), то он начинается с https://,
+// чтобы возвращаемые короткие URL были правильными.
+func ResolveDefaults() {
+	if Params.EnableHTTPS && !baseURLSet() {
+		Params.BaseURL = "https://" + strings.TrimPrefix(Params.BaseURL, "http://")
+	}
+}
+
+// baseURLSet - проверяет, задан ли базовый URL явно.
+func baseURLSet() bool {
+	if os.Getenv("BASE_URL") != "" {
+		return true
+	}
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "b" {
+			set = true
+		}
+	})
+	return set
+}
+
 // JSONString - сериализуем структуру в формат JSON
 func JSONString(params interface{}) string {
 	bytes, err := json.MarshalIndent(params, "", "  ")
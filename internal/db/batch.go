@@ -0,0 +1,99 @@
+// Description: Сохранение пачки записей в одной транзакции. Используется хранилищами Postgres и SQLite.
+
+package db
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+)
+
+// batchChunkSize - максимальное количество строк в одном INSERT.
+// Ограничивает число параметров запроса (4 на строку) лимитами PostgreSQL и SQLite.
+const batchChunkSize = 1000
+
+// storeBatch - сохраняет записи в одной транзакции многострочными INSERT ... ON CONFLICT DO NOTHING.
+// Удаленные строки с оригинальными URL пачки не мешают вставке, а просроченные перед вставкой помечаются удаленными, как в store.
+// Результаты возвращаются в порядке записей:
+// - новая запись - IsNew == true;
+// - оригинальный URL уже сохранен в неудаленной строке (в том числе ранее в этой же пачке) - существующая запись и IsNew == false;
+// - короткий id занят другим URL или удаленной строкой - Err == repository.ErrShortIDTaken.
+// Ошибка err означает, что транзакция откачена и ни одна запись не сохранена.
+// Запрос составляется с параметрами ? и преобразуется под драйвер conn.
+func storeBatch(ctx context.Context, conn *sqlx.DB, records []repository.Record) (results []repository.SaveResult, err error) {
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	originals := make([]string, 0, len(records))
+	for _, r := range records {
+		originals = append(originals, r.OriginalURL)
+	}
+	if err := retireExpired(ctx, tx, originals, time.Now()); err != nil {
+		return nil, err
+	}
+
+	// Вставить записи, запомнив короткие id вставленных строк
+	inserted := make(map[string]bool, len(records))
+	for start := 0; start < len(records); start += batchChunkSize {
+		chunk := records[start:min(start+batchChunkSize, len(records))]
+		values := make([]string, 0, len(chunk))
+		args := make([]any, 0, 4*len(chunk))
+		for _, r := range chunk {
+			values = append(values, "(?, ?, NULLIF(?, ''), ?)")
+			args = append(args, r.ShortID, r.OriginalURL, r.UserID, nullTime(r.ExpiresAt.UTC()))
+		}
+		query := tx.Rebind("INSERT INTO urls (short_id, original_url, user_id, expires_at) VALUES " +
+			strings.Join(values, ", ") + " ON CONFLICT DO NOTHING RETURNING short_id")
+		shortIDs := make([]string, 0, len(chunk))
+		if err := tx.SelectContext(ctx, &shortIDs, query, args...); err != nil {
+			return nil, err
+		}
+		for _, shortID := range shortIDs {
+			inserted[shortID] = true
+		}
+	}
+
+	// Прочитать неудаленные записи с оригинальными URL пачки, включая только что вставленные
+	byOriginal := make(map[string]repository.Record, len(records))
+	for start := 0; start < len(originals); start += batchChunkSize {
+		query, args, err := sqlx.In(
+			"SELECT short_id, original_url, COALESCE(user_id, '') AS user_id, is_deleted, expires_at FROM urls WHERE original_url IN (?) AND NOT is_deleted",
+			originals[start:min(start+batchChunkSize, len(originals))])
+		if err != nil {
+			return nil, err
+		}
+		rows := make([]Record, 0)
+		if err := tx.SelectContext(ctx, &rows, tx.Rebind(query), args...); err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			byOriginal[row.OriginalURL] = toRepositoryRecord(row)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	results = make([]repository.SaveResult, 0, len(records))
+	for _, r := range records {
+		saved, ok := byOriginal[r.OriginalURL]
+		switch {
+		case !ok:
+			results = append(results, repository.SaveResult{Err: repository.ErrShortIDTaken})
+		case saved.ShortID == r.ShortID && inserted[r.ShortID]:
+			// Новой считается только первая запись пачки с этим коротким id
+			delete(inserted, r.ShortID)
+			results = append(results, repository.SaveResult{Record: saved, IsNew: true})
+		default:
+			results = append(results, repository.SaveResult{Record: saved})
+		}
+	}
+	return results, nil
+}
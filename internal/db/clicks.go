@@ -0,0 +1,40 @@
+// Description: Хранение переходов по коротким URL в таблице clicks.
+
+package db
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/vadim-ivlev/url-shortener/internal/analytics"
+)
+
+// storeClicks - сохраняет переходы одним запросом.
+func storeClicks(ctx context.Context, conn *sqlx.DB, clicks []analytics.Click) error {
+	if len(clicks) == 0 {
+		return nil
+	}
+	_, err := conn.NamedExecContext(ctx,
+		`INSERT INTO clicks (short_id, clicked_at, referrer, user_agent, client_ip)
+		VALUES (:short_id, :clicked_at, :referrer, :user_agent, :client_ip)`,
+		clicks)
+	return err
+}
+
+// getClickStats - возвращает статистику переходов по короткому URL.
+// Уникальные посетители определяются по паре огрубленный IP + User-Agent.
+func getClickStats(ctx context.Context, conn *sqlx.DB, shortID string) (stats analytics.Stats, err error) {
+	err = conn.QueryRowContext(ctx,
+		`SELECT COUNT(*), COUNT(DISTINCT (client_ip, user_agent)) FROM clicks WHERE short_id = $1`,
+		shortID).Scan(&stats.TotalClicks, &stats.UniqueVisitors)
+	if err != nil {
+		return stats, err
+	}
+	stats.Daily = make([]analytics.DayClicks, 0)
+	err = conn.SelectContext(ctx, &stats.Daily,
+		`SELECT to_char(clicked_at AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS date, COUNT(*) AS clicks
+		FROM clicks WHERE short_id = $1
+		GROUP BY date ORDER BY date`,
+		shortID)
+	return stats, err
+}
@@ -2,109 +2,236 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"time"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/rs/zerolog/log"
-	"github.com/vadim-ivlev/url-shortener/internal/config"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
 )
 
-// DB - пул соединений с базой данных
-var DB *sqlx.DB = nil
+// uniqueViolation - код ошибки PostgreSQL при нарушении ограничения уникальности.
+const uniqueViolation = "23505"
 
-// CreatePool - создает пул соединений с базой данных
-func CreatePool() (err error) {
-	DB, err = sqlx.Connect("postgres", config.Params.DatabaseDSN)
-	return err
+// urlsPrimaryKey - имя ограничения первичного ключа таблицы urls.
+const urlsPrimaryKey = "urls_pkey"
+
+// CreatePool - создает пул соединений с базой данных и проверяет соединение.
+// Параметры:
+// - dsn - строка подключения к базе данных
+func CreatePool(dsn string) (*sqlx.DB, error) {
+	return sqlx.Connect("postgres", dsn)
 }
 
 // TryToConnect - Пытается соединиться с базой данных повторяя попытки в случае неудачи.
-// numAttempts - количество попыток
-func TryToConnect(numAttempts int) (err error) {
+// Если соединиться не удалось, возвращает пул, который установит соединение при первом запросе, и ошибку.
+// Параметры:
+// - dsn - строка подключения к базе данных
+// - numAttempts - количество попыток
+func TryToConnect(dsn string, numAttempts int) (conn *sqlx.DB, err error) {
+	conn, err = sqlx.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
 	err = errors.New("no attempts to connect to DB")
 	for i := 1; i <= numAttempts; i++ {
-		err = CreatePool()
+		err = conn.Ping()
 		if err == nil {
 			log.Info().Msg("Connected to DB")
-			return err
+			return conn, nil
 		}
 		log.Warn().Err(err).Msgf("Waiting for db connection. Attempt # %d", i)
 		time.Sleep(time.Second)
 	}
 	log.Error().Msg("Failed to connect to DB")
-	return err
+	return conn, err
 }
 
-// Disconnect - закрывает соединение с базой данных
-func Disconnect() {
-	if DB != nil {
-		DB.Close()
+// isShortIDViolation - проверяет, является ли ошибка нарушением первичного ключа short_id.
+func isShortIDViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == uniqueViolation && pqErr.Constraint == urlsPrimaryKey
 	}
-	DB = nil
+	return false
 }
 
-// IsConnected - проверяет, установлено ли соединение с базой данных
-func IsConnected() bool {
-	return DB != nil && DB.Ping() == nil
+// Record - запись таблицы urls.
+type Record struct {
+	ShortID     string `db:"short_id"`
+	OriginalURL string `db:"original_url"`
+	UserID      string `db:"user_id"`
+	IsDeleted   bool   `db:"is_deleted"`
+	// ExpiresAt - срок действия. Невалидное значение означает бессрочную запись.
+	ExpiresAt sql.NullTime `db:"expires_at"`
 }
 
-// Store - сохраняет данные в базу данных.
-// Параметры:
-// - ctx - контекст
-// - shortID - укороченный ID.
-// - originalURL - оригинальный URL.
-// Возвращает ошибку, если запись не удалась.
-func Store(ctx context.Context, shortID, originalURL string) error {
-	if !IsConnected() {
-		return errors.New("Store. No connection to DB")
-	}
-	_, err := DB.ExecContext(ctx, "INSERT INTO urls (short_id, original_url) VALUES ($1, $2)", shortID, originalURL)
-	return err
+// nullTime - преобразует время в sql.NullTime. Нулевое время считается NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
 }
 
-// Clear - очищает таблицу urls
-// - ctx - контекст
-// Возвращает ошибку, если очистка не удалась.
-func Clear(ctx context.Context) error {
-	if !IsConnected() {
-		return errors.New("Clear. No connection to DB")
+// retireExpired - помечает удаленными неудаленные строки с оригинальными URL originalURLs,
+// срок действия которых истек к моменту now. После этого URL можно сохранить под новым коротким id,
+// а прежний короткий id остается удаленным (см. индекс urls_original_url_live_idx).
+// Запрос составляется с параметрами ? и преобразуется под драйвер conn.
+func retireExpired(ctx context.Context, conn sqlx.ExtContext, originalURLs []string, now time.Time) error {
+	for start := 0; start < len(originalURLs); start += batchChunkSize {
+		query, args, err := sqlx.In("UPDATE urls SET is_deleted = TRUE WHERE original_url IN (?) AND NOT is_deleted AND expires_at <= ?",
+			originalURLs[start:min(start+batchChunkSize, len(originalURLs))], now.UTC())
+		if err != nil {
+			return err
+		}
+		if _, err := conn.ExecContext(ctx, conn.Rebind(query), args...); err != nil {
+			return err
+		}
 	}
-	_, err := DB.ExecContext(ctx, "DELETE FROM urls")
-	return err
+	return nil
 }
 
-// GetData - возвращает данные из базы данных в виде map[string]string,
-// где ключ - short_id, значение - original_url.
-// Параметры:
-// - ctx - контекст
-func GetData(ctx context.Context) (data map[string]string, err error) {
-	if !IsConnected() {
-		return nil, errors.New("GetData. No connection to DB")
+// store - сохраняет запись в таблице urls. Пустой UserID и нулевой ExpiresAt сохраняются как NULL.
+// Если оригинальный URL уже сохранен в неудаленной строке (например, другим экземпляром сервиса),
+// то строка не изменяется и возвращается *repository.ConflictError с сохраненной записью.
+// Удаленные строки с тем же оригинальным URL не мешают вставке (см. индекс urls_original_url_live_idx),
+// а неудаленная строка с истекшим сроком действия перед вставкой помечается удаленной (см. retireExpired).
+// Вставка с ON CONFLICT ... DO NOTHING не пишет и не блокирует существующую строку,
+// а сохраненная запись читается тем же запросом. Если она добавлена параллельной транзакцией
+// и не видна в снимке запроса, то она читается отдельным запросом.
+// Если занят короткий id, в том числе удаленной строкой, то возвращается ошибка нарушения первичного ключа
+// (см. isShortIDViolation).
+func store(ctx context.Context, conn *sqlx.DB, record repository.Record) error {
+	if err := retireExpired(ctx, conn, []string{record.OriginalURL}, time.Now()); err != nil {
+		return err
 	}
-
-	rows, err := DB.QueryxContext(ctx, "SELECT short_id, original_url FROM urls")
+	rows := make([]struct {
+		Record
+		Inserted bool `db:"inserted"`
+	}, 0, 1)
+	err := conn.SelectContext(ctx, &rows,
+		`WITH inserted AS (
+			INSERT INTO urls (short_id, original_url, user_id, expires_at) VALUES ($1, $2, NULLIF($3, ''), $4)
+			ON CONFLICT (original_url) WHERE NOT is_deleted DO NOTHING
+			RETURNING short_id, original_url, COALESCE(user_id, '') AS user_id, is_deleted, expires_at
+		)
+		SELECT *, TRUE AS inserted FROM inserted
+		UNION ALL
+		SELECT short_id, original_url, COALESCE(user_id, '') AS user_id, is_deleted, expires_at, FALSE AS inserted
+		FROM urls WHERE original_url = $2 AND NOT is_deleted AND NOT EXISTS (SELECT 1 FROM inserted)`,
+		record.ShortID, record.OriginalURL, record.UserID, nullTime(record.ExpiresAt))
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
-
-	if rows.Err() != nil {
-		return nil, rows.Err()
+	if len(rows) == 0 {
+		existing, err := getRecordByOriginal(ctx, conn, record.OriginalURL)
+		if err != nil {
+			return err
+		}
+		return &repository.ConflictError{Existing: toRepositoryRecord(existing)}
+	}
+	if !rows[0].Inserted {
+		return &repository.ConflictError{Existing: toRepositoryRecord(rows[0].Record)}
 	}
+	return nil
+}
 
-	data = make(map[string]string)
+// getRecords - возвращает все записи таблицы urls.
+func getRecords(ctx context.Context, conn *sqlx.DB) (records []Record, err error) {
+	records = make([]Record, 0)
+	err = conn.SelectContext(ctx, &records, "SELECT short_id, original_url, COALESCE(user_id, '') AS user_id, is_deleted, expires_at FROM urls")
+	return records, err
+}
 
+// exportRecords - читает неудаленные записи таблицы urls по одной и вызывает fn для каждой непросроченной записи.
+// Записи не загружаются в память целиком, поэтому таблица может быть любого размера.
+// Запрос не использует параметры и подходит и для PostgreSQL, и для SQLite.
+func exportRecords(ctx context.Context, conn *sqlx.DB, fn func(repository.Record) error) error {
+	rows, err := conn.QueryxContext(ctx,
+		"SELECT short_id, original_url, COALESCE(user_id, '') AS user_id, is_deleted, expires_at FROM urls WHERE NOT is_deleted ORDER BY short_id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	now := time.Now()
 	for rows.Next() {
-		var shortID, originalURL string
-		err = rows.Scan(&shortID, &originalURL)
-		if err != nil {
-			log.Warn().Err(err).Msg("GetData Cannot scan row")
+		var row Record
+		if err := rows.StructScan(&row); err != nil {
+			return err
+		}
+		record := toRepositoryRecord(row)
+		if record.IsExpired(now) {
 			continue
 		}
-		data[shortID] = originalURL
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// getRecord - возвращает запись с коротким id shortID. sql.ErrNoRows, если записи нет.
+func getRecord(ctx context.Context, conn *sqlx.DB, shortID string) (record Record, err error) {
+	err = conn.GetContext(ctx, &record,
+		"SELECT short_id, original_url, COALESCE(user_id, '') AS user_id, is_deleted, expires_at FROM urls WHERE short_id = $1",
+		shortID)
+	return record, err
+}
+
+// getRecordByOriginal - возвращает неудаленную запись с оригинальным URL originalURL. sql.ErrNoRows, если записи нет.
+func getRecordByOriginal(ctx context.Context, conn *sqlx.DB, originalURL string) (record Record, err error) {
+	err = conn.GetContext(ctx, &record,
+		"SELECT short_id, original_url, COALESCE(user_id, '') AS user_id, is_deleted, expires_at FROM urls WHERE original_url = $1 AND NOT is_deleted",
+		originalURL)
+	return record, err
+}
+
+// getUserRecords - возвращает неудаленные и непросроченные записи пользователя userID, упорядоченные по short_id.
+// Параметры:
+// - afterShortID - вернуть только записи с short_id больше указанного (для постраничного чтения)
+// - limit - максимальное количество записей
+func getUserRecords(ctx context.Context, conn *sqlx.DB, userID, afterShortID string, limit int) (records []Record, err error) {
+	records = make([]Record, 0)
+	err = conn.SelectContext(ctx, &records,
+		`SELECT short_id, original_url, user_id, is_deleted, expires_at FROM urls
+		WHERE user_id = $1 AND short_id > $2 AND NOT is_deleted
+		AND (expires_at IS NULL OR expires_at > now())
+		ORDER BY short_id
+		LIMIT $3`,
+		userID, afterShortID, limit)
+	return records, err
+}
+
+// markDeleted - помечает удаленными записи пользователя userID с указанными short_id одним запросом.
+func markDeleted(ctx context.Context, conn *sqlx.DB, userID string, shortIDs []string) error {
+	_, err := conn.ExecContext(ctx,
+		"UPDATE urls SET is_deleted = TRUE WHERE user_id = $1 AND short_id = ANY($2)",
+		userID, pq.Array(shortIDs))
+	return err
+}
+
+// markExpired - помечает удаленными записи, срок действия которых истек к моменту now.
+// Возвращает количество помеченных записей.
+func markExpired(ctx context.Context, conn *sqlx.DB, now time.Time) (n int64, err error) {
+	res, err := conn.ExecContext(ctx, "UPDATE urls SET is_deleted = TRUE WHERE expires_at <= $1 AND NOT is_deleted", now)
+	if err != nil {
+		return 0, err
 	}
+	return res.RowsAffected()
+}
+
+// advanceSequence - устанавливает последовательность short_id_seq так, чтобы следующее значение было больше n.
+// Последовательность, уже превысившая n, не изменяется.
+func advanceSequence(ctx context.Context, conn *sqlx.DB, n uint64) error {
+	_, err := conn.ExecContext(ctx,
+		"SELECT setval('short_id_seq', $1) FROM short_id_seq WHERE last_value < $1 OR (last_value = $1 AND NOT is_called)",
+		int64(n))
+	return err
+}
 
-	return data, nil
+// nextSequenceValue - возвращает следующее значение последовательности short_id_seq.
+func nextSequenceValue(ctx context.Context, conn *sqlx.DB) (n uint64, err error) {
+	err = conn.QueryRowContext(ctx, "SELECT nextval('short_id_seq')").Scan(&n)
+	return n, err
 }
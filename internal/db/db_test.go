@@ -39,7 +39,7 @@ func TestCreateDBPool(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := CreatePool(); (err != nil) != tt.wantErr {
+			if _, err := CreatePool(config.Params.DatabaseDSN); (err != nil) != tt.wantErr {
 				t.Errorf("CreateDBPool() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -70,7 +70,7 @@ func TestConnectToDatabase(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := TryToConnect(tt.args.numAttempts); (err != nil) != tt.wantErr {
+			if _, err := TryToConnect(config.Params.DatabaseDSN, tt.args.numAttempts); (err != nil) != tt.wantErr {
 				t.Errorf("ConnectToDatabase() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -0,0 +1,174 @@
+// Description: Согласование кешей экземпляров сервиса, работающих с одной базой данных PostgreSQL.
+// Триггер urls_notify (миграция 07) отправляет уведомление в канал urls_changes при каждом изменении строки urls.
+// Слушатель получает уведомления на отдельном соединении и применяет изменения к кешу хранилища.
+// При потере соединения pq.Listener переподключается сам. Уведомления, отправленные,
+// пока соединения не было, теряются, поэтому после переподключения кеш синхронизируется полностью.
+
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+)
+
+// urlsChannel - канал уведомлений об изменениях таблицы urls.
+const urlsChannel = "urls_changes"
+
+// Параметры слушателя
+const (
+	// listenTimeout - сколько ждать подписки на уведомления при запуске
+	listenTimeout = 5 * time.Second
+	// listenerPingInterval - период проверки соединения, если уведомлений нет
+	listenerPingInterval = 90 * time.Second
+	// Интервалы между попытками переподключения
+	minReconnectInterval = time.Second
+	maxReconnectInterval = time.Minute
+)
+
+// opDelete - операция удаления строки в уведомлении.
+const opDelete = "DELETE"
+
+// urlsChange - уведомление об изменении строки таблицы urls.
+type urlsChange struct {
+	// Op - операция: INSERT, UPDATE или DELETE
+	Op      string `json:"op"`
+	ShortID string `json:"short_id"`
+	// OriginalURL - nil, если строка не поместилась в уведомление
+	OriginalURL *string    `json:"original_url"`
+	UserID      *string    `json:"user_id"`
+	IsDeleted   bool       `json:"is_deleted"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+// parseChange - разбирает уведомление об изменении строки таблицы urls.
+func parseChange(payload string) (change urlsChange, err error) {
+	err = json.Unmarshal([]byte(payload), &change)
+	return change, err
+}
+
+// record - возвращает измененную запись. ok - false, если запись не поместилась в уведомление.
+func (c urlsChange) record() (record repository.Record, ok bool) {
+	if c.OriginalURL == nil {
+		return repository.Record{}, false
+	}
+	record = repository.Record{ShortID: c.ShortID, OriginalURL: *c.OriginalURL, IsDeleted: c.IsDeleted}
+	if c.UserID != nil {
+		record.UserID = *c.UserID
+	}
+	if c.ExpiresAt != nil {
+		record.ExpiresAt = *c.ExpiresAt
+	}
+	return record, true
+}
+
+// changeCache - кеш, к которому слушатель применяет изменения.
+type changeCache interface {
+	// applyChange применяет к кешу изменение строки таблицы urls
+	applyChange(ctx context.Context, change urlsChange) error
+	// resync синхронизирует кеш с базой данных после пропуска уведомлений
+	resync(ctx context.Context) error
+}
+
+// listener - слушатель уведомлений об изменениях таблицы urls.
+type listener struct {
+	pq    *pq.Listener
+	cache changeCache
+	// ready - принимает сигнал о подписке на уведомления, пока вызывающий ждет ее в waitReady
+	ready chan struct{}
+	// done - закрывается, когда слушатель завершил работу
+	done chan struct{}
+}
+
+// startListener - запускает слушателя уведомлений на отдельном соединении с базой данных dsn
+// и ждет подписки не дольше listenTimeout.
+// Если подписаться за это время не удалось (например, база данных недоступна),
+// то слушатель продолжает подключаться в фоне и после подписки синхронизирует кеш полностью.
+func startListener(dsn string, cache changeCache) *listener {
+	l := &listener{cache: cache, ready: make(chan struct{}), done: make(chan struct{})}
+	l.pq = pq.NewListener(dsn, minReconnectInterval, maxReconnectInterval, logListenerEvent)
+	go l.run()
+
+	select {
+	case <-l.ready:
+	case <-time.After(listenTimeout):
+		log.Warn().Msg("Listener is not connected yet. Cache will be resynced after connection")
+	}
+	return l
+}
+
+// logListenerEvent - пишет в лог изменения состояния соединения слушателя.
+func logListenerEvent(event pq.ListenerEventType, err error) {
+	switch event {
+	case pq.ListenerEventDisconnected:
+		log.Warn().Err(err).Msg("Listener disconnected")
+	case pq.ListenerEventReconnected:
+		log.Info().Msg("Listener reconnected")
+	case pq.ListenerEventConnectionAttemptFailed:
+		log.Warn().Err(err).Msg("Listener connection attempt failed")
+	}
+}
+
+// run - подписывается на уведомления и применяет их к кешу, пока слушатель не остановлен.
+func (l *listener) run() {
+	defer close(l.done)
+
+	// Listen ждет соединения и возвращает ошибку, если слушатель остановлен
+	if err := l.pq.Listen(urlsChannel); err != nil {
+		log.Error().Err(err).Msg("Cannot listen for urls changes")
+		return
+	}
+	select {
+	case l.ready <- struct{}{}:
+		// Вызывающий еще ждет и загрузит кеш сам
+	default:
+		l.resync()
+	}
+
+	for {
+		select {
+		case n, ok := <-l.pq.Notify:
+			if !ok {
+				return
+			}
+			// После переподключения приходит nil: уведомления могли быть потеряны
+			if n == nil {
+				l.resync()
+				continue
+			}
+			l.apply(n.Extra)
+		case <-time.After(listenerPingInterval):
+			go l.pq.Ping()
+		}
+	}
+}
+
+// apply - применяет уведомление к кешу.
+func (l *listener) apply(payload string) {
+	change, err := parseChange(payload)
+	if err != nil {
+		log.Error().Err(err).Str("payload", payload).Msg("Cannot parse urls change")
+		return
+	}
+	if err := l.cache.applyChange(context.Background(), change); err != nil {
+		log.Error().Err(err).Str("short_id", change.ShortID).Msg("Cannot apply urls change")
+	}
+}
+
+// resync - синхронизирует кеш с базой данных.
+func (l *listener) resync() {
+	log.Info().Msg("Resyncing cache")
+	if err := l.cache.resync(context.Background()); err != nil {
+		log.Error().Err(err).Msg("Cannot resync cache")
+	}
+}
+
+// stop - останавливает слушателя и закрывает его соединение.
+func (l *listener) stop() {
+	l.pq.Close()
+	<-l.done
+}
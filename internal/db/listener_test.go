@@ -0,0 +1,72 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vadim-ivlev/url-shortener/internal/config"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+	"github.com/vadim-ivlev/url-shortener/migrations"
+)
+
+func TestParseChange(t *testing.T) {
+	change, err := parseChange(`{"op":"INSERT","short_id":"AAAA","original_url":"https://a.com","user_id":null,"is_deleted":false,"expires_at":"2030-01-02T03:04:05.123456+00:00"}`)
+	assert.NoError(t, err)
+	record, ok := change.record()
+	assert.True(t, ok)
+	assert.Equal(t, "AAAA", record.ShortID)
+	assert.Equal(t, "https://a.com", record.OriginalURL)
+	assert.Equal(t, "", record.UserID)
+	assert.True(t, record.ExpiresAt.Equal(time.Date(2030, 1, 2, 3, 4, 5, 123456000, time.UTC)))
+
+	// Строка не поместилась в уведомление
+	change, err = parseChange(`{"op":"UPDATE","short_id":"AAAA"}`)
+	assert.NoError(t, err)
+	_, ok = change.record()
+	assert.False(t, ok)
+
+	_, err = parseChange(`not json`)
+	assert.Error(t, err)
+}
+
+func TestPostgresListen(t *testing.T) {
+	skipCI(t)
+
+	ctx := context.Background()
+	conn, err := CreatePool(config.Params.DatabaseDSN)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, MigrateUp(conn, migrations.FS))
+	_, err = conn.Exec("DELETE FROM urls WHERE original_url LIKE 'https://listen.test/%'")
+	assert.NoError(t, err)
+
+	// Два экземпляра сервиса с общей базой данных
+	writer := NewPostgres(conn)
+	reader := NewPostgres(conn)
+	reader.Listen(config.Params.DatabaseDSN)
+	// Close останавливает слушателя и закрывает общий пул соединений
+	defer reader.Close()
+
+	_, _, err = writer.Save(ctx, repository.Record{ShortID: "listen-a", OriginalURL: "https://listen.test/a", UserID: "user-1"})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		record, err := reader.Get(ctx, "listen-a")
+		return err == nil && record.UserID == "user-1"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, writer.Delete(ctx, "user-1", []string{"listen-a"}))
+	assert.Eventually(t, func() bool {
+		record, err := reader.Get(ctx, "listen-a")
+		return err == nil && record.IsDeleted
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, err = conn.Exec("DELETE FROM urls WHERE short_id = 'listen-a'")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		_, err := reader.Get(ctx, "listen-a")
+		return err != nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
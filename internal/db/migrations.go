@@ -1,60 +1,367 @@
 // Мигграции.
 // Файлы миграций содержат SQL команды для создания объектов базы данных.
-// Файлы миграций находятся в отдельной директории (./migrations), имеют расширение *.up.sql,
-// сортируются по имени и выполняются в порядке возрастания.
+// Файлы миграций встроены в исполняемый файл (см. пакет migrations) и имеют имена вида
+// <версия>_<название>.up.sql и <версия>_<название>.down.sql, например 01_tables.up.sql.
+// Миграции применяются в порядке возрастания версии, каждая в своей транзакции.
+// Примененные версии и контрольные суммы их файлов записываются в таблицу schema_migrations,
+// поэтому при следующем запуске применяются только новые миграции.
+// Если SQL миграции отличается для разных СУБД, то рядом с общим файлом кладется файл для конкретной СУБД
+// с именем диалекта перед расширением, например 02_short_id_seq.sqlite.up.sql.
+// Такой файл выполняется вместо общего, а файлы других диалектов пропускаются.
 
 package db
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io/fs"
 	"path"
-
-	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/rs/zerolog/log"
 )
 
-// MigrateUp порождает объекты базы данных
-// dirname - директория с файлами миграций
-func MigrateUp(dirname string) error {
-	files, err := os.ReadDir(dirname)
+// Диалекты SQL. Совпадают с именами драйверов database/sql.
+const (
+	dialectPostgres = "postgres"
+	dialectSQLite   = "sqlite"
+)
+
+// dialects - диалекты, для которых могут быть файлы миграций.
+var dialects = []string{dialectPostgres, dialectSQLite}
+
+// Расширения файлов миграций
+const (
+	upSuffix   = ".up.sql"
+	downSuffix = ".down.sql"
+)
+
+// migrationLockKey - ключ advisory-блокировки PostgreSQL, под которой выполняются миграции,
+// чтобы одновременно запущенные экземпляры сервиса не применяли их параллельно.
+const migrationLockKey int64 = 0x75726c73686f7274
+
+// ErrMigrationModified - файл уже примененной миграции изменился.
+var ErrMigrationModified = errors.New("applied migration has been modified")
+
+// ErrNoDownMigration - у миграции нет файла отката.
+var ErrNoDownMigration = errors.New("migration has no down file")
+
+// Migration - миграция, прочитанная из файлов.
+type Migration struct {
+	// Version - версия, числовой префикс имени файла
+	Version int64
+	// Name - название миграции, часть имени файла после версии
+	Name string
+	// Up, Down - SQL применения и отката. Down пустой, если файла отката нет.
+	Up, Down string
+	// Checksum - контрольная сумма SQL применения
+	Checksum string
+}
+
+// MigrationState - состояние миграции в базе данных.
+type MigrationState struct {
+	Version int64
+	Name    string
+	// Applied - миграция применена
+	Applied bool
+	// AppliedAt - момент применения
+	AppliedAt time.Time
+	// Modified - файл миграции изменился после применения
+	Modified bool
+	// Missing - миграция применена, но ее файла нет
+	Missing bool
+}
+
+// appliedMigration - строка таблицы schema_migrations.
+type appliedMigration struct {
+	Version   int64     `db:"version"`
+	Name      string    `db:"name"`
+	Checksum  string    `db:"checksum"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+// createSchemaMigrations - создает таблицу примененных миграций. SQL подходит для всех диалектов.
+const createSchemaMigrations = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version BIGINT PRIMARY KEY,
+    name TEXT NOT NULL,
+    checksum TEXT NOT NULL,
+    applied_at TIMESTAMP NOT NULL
+)`
+
+// LoadMigrations - читает миграции диалекта dialect из файловой системы fsys, упорядоченные по версии.
+func LoadMigrations(fsys fs.FS, dialect string) ([]Migration, error) {
+	files, err := fs.ReadDir(fsys, ".")
 	if err != nil {
-		log.Error().Err(err).Msg("MigrateUp error in directory")
-		return err
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, suffix := range []string{upSuffix, downSuffix} {
+		for _, fileName := range selectDialectFiles(files, dialect, suffix) {
+			version, name, err := parseMigrationName(fileName, dialect, suffix)
+			if err != nil {
+				return nil, err
+			}
+			b, err := fs.ReadFile(fsys, fileName)
+			if err != nil {
+				return nil, err
+			}
+			m := byVersion[version]
+			if m == nil {
+				m = &Migration{Version: version, Name: name}
+				byVersion[version] = m
+			}
+			if suffix == upSuffix {
+				m.Up = string(b)
+				sum := sha256.Sum256(b)
+				m.Checksum = hex.EncodeToString(sum[:])
+			} else {
+				m.Down = string(b)
+			}
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Checksum == "" {
+			return nil, fmt.Errorf("migration %02d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// parseMigrationName - извлекает версию и название миграции из имени файла.
+func parseMigrationName(fileName, dialect, suffix string) (version int64, name string, err error) {
+	base := strings.TrimSuffix(strings.TrimSuffix(fileName, suffix), "."+dialect)
+	prefix, name, _ := strings.Cut(base, "_")
+	version, err = strconv.ParseInt(prefix, 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("migration file %s: invalid version: %w", fileName, err)
 	}
-	return executeSQLFiles(files, dirname, ".up.sql")
+	return version, name, nil
 }
 
-// executeSQLFiles выполняем SQL команды в файлах с расширением filenameSuffix в директории dirname
-func executeSQLFiles(files []fs.DirEntry, dirname, filenameSuffix string) error {
+// selectDialectFiles - отбирает имена файлов с расширением filenameSuffix для диалекта dialect.
+// Файл диалекта заменяет общий файл с тем же именем, файлы других диалектов отбрасываются.
+// Порядок файлов сохраняется.
+func selectDialectFiles(files []fs.DirEntry, dialect, filenameSuffix string) []string {
+	names := make(map[string]bool, len(files))
+	for _, file := range files {
+		names[file.Name()] = true
+	}
 
+	selected := make([]string, 0, len(files))
 	for _, file := range files {
 		fileName := file.Name()
-		if strings.HasSuffix(fileName, filenameSuffix) {
-			log.Info().Str("filename", fileName).Msg("Executing")
-			if !IsConnected() {
-				log.Error().Msg("executeSQLFiles(). No connection to DB")
-				return errors.New("executeSQLFiles(). No connection to DB")
+		if !strings.HasSuffix(fileName, filenameSuffix) {
+			continue
+		}
+		base := strings.TrimSuffix(fileName, filenameSuffix)
+		if fileDialect := strings.TrimPrefix(path.Ext(base), "."); isDialect(fileDialect) {
+			// Файл диалекта
+			if fileDialect == dialect {
+				selected = append(selected, fileName)
 			}
-			_, err := DB.Exec(ReadTextFile(path.Join(dirname, fileName)))
+			continue
+		}
+		// Общий файл, если для диалекта нет своего
+		if !names[base+"."+dialect+filenameSuffix] {
+			selected = append(selected, fileName)
+		}
+	}
+	return selected
+}
+
+// isDialect - проверяет, является ли name именем известного диалекта.
+func isDialect(name string) bool {
+	for _, d := range dialects {
+		if name == d {
+			return true
+		}
+	}
+	return false
+}
+
+// MigrateUp применяет непримененные миграции.
+// conn - пул соединений с базой данных. Диалект миграций определяется по имени драйвера.
+// fsys - файловая система с файлами миграций
+// Возвращает ErrMigrationModified, если файл уже примененной миграции изменился.
+func MigrateUp(conn *sqlx.DB, fsys fs.FS) error {
+	migrations, err := LoadMigrations(fsys, conn.DriverName())
+	if err != nil {
+		log.Error().Err(err).Msg("MigrateUp. Cannot load migrations")
+		return err
+	}
+
+	ctx := context.Background()
+	return withMigrationLock(ctx, conn, func(c *sqlx.Conn) error {
+		applied, err := getAppliedMigrations(ctx, c)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if a, ok := applied[m.Version]; ok {
+				if a.Checksum != m.Checksum {
+					return fmt.Errorf("%w: %02d_%s", ErrMigrationModified, m.Version, m.Name)
+				}
+				continue
+			}
+			log.Info().Int64("version", m.Version).Str("name", m.Name).Msg("Applying migration")
+			err := inTx(ctx, c, func(tx *sqlx.Tx) error {
+				if _, err := tx.ExecContext(ctx, m.Up); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx,
+					tx.Rebind("INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)"),
+					m.Version, m.Name, m.Checksum, time.Now().UTC())
+				return err
+			})
 			if err != nil {
-				log.Error().Err(err).Msg("Query Execution error")
+				log.Error().Err(err).Int64("version", m.Version).Msg("Migration failed")
+				return fmt.Errorf("migration %02d_%s: %w", m.Version, m.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// MigrateDown откатывает n последних примененных миграций в порядке убывания версии.
+// Возвращает ErrNoDownMigration, если у откатываемой миграции нет файла отката.
+func MigrateDown(conn *sqlx.DB, fsys fs.FS, n int) error {
+	migrations, err := LoadMigrations(fsys, conn.DriverName())
+	if err != nil {
+		return err
+	}
+	byVersion := make(map[int64]Migration, len(migrations))
+	for _, m := range migrations {
+		byVersion[m.Version] = m
+	}
+
+	ctx := context.Background()
+	return withMigrationLock(ctx, conn, func(c *sqlx.Conn) error {
+		applied, err := getAppliedMigrations(ctx, c)
+		if err != nil {
+			return err
+		}
+		versions := make([]int64, 0, len(applied))
+		for version := range applied {
+			versions = append(versions, version)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+		for _, version := range versions[:min(max(n, 0), len(versions))] {
+			m, ok := byVersion[version]
+			if !ok || m.Down == "" {
+				return fmt.Errorf("%w: %02d_%s", ErrNoDownMigration, version, applied[version].Name)
+			}
+			log.Info().Int64("version", m.Version).Str("name", m.Name).Msg("Reverting migration")
+			err := inTx(ctx, c, func(tx *sqlx.Tx) error {
+				if _, err := tx.ExecContext(ctx, m.Down); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx, tx.Rebind("DELETE FROM schema_migrations WHERE version = ?"), m.Version)
 				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %02d_%s: %w", m.Version, m.Name, err)
 			}
 		}
+		return nil
+	})
+}
+
+// MigrationStatus возвращает состояние всех известных миграций, упорядоченных по версии,
+// включая примененные миграции, файлов которых нет.
+func MigrationStatus(conn *sqlx.DB, fsys fs.FS) ([]MigrationState, error) {
+	migrations, err := LoadMigrations(fsys, conn.DriverName())
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	ctx := context.Background()
+	var applied map[int64]appliedMigration
+	err = withMigrationLock(ctx, conn, func(c *sqlx.Conn) (err error) {
+		applied, err = getAppliedMigrations(ctx, c)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		state := MigrationState{Version: m.Version, Name: m.Name}
+		if a, ok := applied[m.Version]; ok {
+			state.Applied = true
+			state.AppliedAt = a.AppliedAt
+			state.Modified = a.Checksum != m.Checksum
+			delete(applied, m.Version)
+		}
+		states = append(states, state)
+	}
+	for _, a := range applied {
+		states = append(states, MigrationState{Version: a.Version, Name: a.Name, Applied: true, AppliedAt: a.AppliedAt, Missing: true})
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Version < states[j].Version })
+	return states, nil
 }
 
-// ReadTextFile  - читает текстовый файл и возвращает его содержимое в виде строки.
-// fileName - имя файла
-func ReadTextFile(fileName string) string {
-	b, err := os.ReadFile(fileName)
+// withMigrationLock - выполняет fn на выделенном соединении, создав таблицу schema_migrations.
+// В PostgreSQL на время выполнения берется advisory-блокировка migrationLockKey.
+// В SQLite одновременную запись исключает блокировка файла базы данных.
+func withMigrationLock(ctx context.Context, conn *sqlx.DB, fn func(c *sqlx.Conn) error) error {
+	c, err := conn.Connx(ctx)
 	if err != nil {
-		log.Error().Err(err).Msg("ReadTextFile error")
+		return err
+	}
+	defer c.Close()
+
+	if conn.DriverName() == dialectPostgres {
+		if _, err := c.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+			return err
+		}
+		defer c.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockKey)
+	}
+
+	if _, err := c.ExecContext(ctx, createSchemaMigrations); err != nil {
+		return err
+	}
+	return fn(c)
+}
+
+// getAppliedMigrations - возвращает примененные миграции по версиям.
+func getAppliedMigrations(ctx context.Context, c *sqlx.Conn) (map[int64]appliedMigration, error) {
+	rows := make([]appliedMigration, 0)
+	err := c.SelectContext(ctx, &rows, "SELECT version, name, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]appliedMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// inTx - выполняет fn в транзакции на соединении c.
+// Транзакция фиксируется, если fn не вернула ошибку, и откатывается в противном случае.
+func inTx(ctx context.Context, c *sqlx.Conn, fn func(tx *sqlx.Tx) error) error {
+	tx, err := c.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
 	}
-	return string(b)
+	return tx.Commit()
 }
@@ -1,19 +1,21 @@
-// Мигграции.
-// Файлы миграций содержат SQL команды для создания объектов базы данных.
-// Файлы миграций находятся в отдельной директории (./migrations), имеют расширение *.up.sql,
-// сортируются по имени и выполняются в порядке возрастания.
-
 package db
 
 import (
+	"os"
 	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vadim-ivlev/url-shortener/internal/config"
+	"github.com/vadim-ivlev/url-shortener/migrations"
 )
 
 func TestMigrateUp(t *testing.T) {
 	// skipCI skips tests in CI environment
 	skipCI(t)
 
-	TryToConnect(1)
+	conn, _ := TryToConnect(config.Params.DatabaseDSN, 1)
+	defer conn.Close()
 
 	type args struct {
 		dirname string
@@ -36,9 +38,94 @@ func TestMigrateUp(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := MigrateUp(tt.args.dirname); (err != nil) != tt.wantErr {
+			if err := MigrateUp(conn, os.DirFS(tt.args.dirname)); (err != nil) != tt.wantErr {
 				t.Errorf("MigrateUp() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
+
+func TestLoadMigrations(t *testing.T) {
+	for _, dialect := range dialects {
+		list, err := LoadMigrations(migrations.FS, dialect)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, list)
+		for i, m := range list {
+			assert.Equal(t, int64(i+1), m.Version)
+			assert.NotEmpty(t, m.Up, m.Name)
+			assert.NotEmpty(t, m.Checksum, m.Name)
+			assert.NotEmpty(t, m.Down, m.Name)
+		}
+	}
+
+	_, err := LoadMigrations(fstest.MapFS{"xx_bad.up.sql": {Data: []byte("SELECT 1")}}, dialectSQLite)
+	assert.Error(t, err)
+}
+
+func TestMigrateDownAndStatus(t *testing.T) {
+	conn, err := OpenSQLite(t.TempDir() + "/db.sqlite")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	fsys := fstest.MapFS{
+		"01_a.up.sql":          {Data: []byte("CREATE TABLE a (id INTEGER);")},
+		"01_a.down.sql":        {Data: []byte("DROP TABLE a;")},
+		"02_b.up.sql":          {Data: []byte("CREATE TABLE b (id INTEGER);")},
+		"02_b.down.sql":        {Data: []byte("DROP TABLE b;")},
+		"03_c.postgres.up.sql": {Data: []byte("CREATE TABLE c (id SERIAL);")},
+		"03_c.up.sql":          {Data: []byte("CREATE TABLE c (id INTEGER);")},
+	}
+
+	// До применения все миграции ожидают
+	states, err := MigrationStatus(conn, fsys)
+	assert.NoError(t, err)
+	assert.Len(t, states, 3)
+	for _, s := range states {
+		assert.False(t, s.Applied)
+	}
+
+	// Повторное применение ничего не делает
+	assert.NoError(t, MigrateUp(conn, fsys))
+	assert.NoError(t, MigrateUp(conn, fsys))
+	states, err = MigrationStatus(conn, fsys)
+	assert.NoError(t, err)
+	for _, s := range states {
+		assert.True(t, s.Applied)
+		assert.False(t, s.AppliedAt.IsZero())
+	}
+
+	// У миграции 03 нет файла отката
+	assert.ErrorIs(t, MigrateDown(conn, fsys, 1), ErrNoDownMigration)
+
+	// Откат 02 после удаления 03 вручную
+	_, err = conn.Exec("DROP TABLE c; DELETE FROM schema_migrations WHERE version = 3")
+	assert.NoError(t, err)
+	assert.NoError(t, MigrateDown(conn, fsys, 1))
+	_, err = conn.Exec("SELECT * FROM b")
+	assert.Error(t, err)
+	_, err = conn.Exec("SELECT * FROM a")
+	assert.NoError(t, err)
+
+	// Откат более чем всех миграций откатывает все
+	assert.NoError(t, MigrateDown(conn, fsys, 10))
+	states, err = MigrationStatus(conn, fsys)
+	assert.NoError(t, err)
+	for _, s := range states {
+		assert.False(t, s.Applied)
+	}
+
+	// Изменение примененной миграции обнаруживается
+	assert.NoError(t, MigrateUp(conn, fsys))
+	fsys["02_b.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE b (id TEXT);")}
+	assert.ErrorIs(t, MigrateUp(conn, fsys), ErrMigrationModified)
+	states, err = MigrationStatus(conn, fsys)
+	assert.NoError(t, err)
+	assert.True(t, states[1].Modified)
+
+	// Примененная миграция без файла
+	delete(fsys, "03_c.up.sql")
+	delete(fsys, "03_c.postgres.up.sql")
+	states, err = MigrationStatus(conn, fsys)
+	assert.NoError(t, err)
+	assert.True(t, states[2].Missing)
+}
@@ -0,0 +1,240 @@
+// Description: Хранилище коротких URL в PostgreSQL, реализующее repository.Repository.
+// Записи дублируются в кеше в памяти (storage.Memory), из которого выполняется чтение по ключу.
+// Списки пользователей, удаление и статистика переходов читаются и пишутся напрямую в базу данных,
+// поэтому учитывают данные всех экземпляров сервиса.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"github.com/vadim-ivlev/url-shortener/internal/analytics"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+	"github.com/vadim-ivlev/url-shortener/internal/storage"
+)
+
+// Postgres - хранилище коротких URL в PostgreSQL с кешем в памяти.
+type Postgres struct {
+	*storage.Memory
+	conn *sqlx.DB
+	health
+	// listener - слушатель изменений, сделанных другими экземплярами сервиса. nil, если не запущен.
+	listener *listener
+}
+
+// NewPostgres создает хранилище, использующее пул соединений conn. Кеш создается пустым.
+func NewPostgres(conn *sqlx.DB) *Postgres {
+	return &Postgres{Memory: storage.NewMemory(), conn: conn}
+}
+
+// Conn возвращает пул соединений с базой данных.
+func (p *Postgres) Conn() *sqlx.DB {
+	return p.conn
+}
+
+// LoadCache - заменяет содержимое кеша записями таблицы urls.
+// Записи, которых больше нет в таблице, например удаленные другим экземпляром сервиса
+// за время разрыва соединения слушателя, в кеше не остаются.
+func (p *Postgres) LoadCache(ctx context.Context) error {
+	rows, err := getRecords(ctx, p.conn)
+	if err != nil {
+		return err
+	}
+	records := make([]repository.Record, 0, len(rows))
+	for _, row := range rows {
+		records = append(records, toRepositoryRecord(row))
+	}
+	p.Reload(records)
+	log.Info().Msgf("%d Records loaded from database", len(records))
+	return nil
+}
+
+// Listen запускает применение к кешу изменений таблицы urls, сделанных другими экземплярами сервиса.
+// Вызывается до LoadCache, чтобы не пропустить изменения, сделанные во время загрузки.
+// Слушатель останавливается методом Close.
+// Параметры:
+// - dsn - строка подключения к базе данных для отдельного соединения слушателя
+func (p *Postgres) Listen(dsn string) {
+	p.listener = startListener(dsn, p)
+}
+
+// applyChange - применяет к кешу изменение строки таблицы urls.
+// Если строка не поместилась в уведомление, то она читается из базы данных.
+func (p *Postgres) applyChange(ctx context.Context, change urlsChange) error {
+	if change.Op == opDelete {
+		p.Remove(change.ShortID)
+		return nil
+	}
+	record, ok := change.record()
+	if !ok {
+		row, err := getRecord(ctx, p.conn, change.ShortID)
+		if err != nil {
+			return err
+		}
+		record = toRepositoryRecord(row)
+	}
+	p.Load(record)
+	return nil
+}
+
+// resync - заменяет кеш записями таблицы urls (см. LoadCache).
+func (p *Postgres) resync(ctx context.Context) error {
+	return p.LoadCache(ctx)
+}
+
+// toRepositoryRecord - преобразует запись таблицы urls в запись хранилища.
+func toRepositoryRecord(record Record) repository.Record {
+	return repository.Record{
+		ShortID:     record.ShortID,
+		OriginalURL: record.OriginalURL,
+		UserID:      record.UserID,
+		IsDeleted:   record.IsDeleted,
+		ExpiresAt:   record.ExpiresAt.Time,
+	}
+}
+
+// Save - сохраняет запись в кеше и, если она новая, в базе данных.
+// Если short_id занят в базе данных другим экземпляром сервиса, возвращает repository.ErrShortIDTaken.
+// Если оригинальный URL сохранен в базе данных другим экземпляром сервиса,
+// возвращает сохраненную запись и *repository.ConflictError.
+// Если запись в базу данных не удалась, запись удаляется из кеша.
+func (p *Postgres) Save(ctx context.Context, record repository.Record) (saved repository.Record, isNew bool, err error) {
+	saved, isNew, err = p.Memory.Save(ctx, record)
+	if err != nil || !isNew {
+		return saved, isNew, err
+	}
+	if err := p.checkAvailable(); err != nil {
+		p.Remove(saved.ShortID)
+		return repository.Record{}, false, err
+	}
+	err = store(ctx, p.conn, saved)
+	if err != nil {
+		p.Remove(saved.ShortID)
+		var conflict *repository.ConflictError
+		if errors.As(err, &conflict) {
+			// URL сохранен другим экземпляром сервиса. Добавить его запись в кеш.
+			p.Load(conflict.Existing)
+			return conflict.Existing, false, err
+		}
+		if isShortIDViolation(err) {
+			return repository.Record{}, false, repository.ErrShortIDTaken
+		}
+		return repository.Record{}, false, p.wrapError(err)
+	}
+	return saved, true, nil
+}
+
+// SaveBatch - сохраняет записи в базе данных в одной транзакции и только после ее фиксации добавляет их в кеш.
+// Ошибка err означает, что транзакция откачена и ни одна запись не сохранена.
+func (p *Postgres) SaveBatch(ctx context.Context, records []repository.Record) (results []repository.SaveResult, err error) {
+	if err := p.checkAvailable(); err != nil {
+		return nil, err
+	}
+	results, err = storeBatch(ctx, p.conn, records)
+	if err != nil {
+		return nil, p.wrapError(err)
+	}
+	for _, result := range results {
+		if result.Err == nil {
+			p.Load(result.Record)
+		}
+	}
+	return results, nil
+}
+
+// ListByUser - читает страницу записей пользователя из базы данных.
+func (p *Postgres) ListByUser(ctx context.Context, userID, afterShortID string, limit int) ([]repository.Record, error) {
+	records, err := getUserRecords(ctx, p.conn, userID, afterShortID, limit)
+	if err != nil {
+		return nil, p.wrapError(err)
+	}
+	result := make([]repository.Record, 0, len(records))
+	for _, record := range records {
+		result = append(result, toRepositoryRecord(record))
+	}
+	return result, nil
+}
+
+// Delete - помечает удаленными записи пользователя в базе данных и, после успешной записи, в кеше.
+// Если запись в базу данных не удалась, то кеш не изменяется.
+func (p *Postgres) Delete(ctx context.Context, userID string, shortIDs []string) error {
+	if err := p.checkAvailable(); err != nil {
+		return err
+	}
+	if err := markDeleted(ctx, p.conn, userID, shortIDs); err != nil {
+		return p.wrapError(err)
+	}
+	p.MarkDeleted(userID, shortIDs)
+	return nil
+}
+
+// DeleteExpired - удаляет из кеша просроченные записи и помечает удаленными просроченные записи в базе данных,
+// в том числе созданные другими экземплярами сервиса.
+// Возвращает количество записей, помеченных в базе данных, или 0 и ошибку, если пометить их не удалось.
+func (p *Postgres) DeleteExpired(ctx context.Context, now time.Time) (n int, err error) {
+	p.PurgeExpired(now)
+	marked, err := markExpired(ctx, p.conn, now)
+	if err != nil {
+		return 0, p.wrapError(err)
+	}
+	return int(marked), nil
+}
+
+// Export - перечисляет неудаленные и непросроченные записи из базы данных, а не из кеша.
+func (p *Postgres) Export(ctx context.Context, fn func(repository.Record) error) error {
+	return p.wrapError(exportRecords(ctx, p.conn, fn))
+}
+
+// Ping - проверяет соединение с базой данных.
+// Если задан наблюдатель за соединением, то возвращает его последнее состояние, не выполняя запрос.
+func (p *Postgres) Ping(ctx context.Context) error {
+	if p.supervisor != nil {
+		return p.checkAvailable()
+	}
+	return p.conn.PingContext(ctx)
+}
+
+// Close - останавливает наблюдателя за соединением, слушателя изменений и закрывает пул соединений с базой данных.
+func (p *Postgres) Close() error {
+	p.stopSupervisor()
+	if p.listener != nil {
+		p.listener.stop()
+	}
+	return p.conn.Close()
+}
+
+// SaveClicks - сохраняет переходы в базу данных.
+func (p *Postgres) SaveClicks(ctx context.Context, clicks []analytics.Click) error {
+	if err := p.checkAvailable(); err != nil {
+		return err
+	}
+	return p.wrapError(storeClicks(ctx, p.conn, clicks))
+}
+
+// ClickStats - возвращает статистику переходов из базы данных.
+// Статистика учитывает переходы на всех экземплярах сервиса.
+func (p *Postgres) ClickStats(ctx context.Context, shortID string) (analytics.Stats, error) {
+	stats, err := getClickStats(ctx, p.conn, shortID)
+	return stats, p.wrapError(err)
+}
+
+// NextSequence - возвращает следующее значение последовательности short_id_seq.
+func (p *Postgres) NextSequence(ctx context.Context) (uint64, error) {
+	if err := p.checkAvailable(); err != nil {
+		return 0, err
+	}
+	n, err := nextSequenceValue(ctx, p.conn)
+	return n, p.wrapError(err)
+}
+
+// AdvanceSequence - устанавливает последовательность short_id_seq так, чтобы следующее значение было больше n.
+func (p *Postgres) AdvanceSequence(ctx context.Context, n uint64) error {
+	if err := p.checkAvailable(); err != nil {
+		return err
+	}
+	return p.wrapError(advanceSequence(ctx, p.conn, n))
+}
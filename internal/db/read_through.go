@@ -0,0 +1,239 @@
+// Description: Хранилище коротких URL в PostgreSQL с чтением через ограниченный кеш (режим кеша lru).
+// В отличие от Postgres, таблица urls не загружается в память при запуске:
+// источником истины остается база данных, а в кеше (storage.LRU) держатся только недавно
+// запрошенные записи и отсутствующие короткие id. Режим рассчитан на таблицы, которые не помещаются в память.
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/vadim-ivlev/url-shortener/internal/analytics"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+	"github.com/vadim-ivlev/url-shortener/internal/storage"
+)
+
+// negativeCacheTTL - время жизни записей кеша об отсутствующих коротких id.
+const negativeCacheTTL = time.Minute
+
+// PostgresReadThrough - хранилище коротких URL в PostgreSQL с ограниченным кешем.
+type PostgresReadThrough struct {
+	conn  *sqlx.DB
+	cache *storage.LRU
+	health
+	// listener - слушатель изменений, сделанных другими экземплярами сервиса. nil, если не запущен.
+	listener *listener
+}
+
+// NewPostgresReadThrough создает хранилище, использующее пул соединений conn и кеш емкостью capacity записей.
+func NewPostgresReadThrough(conn *sqlx.DB, capacity int) *PostgresReadThrough {
+	return &PostgresReadThrough{conn: conn, cache: storage.NewLRU(capacity, negativeCacheTTL)}
+}
+
+// Conn возвращает пул соединений с базой данных.
+func (p *PostgresReadThrough) Conn() *sqlx.DB {
+	return p.conn
+}
+
+// Listen запускает удаление из кеша записей, измененных другими экземплярами сервиса.
+// Слушатель останавливается методом Close.
+func (p *PostgresReadThrough) Listen(dsn string) {
+	p.listener = startListener(dsn, p)
+}
+
+// applyChange - удаляет измененную запись из кеша. Она будет прочитана заново при следующем запросе.
+func (p *PostgresReadThrough) applyChange(ctx context.Context, change urlsChange) error {
+	p.cache.Remove(change.ShortID)
+	return nil
+}
+
+// resync - очищает кеш.
+func (p *PostgresReadThrough) resync(ctx context.Context) error {
+	p.cache.Clear()
+	return nil
+}
+
+// CacheStats возвращает счетчики кеша.
+func (p *PostgresReadThrough) CacheStats() storage.CacheStats {
+	return p.cache.Stats()
+}
+
+// Save - сохраняет запись в базе данных и в кеше.
+// Если оригинальный URL уже сохранен в неудаленной непросроченной записи, то возвращается сохраненная запись и isNew == false.
+// Просроченная запись с тем же URL помечается удаленной, а URL сохраняется под новым коротким id.
+func (p *PostgresReadThrough) Save(ctx context.Context, record repository.Record) (saved repository.Record, isNew bool, err error) {
+	if saved, err := p.cache.PeekByOriginal(record.OriginalURL); err == nil && !saved.IsExpired(time.Now()) {
+		return saved, false, nil
+	}
+	if err := p.checkAvailable(); err != nil {
+		return repository.Record{}, false, err
+	}
+	err = store(ctx, p.conn, record)
+	var conflict *repository.ConflictError
+	switch {
+	case errors.As(err, &conflict):
+		// Источник истины - база данных, поэтому повтор URL - обычный результат, а не конфликт
+		p.cache.Put(conflict.Existing)
+		return conflict.Existing, false, nil
+	case isShortIDViolation(err):
+		return repository.Record{}, false, repository.ErrShortIDTaken
+	case err != nil:
+		return repository.Record{}, false, p.wrapError(err)
+	}
+	p.cache.Put(record)
+	return record, true, nil
+}
+
+// SaveBatch - сохраняет записи в базе данных в одной транзакции и после ее фиксации добавляет их в кеш.
+// Ошибка err означает, что транзакция откачена и ни одна запись не сохранена.
+func (p *PostgresReadThrough) SaveBatch(ctx context.Context, records []repository.Record) (results []repository.SaveResult, err error) {
+	if err := p.checkAvailable(); err != nil {
+		return nil, err
+	}
+	results, err = storeBatch(ctx, p.conn, records)
+	if err != nil {
+		return nil, p.wrapError(err)
+	}
+	for _, result := range results {
+		if result.Err == nil {
+			p.cache.Put(result.Record)
+		}
+	}
+	return results, nil
+}
+
+// Get - возвращает запись из кеша, а если ее там нет - из базы данных, добавляя ее в кеш.
+// Отсутствие записи в базе данных тоже запоминается в кеше.
+func (p *PostgresReadThrough) Get(ctx context.Context, shortID string) (repository.Record, error) {
+	record, err := p.cache.Get(shortID)
+	if !errors.Is(err, storage.ErrCacheMiss) {
+		return record, err
+	}
+	row, err := getRecord(ctx, p.conn, shortID)
+	if errors.Is(err, sql.ErrNoRows) {
+		p.cache.PutNotFound(shortID)
+		return repository.Record{}, repository.ErrNotFound
+	}
+	if err != nil {
+		return repository.Record{}, p.wrapError(err)
+	}
+	record = toRepositoryRecord(row)
+	p.cache.Put(record)
+	return record, nil
+}
+
+// GetByOriginal - возвращает запись по оригинальному URL из кеша, а если ее там нет - из базы данных.
+func (p *PostgresReadThrough) GetByOriginal(ctx context.Context, originalURL string) (repository.Record, error) {
+	record, err := p.cache.GetByOriginal(originalURL)
+	if err == nil {
+		return record, nil
+	}
+	row, err := getRecordByOriginal(ctx, p.conn, originalURL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.Record{}, repository.ErrNotFound
+	}
+	if err != nil {
+		return repository.Record{}, p.wrapError(err)
+	}
+	record = toRepositoryRecord(row)
+	p.cache.Put(record)
+	return record, nil
+}
+
+// ListByUser - читает страницу записей пользователя из базы данных.
+func (p *PostgresReadThrough) ListByUser(ctx context.Context, userID, afterShortID string, limit int) ([]repository.Record, error) {
+	records, err := getUserRecords(ctx, p.conn, userID, afterShortID, limit)
+	if err != nil {
+		return nil, p.wrapError(err)
+	}
+	result := make([]repository.Record, 0, len(records))
+	for _, record := range records {
+		result = append(result, toRepositoryRecord(record))
+	}
+	return result, nil
+}
+
+// Delete - помечает удаленными записи пользователя в базе данных и удаляет их из кеша.
+// Владелец проверяется в базе данных.
+func (p *PostgresReadThrough) Delete(ctx context.Context, userID string, shortIDs []string) error {
+	if err := p.checkAvailable(); err != nil {
+		return err
+	}
+	if err := markDeleted(ctx, p.conn, userID, shortIDs); err != nil {
+		// Записи в базе данных не изменились - кеш остается согласованным с ней
+		return p.wrapError(err)
+	}
+	for _, shortID := range shortIDs {
+		p.cache.Remove(shortID)
+	}
+	return nil
+}
+
+// DeleteExpired - помечает удаленными просроченные записи в базе данных и удаляет их из кеша.
+// Возвращает количество записей, помеченных в базе данных, или 0 и ошибку, если пометить их не удалось.
+func (p *PostgresReadThrough) DeleteExpired(ctx context.Context, now time.Time) (n int, err error) {
+	p.cache.RemoveExpired(now)
+	marked, err := markExpired(ctx, p.conn, now)
+	if err != nil {
+		return 0, p.wrapError(err)
+	}
+	return int(marked), nil
+}
+
+// Export - перечисляет неудаленные и непросроченные записи из базы данных, а не из кеша.
+func (p *PostgresReadThrough) Export(ctx context.Context, fn func(repository.Record) error) error {
+	return p.wrapError(exportRecords(ctx, p.conn, fn))
+}
+
+// Ping - проверяет соединение с базой данных.
+// Если задан наблюдатель за соединением, то возвращает его последнее состояние, не выполняя запрос.
+func (p *PostgresReadThrough) Ping(ctx context.Context) error {
+	if p.supervisor != nil {
+		return p.checkAvailable()
+	}
+	return p.conn.PingContext(ctx)
+}
+
+// Close - останавливает наблюдателя за соединением, слушателя изменений и закрывает пул соединений с базой данных.
+func (p *PostgresReadThrough) Close() error {
+	p.stopSupervisor()
+	if p.listener != nil {
+		p.listener.stop()
+	}
+	return p.conn.Close()
+}
+
+// SaveClicks - сохраняет переходы в базу данных.
+func (p *PostgresReadThrough) SaveClicks(ctx context.Context, clicks []analytics.Click) error {
+	if err := p.checkAvailable(); err != nil {
+		return err
+	}
+	return p.wrapError(storeClicks(ctx, p.conn, clicks))
+}
+
+// ClickStats - возвращает статистику переходов из базы данных.
+func (p *PostgresReadThrough) ClickStats(ctx context.Context, shortID string) (analytics.Stats, error) {
+	stats, err := getClickStats(ctx, p.conn, shortID)
+	return stats, p.wrapError(err)
+}
+
+// NextSequence - возвращает следующее значение последовательности short_id_seq.
+func (p *PostgresReadThrough) NextSequence(ctx context.Context) (uint64, error) {
+	if err := p.checkAvailable(); err != nil {
+		return 0, err
+	}
+	n, err := nextSequenceValue(ctx, p.conn)
+	return n, p.wrapError(err)
+}
+
+// AdvanceSequence - устанавливает последовательность short_id_seq так, чтобы следующее значение было больше n.
+func (p *PostgresReadThrough) AdvanceSequence(ctx context.Context, n uint64) error {
+	if err := p.checkAvailable(); err != nil {
+		return err
+	}
+	return p.wrapError(advanceSequence(ctx, p.conn, n))
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/vadim-ivlev/url-shortener/internal/config"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+	"github.com/vadim-ivlev/url-shortener/migrations"
+)
+
+func TestPostgresReadThrough(t *testing.T) {
+	skipCI(t)
+
+	ctx := context.Background()
+	conn, err := CreatePool(config.Params.DatabaseDSN)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	assert.NoError(t, MigrateUp(conn, migrations.FS))
+	_, err = conn.Exec("DELETE FROM urls WHERE original_url LIKE 'https://read-through.test/%'")
+	assert.NoError(t, err)
+
+	repo := NewPostgresReadThrough(conn, 2)
+	_, isNew, err := repo.Save(ctx, repository.Record{ShortID: "rt-a", OriginalURL: "https://read-through.test/a", UserID: "user-1"})
+	assert.NoError(t, err)
+	assert.True(t, isNew)
+
+	// Повтор URL возвращает сохраненную запись
+	saved, isNew, err := repo.Save(ctx, repository.Record{ShortID: "rt-b", OriginalURL: "https://read-through.test/a"})
+	assert.NoError(t, err)
+	assert.False(t, isNew)
+	assert.Equal(t, "rt-a", saved.ShortID)
+
+	// Запись другого экземпляра читается из базы данных
+	other := NewPostgresReadThrough(conn, 2)
+	record, err := other.Get(ctx, "rt-a")
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", record.UserID)
+	_, err = other.Get(ctx, "rt-a")
+	assert.NoError(t, err)
+	stats := other.CacheStats()
+	assert.Equal(t, int64(1), stats.Hits)
+	assert.Equal(t, int64(1), stats.Misses)
+
+	// Отсутствующий id запоминается
+	_, err = other.Get(ctx, "rt-missing")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	_, err = other.Get(ctx, "rt-missing")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	assert.Equal(t, int64(2), other.CacheStats().Hits)
+
+	// Удаление видно после сброса кеша
+	assert.NoError(t, repo.Delete(ctx, "user-1", []string{"rt-a"}))
+	record, err = repo.Get(ctx, "rt-a")
+	assert.NoError(t, err)
+	assert.True(t, record.IsDeleted)
+
+	// Просроченная запись
+	_, _, err = repo.Save(ctx, repository.Record{ShortID: "rt-c", OriginalURL: "https://read-through.test/c", ExpiresAt: time.Now().Add(time.Minute)})
+	assert.NoError(t, err)
+	n, err := repo.DeleteExpired(ctx, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, n, 1)
+	record, err = repo.Get(ctx, "rt-c")
+	assert.NoError(t, err)
+	assert.True(t, record.IsDeleted)
+}
+
+// TestPostgresReadThroughDeleteFailed - если пометить записи удаленными не удалось, кеш не меняется.
+func TestPostgresReadThroughDeleteFailed(t *testing.T) {
+	ctx := context.Background()
+	// Пул без доступного сервера: соединение устанавливается только при запросе
+	conn, err := sqlx.Open("postgres", "postgres://127.0.0.1:1/none?sslmode=disable&connect_timeout=1")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	repo := NewPostgresReadThrough(conn, 2)
+	repo.cache.Put(repository.Record{ShortID: "rt-a", OriginalURL: "https://read-through.test/a", UserID: "user-1"})
+
+	assert.Error(t, repo.Delete(ctx, "user-1", []string{"rt-a"}))
+	record, err := repo.Get(ctx, "rt-a")
+	assert.NoError(t, err)
+	assert.False(t, record.IsDeleted)
+	assert.Equal(t, int64(1), repo.CacheStats().Hits)
+}
@@ -0,0 +1,279 @@
+// Description: Хранилище коротких URL во встроенной базе данных SQLite, реализующее repository.Repository.
+// Используется, если DSN имеет вид sqlite:///path/db.sqlite (абсолютный путь) или sqlite://data/db.sqlite (относительный).
+// Драйвер modernc.org/sqlite написан на чистом Go и не требует cgo.
+// Так же, как в Postgres, записи дублируются в кеше в памяти.
+// Время хранится в UTC в текстовом формате SQLite, поэтому его можно сравнивать в запросах как строки.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"github.com/vadim-ivlev/url-shortener/internal/analytics"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+	"github.com/vadim-ivlev/url-shortener/internal/storage"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLiteScheme - префикс DSN базы данных SQLite.
+const SQLiteScheme = "sqlite://"
+
+// sqliteParams - параметры соединения с SQLite:
+// ожидание блокировки вместо немедленной ошибки, журнал WAL и запись времени в формате SQLite.
+const sqliteParams = "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_time_format=sqlite"
+
+func init() {
+	// Драйвер modernc.org/sqlite использует параметры вида ?
+	sqlx.BindDriver(dialectSQLite, sqlx.QUESTION)
+}
+
+// SQLitePath - возвращает путь к файлу базы данных из DSN вида sqlite:///path/db.sqlite.
+// ok - false, если DSN не относится к SQLite.
+func SQLitePath(dsn string) (path string, ok bool) {
+	if !strings.HasPrefix(dsn, SQLiteScheme) {
+		return "", false
+	}
+	return strings.TrimPrefix(dsn, SQLiteScheme), true
+}
+
+// OpenSQLite - открывает базу данных SQLite, создавая файл и его директорию, если их нет.
+// Параметры:
+// - path - путь к файлу базы данных
+func OpenSQLite(path string) (*sqlx.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	conn, err := sqlx.Connect(dialectSQLite, path+sqliteParams)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite допускает только одного писателя. Одно соединение исключает ошибки SQLITE_BUSY.
+	conn.SetMaxOpenConns(1)
+	return conn, nil
+}
+
+// SQLite - хранилище коротких URL в SQLite с кешем в памяти.
+type SQLite struct {
+	*storage.Memory
+	conn *sqlx.DB
+}
+
+// NewSQLite создает хранилище, использующее соединение conn, открытое OpenSQLite. Кеш создается пустым.
+func NewSQLite(conn *sqlx.DB) *SQLite {
+	return &SQLite{Memory: storage.NewMemory(), conn: conn}
+}
+
+// Conn возвращает соединение с базой данных.
+func (s *SQLite) Conn() *sqlx.DB {
+	return s.conn
+}
+
+// LoadCache - загружает записи таблицы urls в кеш.
+func (s *SQLite) LoadCache(ctx context.Context) error {
+	records, err := getRecords(ctx, s.conn)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		s.Load(toRepositoryRecord(record))
+	}
+	log.Info().Msgf("%d Records loaded from SQLite", len(records))
+	return nil
+}
+
+// isSQLiteShortIDViolation - проверяет, является ли ошибка нарушением первичного ключа short_id.
+func isSQLiteShortIDViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+}
+
+// storeSQLite - сохраняет запись в таблице urls так же, как store.
+// Если оригинальный URL уже сохранен в неудаленной строке, возвращает *repository.ConflictError с сохраненной записью.
+// SQLite выполняет записи по одной, поэтому после вставки без изменений конфликтующая запись уже видна.
+func storeSQLite(ctx context.Context, conn *sqlx.DB, record repository.Record) error {
+	if err := retireExpired(ctx, conn, []string{record.OriginalURL}, time.Now()); err != nil {
+		return err
+	}
+	res, err := conn.ExecContext(ctx,
+		`INSERT INTO urls (short_id, original_url, user_id, expires_at) VALUES (?, ?, NULLIF(?, ''), ?)
+		ON CONFLICT (original_url) WHERE NOT is_deleted DO NOTHING`,
+		record.ShortID, record.OriginalURL, record.UserID, nullTime(record.ExpiresAt.UTC()))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	var existing Record
+	err = conn.GetContext(ctx, &existing,
+		"SELECT short_id, original_url, COALESCE(user_id, '') AS user_id, is_deleted, expires_at FROM urls WHERE original_url = ? AND NOT is_deleted",
+		record.OriginalURL)
+	if err != nil {
+		return err
+	}
+	return &repository.ConflictError{Existing: toRepositoryRecord(existing)}
+}
+
+// Save - сохраняет запись в кеше и, если она новая, в базе данных.
+// Если оригинальный URL сохранен в базе данных другим процессом,
+// возвращает сохраненную запись и *repository.ConflictError.
+// Если запись в базу данных не удалась, запись удаляется из кеша.
+func (s *SQLite) Save(ctx context.Context, record repository.Record) (saved repository.Record, isNew bool, err error) {
+	saved, isNew, err = s.Memory.Save(ctx, record)
+	if err != nil || !isNew {
+		return saved, isNew, err
+	}
+	err = storeSQLite(ctx, s.conn, saved)
+	if err != nil {
+		s.Remove(saved.ShortID)
+		var conflict *repository.ConflictError
+		if errors.As(err, &conflict) {
+			s.Load(conflict.Existing)
+			return conflict.Existing, false, err
+		}
+		if isSQLiteShortIDViolation(err) {
+			return repository.Record{}, false, repository.ErrShortIDTaken
+		}
+		return repository.Record{}, false, err
+	}
+	return saved, true, nil
+}
+
+// SaveBatch - сохраняет записи в базе данных в одной транзакции и только после ее фиксации добавляет их в кеш.
+// Ошибка err означает, что транзакция откачена и ни одна запись не сохранена.
+func (s *SQLite) SaveBatch(ctx context.Context, records []repository.Record) (results []repository.SaveResult, err error) {
+	results, err = storeBatch(ctx, s.conn, records)
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		if result.Err == nil {
+			s.Load(result.Record)
+		}
+	}
+	return results, nil
+}
+
+// ListByUser - читает страницу неудаленных и непросроченных записей пользователя из базы данных.
+func (s *SQLite) ListByUser(ctx context.Context, userID, afterShortID string, limit int) ([]repository.Record, error) {
+	records := make([]Record, 0)
+	err := s.conn.SelectContext(ctx, &records,
+		`SELECT short_id, original_url, user_id, is_deleted, expires_at FROM urls
+		WHERE user_id = ? AND short_id > ? AND NOT is_deleted
+		AND (expires_at IS NULL OR expires_at > ?)
+		ORDER BY short_id
+		LIMIT ?`,
+		userID, afterShortID, time.Now().UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]repository.Record, 0, len(records))
+	for _, record := range records {
+		result = append(result, toRepositoryRecord(record))
+	}
+	return result, nil
+}
+
+// Delete - помечает удаленными записи пользователя в базе данных и, после успешной записи, в кеше.
+// Если запись в базу данных не удалась, то кеш не изменяется.
+func (s *SQLite) Delete(ctx context.Context, userID string, shortIDs []string) error {
+	if len(shortIDs) == 0 {
+		return nil
+	}
+	query, args, err := sqlx.In("UPDATE urls SET is_deleted = TRUE WHERE user_id = ? AND short_id IN (?)", userID, shortIDs)
+	if err != nil {
+		return err
+	}
+	if _, err := s.conn.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+	s.MarkDeleted(userID, shortIDs)
+	return nil
+}
+
+// DeleteExpired - удаляет из кеша просроченные записи и помечает их удаленными в базе данных.
+// Возвращает количество записей, помеченных в базе данных, или 0 и ошибку, если пометить их не удалось.
+func (s *SQLite) DeleteExpired(ctx context.Context, now time.Time) (n int, err error) {
+	s.PurgeExpired(now)
+	res, err := s.conn.ExecContext(ctx,
+		"UPDATE urls SET is_deleted = TRUE WHERE expires_at <= ? AND NOT is_deleted", now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	marked, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(marked), nil
+}
+
+// Export - перечисляет неудаленные и непросроченные записи из базы данных, а не из кеша.
+func (s *SQLite) Export(ctx context.Context, fn func(repository.Record) error) error {
+	return exportRecords(ctx, s.conn, fn)
+}
+
+// Ping - проверяет соединение с базой данных.
+func (s *SQLite) Ping(ctx context.Context) error {
+	return s.conn.PingContext(ctx)
+}
+
+// Close - закрывает соединение с базой данных.
+func (s *SQLite) Close() error {
+	return s.conn.Close()
+}
+
+// SaveClicks - сохраняет переходы в базу данных одним запросом.
+func (s *SQLite) SaveClicks(ctx context.Context, clicks []analytics.Click) error {
+	if len(clicks) == 0 {
+		return nil
+	}
+	utc := make([]analytics.Click, len(clicks))
+	for i, click := range clicks {
+		click.Time = click.Time.UTC()
+		utc[i] = click
+	}
+	_, err := s.conn.NamedExecContext(ctx,
+		`INSERT INTO clicks (short_id, clicked_at, referrer, user_agent, client_ip)
+		VALUES (:short_id, :clicked_at, :referrer, :user_agent, :client_ip)`,
+		utc)
+	return err
+}
+
+// ClickStats - возвращает статистику переходов из базы данных.
+// Уникальные посетители определяются по паре огрубленный IP + User-Agent.
+func (s *SQLite) ClickStats(ctx context.Context, shortID string) (stats analytics.Stats, err error) {
+	err = s.conn.QueryRowContext(ctx,
+		`SELECT COUNT(*), (SELECT COUNT(*) FROM (SELECT DISTINCT client_ip, user_agent FROM clicks WHERE short_id = ?1))
+		FROM clicks WHERE short_id = ?1`,
+		shortID).Scan(&stats.TotalClicks, &stats.UniqueVisitors)
+	if err != nil {
+		return stats, err
+	}
+	stats.Daily = make([]analytics.DayClicks, 0)
+	err = s.conn.SelectContext(ctx, &stats.Daily,
+		`SELECT strftime('%Y-%m-%d', clicked_at) AS date, COUNT(*) AS clicks
+		FROM clicks WHERE short_id = ?
+		GROUP BY date ORDER BY date`,
+		shortID)
+	return stats, err
+}
+
+// NextSequence - увеличивает счетчик short_id_seq и возвращает его новое значение.
+func (s *SQLite) NextSequence(ctx context.Context) (n uint64, err error) {
+	err = s.conn.QueryRowContext(ctx, "UPDATE short_id_seq SET value = value + 1 RETURNING value").Scan(&n)
+	return n, err
+}
+
+// AdvanceSequence - устанавливает счетчик short_id_seq так, чтобы следующее значение было больше n.
+func (s *SQLite) AdvanceSequence(ctx context.Context, n uint64) error {
+	_, err := s.conn.ExecContext(ctx, "UPDATE short_id_seq SET value = ? WHERE value < ?", n, n)
+	return err
+}
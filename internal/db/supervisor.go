@@ -0,0 +1,232 @@
+// Description: Наблюдение за соединением с базой данных.
+// Supervisor периодически проверяет соединение и хранит его состояние.
+// Пока соединения нет, проверки повторяются с экспоненциально растущей задержкой со случайной составляющей,
+// чтобы экземпляры сервиса не подключались к восстановленной базе данных одновременно.
+// Хранилища PostgreSQL используют состояние, чтобы во время сбоя сразу отказывать в записи
+// ошибкой repository.ErrUnavailable, не дожидаясь таймаута запроса.
+
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+)
+
+// Параметры проверок соединения
+const (
+	// healthCheckInterval - период проверки установленного соединения
+	healthCheckInterval = 5 * time.Second
+	// pingTimeout - таймаут одной проверки
+	pingTimeout = 2 * time.Second
+	// minBackoff, maxBackoff - границы задержки между попытками подключения
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 30 * time.Second
+)
+
+// PoolConfig - ограничения пула соединений. Нулевые значения означают ограничения database/sql по умолчанию.
+type PoolConfig struct {
+	// MaxOpenConns - максимальное количество открытых соединений
+	MaxOpenConns int
+	// MaxIdleConns - максимальное количество простаивающих соединений
+	MaxIdleConns int
+	// ConnMaxLifetime - максимальное время жизни соединения
+	ConnMaxLifetime time.Duration
+	// ConnMaxIdleTime - максимальное время простоя соединения
+	ConnMaxIdleTime time.Duration
+}
+
+// OpenPostgres - создает пул соединений с базой данных PostgreSQL, не устанавливая соединение.
+// Соединение устанавливается при первом запросе. Его состояние отслеживает Supervisor.
+// Параметры:
+// - dsn - строка подключения к базе данных
+// - pool - ограничения пула соединений
+func OpenPostgres(dsn string, pool PoolConfig) (*sqlx.DB, error) {
+	conn, err := sqlx.Open(dialectPostgres, dsn)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetMaxOpenConns(pool.MaxOpenConns)
+	conn.SetMaxIdleConns(pool.MaxIdleConns)
+	conn.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	conn.SetConnMaxIdleTime(pool.ConnMaxIdleTime)
+	return conn, nil
+}
+
+// Supervisor - наблюдатель за соединением с базой данных.
+type Supervisor struct {
+	conn      *sqlx.DB
+	connected atomic.Bool
+	// onConnect - вызывается при каждом установлении соединения
+	onConnect func()
+	// recheck - запрос внеочередной проверки
+	recheck chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	// started - фоновые проверки запущены
+	started bool
+	// stopOnce - защищает от повторной остановки
+	stopOnce sync.Once
+}
+
+// NewSupervisor создает наблюдателя за пулом соединений conn.
+// onConnect вызывается при каждом установлении соединения, в том числе после сбоя. Может быть nil.
+func NewSupervisor(conn *sqlx.DB, onConnect func()) *Supervisor {
+	return &Supervisor{
+		conn:      conn,
+		onConnect: onConnect,
+		recheck:   make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start проверяет соединение и запускает фоновые проверки.
+// Первая проверка выполняется синхронно, поэтому после Start состояние соединения известно.
+func (s *Supervisor) Start() {
+	up := s.check()
+	s.started = true
+	go s.run(up)
+}
+
+// Stop останавливает фоновые проверки.
+func (s *Supervisor) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		if s.started {
+			<-s.done
+		}
+	})
+}
+
+// Connected возвращает true, если последняя проверка соединения была успешной.
+func (s *Supervisor) Connected() bool {
+	return s.connected.Load()
+}
+
+// Recheck запрашивает внеочередную проверку соединения, например, после ошибки соединения в запросе.
+func (s *Supervisor) Recheck() {
+	select {
+	case s.recheck <- struct{}{}:
+	default:
+	}
+}
+
+// run - проверяет соединение, пока наблюдатель не остановлен.
+// Установленное соединение проверяется раз в healthCheckInterval,
+// а попытки подключения повторяются с задержкой, удваивающейся от minBackoff до maxBackoff.
+func (s *Supervisor) run(up bool) {
+	defer close(s.done)
+
+	backoff := minBackoff
+	for {
+		wait := healthCheckInterval
+		if !up {
+			wait = jitter(backoff)
+			backoff = min(2*backoff, maxBackoff)
+		} else {
+			backoff = minBackoff
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-s.recheck:
+		case <-time.After(wait):
+		}
+		up = s.check()
+	}
+}
+
+// jitter - возвращает случайную задержку от d/2 до d.
+func jitter(d time.Duration) time.Duration {
+	return d/2 + rand.N(d/2+1)
+}
+
+// check - проверяет соединение и обновляет состояние.
+func (s *Supervisor) check() (up bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	err := s.conn.PingContext(ctx)
+	up = err == nil
+
+	wasUp := s.connected.Swap(up)
+	switch {
+	case up && !wasUp:
+		log.Info().Msg("Connected to DB")
+		if s.onConnect != nil {
+			s.onConnect()
+		}
+	case !up && wasUp:
+		log.Error().Err(err).Msg("Lost connection to DB")
+	case !up:
+		log.Warn().Err(err).Msg("Waiting for DB connection")
+	}
+	return up
+}
+
+// isConnectionError - проверяет, вызвана ли ошибка отсутствием соединения с базой данных.
+func isConnectionError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// 08 - connection exception, 57P01-57P03 - сервер останавливается или запускается
+		return pqErr.Code.Class() == "08" ||
+			pqErr.Code == "57P01" || pqErr.Code == "57P02" || pqErr.Code == "57P03"
+	}
+	return false
+}
+
+// health - состояние соединения для хранилищ PostgreSQL.
+// Без наблюдателя база данных считается доступной.
+type health struct {
+	supervisor *Supervisor
+}
+
+// SetSupervisor задает наблюдателя за соединением хранилища. Наблюдатель останавливается методом Close хранилища.
+func (h *health) SetSupervisor(s *Supervisor) {
+	h.supervisor = s
+}
+
+// checkAvailable - возвращает repository.ErrUnavailable, если соединения с базой данных нет.
+func (h *health) checkAvailable() error {
+	if h.supervisor != nil && !h.supervisor.Connected() {
+		return repository.ErrUnavailable
+	}
+	return nil
+}
+
+// wrapError - заменяет ошибку соединения ошибкой repository.ErrUnavailable и запрашивает проверку соединения.
+// Остальные ошибки возвращаются без изменений.
+func (h *health) wrapError(err error) error {
+	if err == nil || !isConnectionError(err) {
+		return err
+	}
+	if h.supervisor != nil {
+		h.supervisor.Recheck()
+	}
+	return fmt.Errorf("%w: %v", repository.ErrUnavailable, err)
+}
+
+// stopSupervisor - останавливает наблюдателя, если он задан.
+func (h *health) stopSupervisor() {
+	if h.supervisor != nil {
+		h.supervisor.Stop()
+	}
+}
@@ -0,0 +1,79 @@
+// Description: Хранение переходов по коротким URL в файле событий в формате JSON Lines.
+// Файл событий хранится рядом с файлом хранилища и только дополняется.
+
+package filestorage
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vadim-ivlev/url-shortener/internal/analytics"
+)
+
+// ClicksPath - возвращает путь к файлу событий переходов.
+func (f *File) ClicksPath() string {
+	return f.path + ".clicks"
+}
+
+// storeClicks - дописывает переходы в конец файла событий одной операцией записи.
+// Параметры:
+// - clicks - переходы.
+// Возвращает ошибку, если запись не удалась.
+func (f *File) storeClicks(clicks []analytics.Click) error {
+	if len(clicks) == 0 {
+		return nil
+	}
+	var data []byte
+	for _, click := range clicks {
+		clickJSON, err := json.Marshal(click)
+		if err != nil {
+			return err
+		}
+		data = append(data, clickJSON...)
+		data = append(data, '\n')
+	}
+	return appendToFile(f.ClicksPath(), data)
+}
+
+// loadClicks - загружает все переходы из файла событий в статистику в памяти.
+// Если файла нет, то ничего не делает.
+// Поврежденные строки пропускаются. Строка, прерванная сбоем в конце файла, отрезается
+// (в режиме только для чтения - только пропускается), а о поврежденных строках в середине файла пишется ошибка в лог.
+// Возвращает количество прочитанных переходов.
+func (f *File) loadClicks() (n int, err error) {
+	path := f.ClicksPath()
+	valid, bad, err := scanLines(path, func(line []byte) error {
+		var click analytics.Click
+		if err := json.Unmarshal(line, &click); err != nil {
+			return err
+		}
+		f.Memory.SaveClicks(context.Background(), []analytics.Click{click})
+		n++
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(bad) == 0 {
+		return n, nil
+	}
+	for _, b := range bad {
+		if b.tail {
+			log.Warn().Err(b.err).Msgf("Clicks file %s: torn click at line %d will be truncated", path, b.lineNo)
+		} else {
+			log.Error().Err(b.err).Msgf("Clicks file %s is corrupted: line %d skipped", path, b.lineNo)
+		}
+	}
+	if !f.opts.ReadOnly {
+		// Новые переходы дописываются после последней неповрежденной строки
+		if err := repairTail(path, valid); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
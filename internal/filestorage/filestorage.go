@@ -1,27 +1,141 @@
 // Description: Файловое хранилище для хранения записей в формате JSON.
-// Пример содержимого файла хранилища:
+// Пример содержимого файла хранилища (версия формата 2):
 // ```json
-// {"uuid":"1","short_url":"4rSPg8ap","original_url":"http://yandex.ru"}
-// {"uuid":"2","short_url":"edVPg3ks","original_url":"http://ya.ru"}
-// {"uuid":"3","short_url":"dG56Hqxm","original_url":"http://practicum.yandex.ru"}
+// {"v":2,"uuid":"1","short_id":"4rSPg8ap","original_url":"http://yandex.ru","created_at":"2024-06-01T10:00:00Z","crc":...}
+// {"v":2,"uuid":"2","short_id":"edVPg3ks","original_url":"http://ya.ru","user_id":"u1","created_at":"2024-06-01T10:00:01Z","crc":...}
 // ```
+// Удаление короткого URL записывается отдельной записью с флагом is_deleted:
+// ```json
+// {"v":2,"uuid":"3","short_id":"edVPg3ks","is_deleted":true,"crc":...}
+// ```
+// Записи прежнего формата (без поля v) хранят короткий URL целиком в поле short_url:
+// ```json
+// {"uuid":"1","short_url":"http://localhost:8080/4rSPg8ap","original_url":"http://yandex.ru"}
+// ```
+// Они читаются, но новые записи всегда пишутся в текущем формате. Файл прежнего формата
+// переводится в текущий функцией Upgrade или при сжатии.
+// При открытии хранилища все записи загружаются в индекс в памяти (storage.Memory),
+// и чтение выполняется из него. Новые записи дописываются в конец файла (см. journal.go).
 
 package filestorage
 
 import (
-	"encoding/json"
+	"context"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
-	"github.com/vadim-ivlev/url-shortener/internal/config"
+	"github.com/rs/zerolog/log"
+	"github.com/vadim-ivlev/url-shortener/internal/analytics"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+	"github.com/vadim-ivlev/url-shortener/internal/storage"
 )
 
+// formatVersion - текущая версия формата записей файла хранилища.
+const formatVersion = 2
+
 // FileStorageRecord - структура для хранения записи в файловом хранилище.
 type FileStorageRecord struct {
-	UUID        string `json:"uuid"`
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
+	// Version - версия формата записи. 0 означает прежний формат с полем short_url.
+	Version int    `json:"v,omitempty"`
+	UUID    string `json:"uuid"`
+	// ShortID - короткий id (формат 2).
+	ShortID string `json:"short_id,omitempty"`
+	// ShortURL - короткий URL целиком (прежний формат). Только читается.
+	ShortURL    string `json:"short_url,omitempty"`
+	OriginalURL string `json:"original_url,omitempty"`
+	// UserID - владелец короткого URL.
+	UserID    string `json:"user_id,omitempty"`
+	IsDeleted bool   `json:"is_deleted,omitempty"`
+	// CreatedAt - время создания. nil у записей прежнего формата и записей об удалении.
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	// ExpiresAt - срок действия. nil означает бессрочную запись.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// CRC - контрольная сумма CRC-32C JSON записи без этого поля. 0 означает, что сумма не записана.
+	CRC uint32 `json:"crc,omitempty"`
+}
+
+// File - файловое хранилище коротких URL с индексом в памяти.
+type File struct {
+	*storage.Memory
+	// path - путь к файлу хранилища
+	path string
+	// counterMutex - мьютекс для потокобезопасного изменения файла счетчика
+	counterMutex sync.Mutex
+	// saveMutex - упорядочивает сохранение и удаление записей, чтобы пачка проверялась и записывалась без вмешательства других записей
+	saveMutex sync.Mutex
+
+	// opts - параметры хранилища
+	opts Options
+	// writeMutex - защищает файл хранилища и поля ниже
+	writeMutex sync.Mutex
+	// file - файл хранилища, открытый для дополнения. nil после Close.
+	file *os.File
+	// size - размер файла хранилища
+	size int64
+	// dirty - в файле есть записи, еще не сброшенные на диск
+	dirty bool
+	// lines - количество записей в файле хранилища
+	lines int
+	// stale - количество устаревших записей в файле хранилища: удаленных записей и записей об удалении
+	stale int
+
+	// lock - файл блокировки хранилища. Блокировка снимается его закрытием.
+	lock *os.File
+
+	// syncStop - закрывается для остановки периодического сброса на диск
+	syncStop chan struct{}
+	// syncDone - закрывается, когда периодический сброс на диск остановлен
+	syncDone chan struct{}
+}
+
+// Open открывает файловое хранилище и загружает его записи и переходы в память.
+// Если файла нет, то хранилище создается пустым.
+// Файл остается открытым и заблокированным от других процессов до вызова Close.
+// Если хранилище используется другим процессом, возвращает ошибку ErrLocked.
+// Параметры:
+// - path - путь к файлу хранилища.
+// - opts - параметры хранилища.
+func Open(path string, opts Options) (*File, error) {
+	if opts.Sync == "" {
+		opts.Sync = SyncAlways
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = defaultSyncInterval
+	}
+	lock, err := acquireLock(path, !opts.ReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{Memory: storage.NewMemory(), path: path, opts: opts, lock: lock}
+	if err := f.load(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	n, err := f.loadClicks()
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	log.Info().Msgf("%d Clicks loaded from filestorage", n)
+
+	if opts.ReadOnly {
+		return f, nil
+	}
+	if err := f.openJournal(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	if opts.Sync == SyncInterval {
+		f.syncStop = make(chan struct{})
+		f.syncDone = make(chan struct{})
+		go f.runSyncer(opts.SyncInterval, f.syncStop, f.syncDone)
+	}
+	return f, nil
 }
 
 // createDirIfNotExists - создает директорию в которой будет храниться файл хранилища, если ее нет.
@@ -37,45 +151,295 @@ func createDirIfNotExists(filePath string) error {
 	return nil
 }
 
-// Store - сохраняет данные в файловое хранилище.
-// Параметры:
-// - shortURL - укороченный URL.
-// - originalURL - оригинальный URL.
-// Возвращает ошибку, если запись не удалась.
-func Store(shortURL, originalURL string) error {
-	// Генерируем новый UUID
-	uuid, err := uuid.NewV7()
+// legacyShortID - извлекает короткий id из короткого URL записи прежнего формата.
+// Короткий id - последний сегмент пути, поэтому он извлекается независимо от того,
+// с каким префиксом коротких URL (параметр -b) была сделана запись.
+func legacyShortID(shortURL string) string {
+	return shortURL[strings.LastIndexByte(shortURL, '/')+1:]
+}
+
+// upgradeRecord - переводит запись прежнего формата в текущий. Записи текущего формата не изменяются.
+func upgradeRecord(record FileStorageRecord) FileStorageRecord {
+	if record.Version >= formatVersion {
+		return record
+	}
+	record.Version = formatVersion
+	record.ShortID = legacyShortID(record.ShortURL)
+	record.ShortURL = ""
+	return record
+}
+
+// load - загружает записи файла хранилища в память.
+// Запись, прерванная сбоем в конце файла, отрезается (в режиме только для чтения - пропускается).
+func (f *File) load() error {
+	records, valid, err := readJournal(f.path)
+	if os.IsNotExist(err) {
+		log.Info().Msg("Filestorage not found. Probably this is the first launch.")
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	// Создаем новую запись
-	record := FileStorageRecord{
-		UUID:        uuid.String(),
-		ShortURL:    shortURL,
-		OriginalURL: originalURL,
+	if !f.opts.ReadOnly {
+		if err := repairTail(f.path, valid); err != nil {
+			return err
+		}
+	}
+
+	legacy := 0
+	for _, record := range records {
+		if record.Version < formatVersion {
+			legacy++
+		}
+		record = upgradeRecord(record)
+		// Запись об удалении помечает ранее загруженную запись как удаленную
+		if record.IsDeleted {
+			f.stale += f.deletedStale(record.ShortID)
+			f.Map().MarkDeleted(record.ShortID)
+			continue
+		}
+		loaded := repository.Record{ShortID: record.ShortID, OriginalURL: record.OriginalURL, UserID: record.UserID}
+		if record.ExpiresAt != nil {
+			loaded.ExpiresAt = *record.ExpiresAt
+		}
+		f.Load(loaded)
+	}
+	f.lines = len(records)
+
+	log.Info().Msgf("%d Records loaded from filestorage", len(records))
+	if legacy > 0 {
+		log.Warn().Msgf("Filestorage has %d records in the legacy format. Run `shortener upgrade-file` to upgrade it", legacy)
+	}
+	return nil
+}
+
+// Save - сохраняет запись в памяти и, если она новая, дописывает ее в файл.
+// Если запись в файл не удалась, запись удаляется из памяти.
+func (f *File) Save(ctx context.Context, record repository.Record) (saved repository.Record, isNew bool, err error) {
+	f.saveMutex.Lock()
+	defer f.saveMutex.Unlock()
+
+	saved, isNew, err = f.Memory.Save(ctx, record)
+	if err != nil || !isNew {
+		return saved, isNew, err
+	}
+	if err := f.appendRecords([]FileStorageRecord{f.newFileRecord(saved)}); err != nil {
+		f.Remove(saved.ShortID)
+		return repository.Record{}, false, err
+	}
+	return saved, true, nil
+}
+
+// SaveBatch - дописывает новые записи пачки в файл одной операцией записи
+// и только после успешной записи добавляет их в память.
+// Ошибка err означает, что ни одна запись не сохранена.
+func (f *File) SaveBatch(ctx context.Context, records []repository.Record) (results []repository.SaveResult, err error) {
+	f.saveMutex.Lock()
+	defer f.saveMutex.Unlock()
+
+	results = f.PlanBatch(records)
+	fileRecords := make([]FileStorageRecord, 0, len(results))
+	for _, result := range results {
+		if result.IsNew {
+			fileRecords = append(fileRecords, f.newFileRecord(result.Record))
+		}
+	}
+	if len(fileRecords) > 0 {
+		if err := f.appendRecords(fileRecords); err != nil {
+			return nil, err
+		}
+	}
+	for _, result := range results {
+		if result.IsNew {
+			f.Load(result.Record)
+		}
 	}
+	return results, nil
+}
 
-	// Преобразуем запись в JSON
-	recordJSON, err := json.Marshal(record)
+// newFileRecord - создает запись файла хранилища для записи о коротком URL.
+func (f *File) newFileRecord(record repository.Record) FileStorageRecord {
+	createdAt := time.Now().UTC()
+	fileRecord := FileStorageRecord{
+		Version:     formatVersion,
+		UUID:        newUUID(),
+		ShortID:     record.ShortID,
+		OriginalURL: record.OriginalURL,
+		UserID:      record.UserID,
+		CreatedAt:   &createdAt,
+	}
+	if !record.ExpiresAt.IsZero() {
+		fileRecord.ExpiresAt = &record.ExpiresAt
+	}
+	return fileRecord
+}
+
+// newUUID - возвращает новый UUID записи. UUID v7 упорядочены по времени создания.
+func newUUID() string {
+	id, err := uuid.NewV7()
 	if err != nil {
+		return uuid.NewString()
+	}
+	return id.String()
+}
+
+// Delete - дописывает в файл записи об удалении записей пользователя и, после успешной записи,
+// помечает их удаленными в памяти. Если запись в файл не удалась, то память не изменяется.
+func (f *File) Delete(ctx context.Context, userID string, shortIDs []string) error {
+	if f.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	// Записи не должны измениться между выбором и пометкой
+	f.saveMutex.Lock()
+	defer f.saveMutex.Unlock()
+
+	deleted := f.Deletable(userID, shortIDs)
+	if err := f.storeDeleted(deleted); err != nil {
 		return err
 	}
+	f.MarkDeleted(userID, deleted)
+	return nil
+}
+
+// DeleteExpired - дописывает в файл записи об удалении просроченных записей и, после успешной записи,
+// удаляет их из памяти. Если запись в файл не удалась, то память не изменяется.
+// В режиме только для чтения записи удаляются только из памяти.
+// Возвращает количество удаленных записей или 0 и ошибку, если записи об удалении не удалось дописать.
+func (f *File) DeleteExpired(ctx context.Context, now time.Time) (n int, err error) {
+	if f.opts.ReadOnly {
+		return len(f.PurgeExpired(now)), nil
+	}
+	// Записи не должны измениться между выбором и удалением
+	f.saveMutex.Lock()
+	defer f.saveMutex.Unlock()
+
+	expired := f.Expired(now)
+	if err := f.storeDeleted(expired); err != nil {
+		return 0, err
+	}
+	f.Purge(expired)
+	return len(expired), nil
+}
+
+// storeDeleted - добавляет в файловое хранилище записи об удалении коротких URL.
+// При загрузке хранилища такие записи помечают ранее сохраненные короткие URL как удаленные.
+// Вызывается до того, как записи помечены удаленными в памяти.
+func (f *File) storeDeleted(shortIDs []string) error {
+	if len(shortIDs) == 0 {
+		return nil
+	}
+	records := make([]FileStorageRecord, 0, len(shortIDs))
+	stale := 0
+	for _, shortID := range shortIDs {
+		records = append(records, FileStorageRecord{Version: formatVersion, UUID: newUUID(), ShortID: shortID, IsDeleted: true})
+		stale += f.deletedStale(shortID)
+	}
+	if err := f.appendRecords(records); err != nil {
+		return err
+	}
+	f.addStale(stale)
+	return nil
+}
+
+// deletedStale - возвращает количество строк файла, которые устаревают при удалении короткого id:
+// 2, если удаляется неудаленная запись (устаревают она и запись об удалении), и 1 (только запись об удалении) в противном случае.
+// Вызывается до того, как запись помечена удаленной в памяти.
+func (f *File) deletedStale(shortID string) int {
+	if f.Map().Get(shortID) != "" && !f.Map().IsDeleted(shortID) {
+		return 2
+	}
+	return 1
+}
 
+// appendToFile - дописывает данные в конец файла, создавая файл и его директорию, если их нет.
+func appendToFile(path string, data []byte) error {
 	// Создаем директорию для файла хранилища, если ее нет
-	if err := createDirIfNotExists(config.Params.FileStoragePath); err != nil {
+	if err := createDirIfNotExists(path); err != nil {
 		return err
 	}
 
 	// Открываем файл для записи (добавляем в конец файла) или создаем новый
-	file, err := os.OpenFile(config.Params.FileStoragePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	// Записываем recordJSON  в файл
-	if _, err := file.Write(append(recordJSON, '\n')); err != nil {
+	// Записываем данные в файл
+	_, err = file.Write(data)
+	return err
+}
+
+// SaveClicks - дописывает переходы в файл событий и учитывает их в статистике в памяти.
+// В режиме только для чтения переходы учитываются только в памяти.
+func (f *File) SaveClicks(ctx context.Context, clicks []analytics.Click) error {
+	f.Memory.SaveClicks(ctx, clicks)
+	if f.opts.ReadOnly {
+		return nil
+	}
+	return f.storeClicks(clicks)
+}
+
+// CounterPath - возвращает путь к файлу счетчика, который хранится рядом с файлом хранилища.
+func (f *File) CounterPath() string {
+	return f.path + ".counter"
+}
+
+// NextSequence - увеличивает на 1 значение счетчика, хранящееся в файле CounterPath(), и возвращает его.
+// Если файла нет, то счетчик начинается с 1.
+// Используется генераторами коротких id на основе счетчика.
+func (f *File) NextSequence(ctx context.Context) (n uint64, err error) {
+	if f.opts.ReadOnly {
+		return 0, ErrReadOnly
+	}
+	f.counterMutex.Lock()
+	defer f.counterMutex.Unlock()
+
+	n, err = f.readCounter()
+	if err != nil {
+		return 0, err
+	}
+	n++
+	if err := f.writeCounter(n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// AdvanceSequence - устанавливает счетчик в файле CounterPath() так, чтобы следующее значение было больше n.
+func (f *File) AdvanceSequence(ctx context.Context, n uint64) error {
+	if f.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	f.counterMutex.Lock()
+	defer f.counterMutex.Unlock()
+
+	current, err := f.readCounter()
+	if err != nil || current >= n {
 		return err
 	}
-	return nil
+	return f.writeCounter(n)
+}
+
+// readCounter - читает значение счетчика из файла CounterPath(). Если файла нет, то возвращает 0.
+// Вызывается под counterMutex.
+func (f *File) readCounter() (n uint64, err error) {
+	b, err := os.ReadFile(f.CounterPath())
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+}
+
+// writeCounter - записывает значение счетчика в файл CounterPath(). Вызывается под counterMutex.
+func (f *File) writeCounter(n uint64) error {
+	path := f.CounterPath()
+	if err := createDirIfNotExists(path); err != nil {
+		return err
+	}
+	// Заменяем файл атомарно и со сбросом на диск, чтобы после сбоя счетчик не вернулся назад
+	return writeFileAtomic(path, []byte(strconv.FormatUint(n, 10)))
 }
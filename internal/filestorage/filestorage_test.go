@@ -0,0 +1,153 @@
+package filestorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+)
+
+// TestDeletedURL - удаленная запись остается удаленной, а ее URL сохраняется под новым коротким id.
+func TestDeletedURL(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir() + "/file-storage.txt"
+
+	f, err := Open(path, Options{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, _, err = f.Save(ctx, repository.Record{ShortID: "AAAA", OriginalURL: "https://a.com", UserID: "user-1"})
+	assert.NoError(t, err)
+	assert.NoError(t, f.Delete(ctx, "user-1", []string{"AAAA"}))
+	saved, isNew, err := f.Save(ctx, repository.Record{ShortID: "BBBB", OriginalURL: "https://a.com", UserID: "user-2"})
+	assert.NoError(t, err)
+	assert.True(t, isNew)
+	assert.Equal(t, "BBBB", saved.ShortID)
+	_, _, err = f.Save(ctx, repository.Record{ShortID: "AAAA", OriginalURL: "https://c.com"})
+	assert.ErrorIs(t, err, repository.ErrShortIDTaken)
+	assert.NoError(t, f.Close())
+
+	// После перезагрузки прежний id остается удаленным, а URL принадлежит новому id
+	f, err = Open(path, Options{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+	record, err := f.Get(ctx, "AAAA")
+	assert.NoError(t, err)
+	assert.Equal(t, repository.Record{ShortID: "AAAA", OriginalURL: "https://a.com", UserID: "user-1", IsDeleted: true}, record)
+	record, err = f.GetByOriginal(ctx, "https://a.com")
+	assert.NoError(t, err)
+	assert.Equal(t, repository.Record{ShortID: "BBBB", OriginalURL: "https://a.com", UserID: "user-2"}, record)
+}
+
+// TestExpiredURL - просроченная запись не продлевается, а ее URL сохраняется под новым коротким id.
+func TestExpiredURL(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir() + "/file-storage.txt"
+
+	f, err := Open(path, Options{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, _, err = f.Save(ctx, repository.Record{ShortID: "AAAA", OriginalURL: "https://a.com", ExpiresAt: time.Now().Add(-time.Second)})
+	assert.NoError(t, err)
+	saved, isNew, err := f.Save(ctx, repository.Record{ShortID: "BBBB", OriginalURL: "https://a.com"})
+	assert.NoError(t, err)
+	assert.True(t, isNew)
+	assert.Equal(t, "BBBB", saved.ShortID)
+	assert.NoError(t, f.Close())
+
+	// После перезагрузки URL принадлежит новому id, а после очистки прежний id повторно не выдается
+	f, err = Open(path, Options{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+	record, err := f.GetByOriginal(ctx, "https://a.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "BBBB", record.ShortID)
+	n, err := f.DeleteExpired(ctx, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	record, err = f.Get(ctx, "AAAA")
+	assert.NoError(t, err)
+	assert.True(t, record.IsDeleted)
+	_, _, err = f.Save(ctx, repository.Record{ShortID: "AAAA", OriginalURL: "https://c.com"})
+	assert.ErrorIs(t, err, repository.ErrShortIDTaken)
+	record, err = f.GetByOriginal(ctx, "https://a.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "BBBB", record.ShortID)
+}
+
+// TestDeleteNotStored - если удаление не записано в файл, то запись в памяти не помечается удаленной.
+func TestDeleteNotStored(t *testing.T) {
+	ctx := context.Background()
+	f, err := Open(t.TempDir()+"/file-storage.txt", Options{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, _, err = f.Save(ctx, repository.Record{ShortID: "AAAA", OriginalURL: "https://a.com", UserID: "user-1"})
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	assert.Error(t, f.Delete(ctx, "user-1", []string{"AAAA"}))
+	record, err := f.Get(ctx, "AAAA")
+	assert.NoError(t, err)
+	assert.False(t, record.IsDeleted)
+}
+
+// TestDeleteExpiredNotStored - если удаление не записано в файл, то просроченная запись остается в памяти.
+func TestDeleteExpiredNotStored(t *testing.T) {
+	ctx := context.Background()
+	f, err := Open(t.TempDir()+"/file-storage.txt", Options{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, _, err = f.Save(ctx, repository.Record{ShortID: "AAAA", OriginalURL: "https://a.com", ExpiresAt: time.Now().Add(-time.Second)})
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	n, err := f.DeleteExpired(ctx, time.Now())
+	assert.Error(t, err)
+	assert.Equal(t, 0, n)
+	record, err := f.Get(ctx, "AAAA")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://a.com", record.OriginalURL)
+	assert.False(t, record.IsDeleted)
+}
+
+// TestStale - устаревшими считаются запись об удалении и удаленная ею неудаленная запись.
+func TestStale(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir() + "/file-storage.txt"
+
+	f, err := Open(path, Options{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, _, err = f.Save(ctx, repository.Record{ShortID: "AAAA", OriginalURL: "https://a.com", UserID: "user-1", ExpiresAt: time.Now().Add(-time.Second)})
+	assert.NoError(t, err)
+	_, _, err = f.Save(ctx, repository.Record{ShortID: "BBBB", OriginalURL: "https://b.com", ExpiresAt: time.Now().Add(-time.Second)})
+	assert.NoError(t, err)
+	assert.NoError(t, f.Delete(ctx, "user-1", []string{"AAAA"}))
+	assert.Equal(t, 2, f.stale)
+
+	// Удаленная владельцем просроченная запись устаревает один раз
+	n, err := f.DeleteExpired(ctx, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 5, f.stale)
+	assert.NoError(t, f.Close())
+
+	// При загрузке устаревшие строки считаются так же
+	f, err = Open(path, Options{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+	assert.Equal(t, 5, f.stale)
+	assert.Equal(t, 5, f.lines)
+}
@@ -0,0 +1,489 @@
+// Description: Запись и чтение файла хранилища.
+// Файл открыт все время работы хранилища и только дополняется. Каждая запись содержит
+// контрольную сумму CRC-32C, по которой при загрузке обнаруживается запись, прерванная сбоем.
+// Такая запись в конце файла отрезается. Записи без контрольной суммы (созданные прежними версиями) принимаются без проверки.
+// Сброс записей на диск (fsync) выполняется в соответствии с политикой SyncPolicy.
+// Когда устаревших строк (удаленных записей и записей об удалении) становится больше половины файла,
+// файл сжимается: живые записи переписываются во временный файл, который атомарно заменяет старый.
+
+package filestorage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vadim-ivlev/url-shortener/internal/metrics"
+)
+
+// SyncPolicy - политика сброса записей файла хранилища на диск (fsync).
+type SyncPolicy string
+
+const (
+	// SyncAlways - сбрасывать на диск каждую запись. Сохраненная запись не теряется при сбое.
+	SyncAlways SyncPolicy = "always"
+	// SyncInterval - сбрасывать на диск периодически. При сбое теряются записи последнего периода.
+	SyncInterval SyncPolicy = "interval"
+	// SyncNever - не сбрасывать на диск, полагаясь на операционную систему.
+	SyncNever SyncPolicy = "never"
+)
+
+// ParseSyncPolicy - возвращает политику сброса на диск по ее названию. Пустая строка означает SyncAlways.
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch policy := SyncPolicy(s); policy {
+	case "":
+		return SyncAlways, nil
+	case SyncAlways, SyncInterval, SyncNever:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown file sync policy %q", s)
+	}
+}
+
+// Options - параметры файлового хранилища.
+type Options struct {
+	// Sync - политика сброса записей на диск. Пустое значение означает SyncAlways.
+	Sync SyncPolicy
+	// SyncInterval - период сброса на диск для политики SyncInterval. Нулевое значение означает defaultSyncInterval.
+	SyncInterval time.Duration
+	// ReadOnly - открыть хранилище только для чтения с разделяемой блокировкой (см. lock.go).
+	// Изменение записей завершается ошибкой ErrReadOnly.
+	ReadOnly bool
+}
+
+// defaultSyncInterval - период сброса на диск по умолчанию для политики SyncInterval.
+const defaultSyncInterval = time.Second
+
+// compactMinStale - минимальное количество устаревших строк файла, при котором выполняется автоматическое сжатие.
+const compactMinStale = 1000
+
+// ErrCorrupted - запись в середине файла хранилища повреждена.
+var ErrCorrupted = errors.New("file storage is corrupted")
+
+// errChecksum - контрольная сумма записи не совпадает.
+var errChecksum = errors.New("checksum mismatch")
+
+// crcTable - таблица CRC-32C (Castagnoli), которая вычисляется аппаратно на большинстве процессоров.
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// encodeRecord - кодирует запись в строку файла хранилища с контрольной суммой.
+// Контрольная сумма вычисляется по JSON записи без поля crc.
+func encodeRecord(record FileStorageRecord) ([]byte, error) {
+	record.CRC = 0
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	record.CRC = crc32.Checksum(data, crcTable)
+	data, err = json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// decodeRecord - декодирует строку файла хранилища и проверяет ее контрольную сумму, если она есть.
+func decodeRecord(line []byte) (record FileStorageRecord, err error) {
+	if err := json.Unmarshal(line, &record); err != nil {
+		return FileStorageRecord{}, err
+	}
+	if record.CRC == 0 {
+		return record, nil
+	}
+	crc := record.CRC
+	record.CRC = 0
+	data, err := json.Marshal(record)
+	if err != nil {
+		return FileStorageRecord{}, err
+	}
+	if crc32.Checksum(data, crcTable) != crc {
+		return FileStorageRecord{}, errChecksum
+	}
+	return record, nil
+}
+
+// readJournal - читает записи файла хранилища.
+// Поврежденная последняя строка считается записью, прерванной сбоем (оборванным хвостом):
+// возвращаются записи до нее, а valid - размер неповрежденной части файла.
+// Если повреждена строка в середине файла, возвращается ошибка ErrCorrupted.
+// Возвращает os.ErrNotExist, если файла нет.
+// Параметры:
+// - path - путь к файлу хранилища.
+func readJournal(path string) (records []FileStorageRecord, valid int64, err error) {
+	valid, bad, err := scanLines(path, func(line []byte) error {
+		record, err := decodeRecord(line)
+		if err == nil {
+			records = append(records, record)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, b := range bad {
+		if !b.tail {
+			return nil, 0, fmt.Errorf("%w: %s line %d: %v", ErrCorrupted, path, b.lineNo, b.err)
+		}
+		log.Warn().Err(b.err).Msgf("Filestorage %s: torn record at line %d will be truncated", path, b.lineNo)
+	}
+	return records, valid, nil
+}
+
+// badLine - строка файла, которую не удалось декодировать.
+type badLine struct {
+	lineNo int
+	err    error
+	// tail - строка является оборванным хвостом файла: это единственная поврежденная строка
+	// после последней декодированной и за ней нет других строк
+	tail bool
+}
+
+// scanLines - читает непустые строки файла path и передает каждую в decode.
+// Строки, которые decode вернул с ошибкой, пропускаются и возвращаются в bad.
+// valid - размер части файла до конца последней декодированной строки (см. repairTail).
+// Возвращает os.ErrNotExist, если файла нет.
+func scanLines(path string, decode func(line []byte) error) (valid int64, bad []badLine, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	// tailStart - индекс в bad первой поврежденной строки после последней декодированной
+	tailStart := 0
+	for lineNo := 1; ; lineNo++ {
+		raw, err := reader.ReadBytes('\n')
+		offset += int64(len(raw))
+		if line := bytes.TrimSpace(raw); len(line) > 0 {
+			if decodeErr := decode(line); decodeErr != nil {
+				bad = append(bad, badLine{lineNo: lineNo, err: decodeErr})
+			} else {
+				tailStart = len(bad)
+			}
+		}
+		if tailStart == len(bad) {
+			valid = offset
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	if tailStart == len(bad)-1 {
+		bad[tailStart].tail = true
+	}
+	return valid, bad, nil
+}
+
+// repairTail - отрезает оборванный хвост файла после первых valid байт
+// и дописывает перевод строки, если последняя запись им не заканчивается.
+func repairTail(path string, valid int64) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() > valid {
+		if err := file.Truncate(valid); err != nil {
+			return err
+		}
+	}
+	if valid > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, valid-1); err != nil {
+			return err
+		}
+		if last[0] != '\n' {
+			if _, err := file.WriteAt([]byte{'\n'}, valid); err != nil {
+				return err
+			}
+		}
+	}
+	return file.Sync()
+}
+
+// openJournal - открывает файл хранилища для дополнения, создавая файл и его директорию, если их нет.
+func (f *File) openJournal() error {
+	if err := createDirIfNotExists(f.path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// appendRecords - дописывает записи в конец файла хранилища одной операцией записи
+// и сбрасывает их на диск в соответствии с политикой SyncPolicy.
+// Если запись не удалась, то файл обрезается до прежнего размера, чтобы в нем не осталось части записей.
+func (f *File) appendRecords(records []FileStorageRecord) error {
+	var data []byte
+	for _, record := range records {
+		line, err := encodeRecord(record)
+		if err != nil {
+			return err
+		}
+		data = append(data, line...)
+	}
+
+	f.writeMutex.Lock()
+	defer f.writeMutex.Unlock()
+
+	if f.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	start := time.Now()
+	defer func() { metrics.FileWriteDuration.Observe(time.Since(start).Seconds()) }()
+	if _, err := f.file.Write(data); err != nil {
+		f.rollback()
+		return err
+	}
+	if f.opts.Sync == SyncAlways {
+		if err := f.file.Sync(); err != nil {
+			f.rollback()
+			return err
+		}
+	} else {
+		f.dirty = true
+	}
+	f.size += int64(len(data))
+	f.lines += len(records)
+	return nil
+}
+
+// rollback - обрезает файл до размера перед неудавшейся записью. Вызывается под writeMutex.
+func (f *File) rollback() {
+	if err := f.file.Truncate(f.size); err != nil {
+		log.Error().Err(err).Msg("Cannot truncate filestorage after failed write")
+	}
+}
+
+// runSyncer - периодически сбрасывает записи на диск (политика SyncInterval), пока не закрыт канал stop.
+func (f *File) runSyncer(interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := f.Sync(); err != nil {
+				log.Error().Err(err).Msg("Cannot sync filestorage")
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Sync - сбрасывает на диск записи, которые еще не сброшены.
+func (f *File) Sync() error {
+	f.writeMutex.Lock()
+	defer f.writeMutex.Unlock()
+	return f.syncLocked()
+}
+
+// syncLocked - сбрасывает записи на диск. Вызывается под writeMutex.
+func (f *File) syncLocked() error {
+	if f.file == nil || !f.dirty {
+		return nil
+	}
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+	f.dirty = false
+	return nil
+}
+
+// Close - сбрасывает записи на диск, закрывает файл хранилища и снимает блокировку.
+func (f *File) Close() error {
+	if f.syncStop != nil {
+		close(f.syncStop)
+		<-f.syncDone
+		f.syncStop = nil
+	}
+
+	f.writeMutex.Lock()
+	defer f.writeMutex.Unlock()
+	var err error
+	if f.file != nil {
+		err = f.syncLocked()
+		if closeErr := f.file.Close(); err == nil {
+			err = closeErr
+		}
+		f.file = nil
+	}
+	if f.lock != nil {
+		if closeErr := f.lock.Close(); err == nil {
+			err = closeErr
+		}
+		f.lock = nil
+	}
+	return err
+}
+
+// addStale - учитывает n устаревших строк и сжимает файл, если их стало больше половины.
+func (f *File) addStale(n int) {
+	f.writeMutex.Lock()
+	defer f.writeMutex.Unlock()
+
+	f.stale += n
+	if f.stale < compactMinStale || 2*f.stale < f.lines {
+		return
+	}
+	if err := f.compactLocked(time.Now()); err != nil {
+		log.Error().Err(err).Msg("Cannot compact filestorage")
+	}
+}
+
+// Compact - сжимает файл хранилища, удаляя из него удаленные и просроченные записи и записи об удалении.
+// Живые записи записываются во временный файл, который затем атомарно заменяет файл хранилища,
+// поэтому после сбоя во время сжатия остается либо прежний, либо сжатый файл.
+// Записи в хранилище во время сжатия ожидают его завершения.
+func (f *File) Compact() error {
+	f.writeMutex.Lock()
+	defer f.writeMutex.Unlock()
+	return f.compactLocked(time.Now())
+}
+
+// compactLocked - сжимает файл хранилища, оставляя записи, действующие в момент now. Вызывается под writeMutex.
+func (f *File) compactLocked(now time.Time) error {
+	if f.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	records, _, err := readJournal(f.path)
+	if err != nil {
+		return err
+	}
+
+	// Последняя запись каждого короткого id в порядке первого появления.
+	// Короткий id, удаленный и сохраненный заново, входит в порядок один раз.
+	order := make([]string, 0, len(records))
+	seen := make(map[string]bool, len(records))
+	live := make(map[string]FileStorageRecord, len(records))
+	for _, record := range records {
+		record = upgradeRecord(record)
+		if record.IsDeleted {
+			delete(live, record.ShortID)
+			continue
+		}
+		if !seen[record.ShortID] {
+			seen[record.ShortID] = true
+			order = append(order, record.ShortID)
+		}
+		live[record.ShortID] = record
+	}
+	kept := make([]FileStorageRecord, 0, len(live))
+	for _, shortID := range order {
+		record, ok := live[shortID]
+		if !ok || (record.ExpiresAt != nil && !record.ExpiresAt.After(now)) {
+			continue
+		}
+		kept = append(kept, record)
+	}
+	if err := replaceFile(f.path, kept); err != nil {
+		return err
+	}
+
+	// Прежний дескриптор указывает на замененный файл
+	f.file.Close()
+	f.file = nil
+	if err := f.openJournal(); err != nil {
+		return err
+	}
+	log.Info().Msgf("Filestorage compacted: %d of %d records kept", len(kept), len(records))
+	f.lines = len(kept)
+	f.stale = 0
+	f.dirty = false
+	return nil
+}
+
+// replaceFile - атомарно заменяет файл path файлом с записями records:
+// записи пишутся во временный файл, который сбрасывается на диск и переименовывается в path.
+func replaceFile(path string, records []FileStorageRecord) error {
+	var data []byte
+	for _, record := range records {
+		line, err := encodeRecord(record)
+		if err != nil {
+			return err
+		}
+		data = append(data, line...)
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic - атомарно заменяет содержимое файла path данными data:
+// данные пишутся во временный файл, который сбрасывается на диск и переименовывается в path,
+// после чего на диск сбрасывается директория.
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	if err := writeFileSync(tmpPath, data); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		log.Warn().Err(err).Msg("Cannot sync filestorage directory")
+	}
+	return nil
+}
+
+// writeFileSync - записывает данные в новый файл и сбрасывает их на диск.
+func writeFileSync(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// syncDir - сбрасывает на диск директорию, чтобы переименование файла в ней пережило сбой.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,200 @@
+package filestorage
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vadim-ivlev/url-shortener/internal/analytics"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+)
+
+const testBaseURL = "http://localhost:8080"
+
+func TestParseSyncPolicy(t *testing.T) {
+	for s, want := range map[string]SyncPolicy{"": SyncAlways, "always": SyncAlways, "interval": SyncInterval, "never": SyncNever} {
+		policy, err := ParseSyncPolicy(s)
+		assert.NoError(t, err)
+		assert.Equal(t, want, policy)
+	}
+	_, err := ParseSyncPolicy("sometimes")
+	assert.Error(t, err)
+}
+
+func TestEncodeRecord(t *testing.T) {
+	line, err := encodeRecord(FileStorageRecord{UUID: "1", ShortURL: testBaseURL + "/AAAA", OriginalURL: "https://a.com"})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(line), "}\n"))
+
+	record, err := decodeRecord(line)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://a.com", record.OriginalURL)
+
+	// Измененная запись не проходит проверку контрольной суммы
+	_, err = decodeRecord([]byte(strings.Replace(string(line), "a.com", "b.com", 1)))
+	assert.ErrorIs(t, err, errChecksum)
+
+	// Записи прежних версий без контрольной суммы принимаются
+	record, err = decodeRecord([]byte(`{"uuid":"1","short_url":"http://localhost:8080/AAAA","original_url":"https://a.com"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "https://a.com", record.OriginalURL)
+}
+
+// TestTornTail - запись, прерванная сбоем в конце файла, отрезается при открытии.
+func TestTornTail(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir() + "/file-storage.txt"
+
+	f, err := Open(path, Options{})
+	assert.NoError(t, err)
+	_, _, err = f.Save(ctx, repository.Record{ShortID: "AAAA", OriginalURL: "https://a.com"})
+	assert.NoError(t, err)
+	_, _, err = f.Save(ctx, repository.Record{ShortID: "BBBB", OriginalURL: "https://b.com"})
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	// Обрезать последнюю запись посередине
+	torn := data[:len(data)-10]
+	assert.NoError(t, os.WriteFile(path, torn, 0644))
+
+	f, err = Open(path, Options{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = f.Get(ctx, "AAAA")
+	assert.NoError(t, err)
+	_, err = f.Get(ctx, "BBBB")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+
+	// Новая запись дописывается после отрезанного хвоста
+	_, _, err = f.Save(ctx, repository.Record{ShortID: "CCCC", OriginalURL: "https://c.com"})
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	f, err = Open(path, Options{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+	_, err = f.Get(ctx, "CCCC")
+	assert.NoError(t, err)
+}
+
+// TestCorruptedMiddle - повреждение записи в середине файла - ошибка открытия.
+func TestCorruptedMiddle(t *testing.T) {
+	path := t.TempDir() + "/file-storage.txt"
+	data := `{"uuid":"1","short_url":"http://localhost:8080/AAAA","original_url":"https://a.com","crc":1}` + "\n" +
+		`{"uuid":"2","short_url":"http://localhost:8080/BBBB","original_url":"https://b.com"}` + "\n"
+	assert.NoError(t, os.WriteFile(path, []byte(data), 0644))
+
+	_, err := Open(path, Options{})
+	assert.ErrorIs(t, err, ErrCorrupted)
+}
+
+// TestCorruptedClicks - поврежденные строки файла событий пропускаются, оборванный хвост отрезается.
+func TestCorruptedClicks(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir() + "/file-storage.txt"
+	data := `{"short_id":"AAAA","time":"2024-01-01T00:00:00Z"}` + "\n" +
+		`{"short_id":"AAAA","ti` + "\n" +
+		`{"short_id":"AAAA","time":"2024-01-02T00:00:00Z"}` + "\n" +
+		`{"short_id":"AAAA","time":"2024-01`
+	assert.NoError(t, os.WriteFile(path+".clicks", []byte(data), 0644))
+
+	f, err := Open(path, Options{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	stats, err := f.ClickStats(ctx, "AAAA")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), stats.TotalClicks)
+
+	// Новый переход дописывается после отрезанного хвоста
+	assert.NoError(t, f.SaveClicks(ctx, []analytics.Click{{ShortID: "AAAA", Time: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)}}))
+	assert.NoError(t, f.Close())
+
+	f, err = Open(path, Options{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+	stats, err = f.ClickStats(ctx, "AAAA")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), stats.TotalClicks)
+	assert.Len(t, stats.Daily, 3)
+}
+
+func TestCompact(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir() + "/file-storage.txt"
+
+	f, err := Open(path, Options{Sync: SyncInterval, SyncInterval: 10 * time.Millisecond})
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, _, err = f.Save(ctx, repository.Record{ShortID: "AAAA", OriginalURL: "https://a.com", UserID: "user-1"})
+	assert.NoError(t, err)
+	_, _, err = f.Save(ctx, repository.Record{ShortID: "BBBB", OriginalURL: "https://b.com", UserID: "user-1"})
+	assert.NoError(t, err)
+	_, _, err = f.Save(ctx, repository.Record{ShortID: "CCCC", OriginalURL: "https://c.com", ExpiresAt: time.Now().Add(-time.Minute)})
+	assert.NoError(t, err)
+	assert.NoError(t, f.Delete(ctx, "user-1", []string{"BBBB"}))
+
+	assert.NoError(t, f.Compact())
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"))
+	assert.Contains(t, string(data), "https://a.com")
+
+	// Хранилище продолжает работать после сжатия
+	_, _, err = f.Save(ctx, repository.Record{ShortID: "DDDD", OriginalURL: "https://d.com"})
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	f, err = Open(path, Options{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+	record, err := f.Get(ctx, "AAAA")
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", record.UserID)
+	_, err = f.Get(ctx, "DDDD")
+	assert.NoError(t, err)
+	_, err = f.Get(ctx, "BBBB")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+}
+
+// TestCompactRepeated - короткий id, который встречается в файле после удаления повторно,
+// остается в сжатом файле одной строкой.
+func TestCompactRepeated(t *testing.T) {
+	path := t.TempDir() + "/file-storage.txt"
+	var data []byte
+	for _, record := range []FileStorageRecord{
+		{Version: 2, UUID: "1", ShortID: "AAAA", OriginalURL: "https://a.com", UserID: "user-1"},
+		{Version: 2, UUID: "2", ShortID: "AAAA", UserID: "user-1", IsDeleted: true},
+		{Version: 2, UUID: "3", ShortID: "AAAA", OriginalURL: "https://a.com", UserID: "user-2"},
+	} {
+		line, err := encodeRecord(record)
+		assert.NoError(t, err)
+		data = append(data, line...)
+	}
+	assert.NoError(t, os.WriteFile(path, data, 0644))
+
+	f, err := Open(path, Options{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+	assert.NoError(t, f.Compact())
+	data, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"))
+	assert.Contains(t, string(data), `"user_id":"user-2"`)
+	assert.Equal(t, 1, f.lines)
+}
@@ -0,0 +1,98 @@
+// Description: Межпроцессная блокировка файлового хранилища.
+// Рядом с файлом хранилища создается файл блокировки (путь к хранилищу + ".lock"), на который ставится
+// рекомендательная блокировка flock. Хранилище, открытое для записи, держит исключительную блокировку,
+// поэтому второй процесс с тем же файлом хранилища не запустится и не перемешает записи с записями первого.
+// В режиме только для чтения ставится разделяемая блокировка: несколько читателей могут работать одновременно,
+// но не вместе с процессом, который пишет в хранилище.
+// Владелец исключительной блокировки записывает в файл блокировки свой PID и имя хоста для сообщения об ошибке.
+// Блокировка снимается при закрытии хранилища или завершении процесса.
+
+package filestorage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrLocked - хранилище заблокировано другим процессом.
+var ErrLocked = errors.New("file storage is locked by another process")
+
+// ErrReadOnly - хранилище открыто только для чтения.
+var ErrReadOnly = errors.New("file storage is opened read-only")
+
+// lockOwner - владелец исключительной блокировки, записанный в файл блокировки.
+type lockOwner struct {
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// lockPath - возвращает путь к файлу блокировки хранилища.
+func lockPath(path string) string {
+	return path + ".lock"
+}
+
+// acquireLock - ставит блокировку на файл блокировки хранилища path, не дожидаясь ее освобождения.
+// Исключительная блокировка (exclusive) записывает в файл блокировки владельца.
+// Если хранилище заблокировано, возвращает ошибку ErrLocked с владельцем блокировки, если он известен.
+// Блокировка снимается закрытием возвращенного файла.
+func acquireLock(path string, exclusive bool) (*os.File, error) {
+	if err := createDirIfNotExists(path); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(lockPath(path), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := flock(file, exclusive); err != nil {
+		file.Close()
+		if errors.Is(err, errWouldBlock) {
+			return nil, lockedError(path)
+		}
+		return nil, err
+	}
+	// Владелец записывается исключительной блокировкой. Читатель стирает владельца,
+	// оставшегося после завершившегося процесса, чтобы не указывать его в сообщениях об ошибке.
+	if exclusive {
+		err = writeLockOwner(file)
+	} else {
+		err = file.Truncate(0)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// writeLockOwner - записывает в файл блокировки текущий процесс.
+func writeLockOwner(file *os.File) error {
+	host, _ := os.Hostname()
+	data, err := json.Marshal(lockOwner{PID: os.Getpid(), Host: host, StartedAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err = file.WriteAt(append(data, '\n'), 0)
+	return err
+}
+
+// lockedError - возвращает ошибку ErrLocked с владельцем блокировки хранилища path.
+func lockedError(path string) error {
+	data, err := os.ReadFile(lockPath(path))
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrLocked, path)
+	}
+	var owner lockOwner
+	if err := json.Unmarshal(data, &owner); err != nil || owner.PID == 0 {
+		// Блокировку держат только читатели
+		return fmt.Errorf("%w: %s is opened by readers", ErrLocked, path)
+	}
+	return fmt.Errorf("%w: %s is used by pid %d on host %s since %s",
+		ErrLocked, path, owner.PID, owner.Host, owner.StartedAt.Format(time.RFC3339))
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package filestorage
+
+import (
+	"errors"
+	"os"
+)
+
+// errWouldBlock - блокировка занята другим процессом.
+var errWouldBlock = errors.New("lock is held by another process")
+
+// flock - на этой платформе блокировка flock не поддерживается, и хранилище не блокируется.
+func flock(file *os.File, exclusive bool) error {
+	return nil
+}
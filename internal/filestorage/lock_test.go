@@ -0,0 +1,78 @@
+//go:build unix
+
+package filestorage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+)
+
+func TestLock(t *testing.T) {
+	path := t.TempDir() + "/file-storage.txt"
+
+	f, err := Open(path, Options{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Второй экземпляр не открывает хранилище, которое уже используется
+	_, err = Open(path, Options{})
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.ErrorContains(t, err, fmt.Sprintf("pid %d", os.Getpid()))
+	_, err = Open(path, Options{ReadOnly: true})
+	assert.ErrorIs(t, err, ErrLocked)
+	_, err = Upgrade(path)
+	assert.ErrorIs(t, err, ErrLocked)
+
+	// После закрытия блокировка снята
+	assert.NoError(t, f.Close())
+	f, err = Open(path, Options{})
+	if assert.NoError(t, err) {
+		assert.NoError(t, f.Close())
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir() + "/file-storage.txt"
+
+	f, err := Open(path, Options{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, _, err = f.Save(ctx, repository.Record{ShortID: "AAAA", OriginalURL: "https://a.com", UserID: "user-1"})
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	// Читатели открывают хранилище одновременно
+	reader1, err := Open(path, Options{ReadOnly: true})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer reader1.Close()
+	reader2, err := Open(path, Options{ReadOnly: true})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer reader2.Close()
+
+	record, err := reader2.Get(ctx, "AAAA")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://a.com", record.OriginalURL)
+
+	_, _, err = reader1.Save(ctx, repository.Record{ShortID: "BBBB", OriginalURL: "https://b.com"})
+	assert.ErrorIs(t, err, ErrReadOnly)
+	_, err = reader1.Get(ctx, "BBBB")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	assert.ErrorIs(t, reader1.Delete(ctx, "user-1", []string{"AAAA"}), ErrReadOnly)
+
+	// Пока есть читатели, хранилище не открывается для записи
+	_, err = Open(path, Options{})
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.ErrorContains(t, err, "readers")
+}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package filestorage
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// errWouldBlock - блокировка занята другим процессом.
+var errWouldBlock = syscall.EWOULDBLOCK
+
+// flock - ставит на файл рекомендательную блокировку flock, не дожидаясь ее освобождения.
+func flock(file *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	for {
+		err := syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+		if !errors.Is(err, syscall.EINTR) {
+			return err
+		}
+	}
+}
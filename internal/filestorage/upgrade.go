@@ -0,0 +1,46 @@
+// Description: Перевод файла хранилища прежнего формата (с короткими URL в поле short_url) в текущий формат.
+
+package filestorage
+
+import (
+	"os"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Upgrade - переводит все записи файла хранилища в текущий формат, сохраняя их порядок,
+// в том числе удаленные записи и записи об удалении.
+// Файл заменяется атомарно. Оборванная запись в конце файла отбрасывается.
+// Если хранилище открыто сервисом, возвращает ошибку ErrLocked.
+// Параметры:
+// - path - путь к файлу хранилища.
+// Возвращает количество переведенных записей. Если записей прежнего формата нет, то файл не изменяется.
+func Upgrade(path string) (n int, err error) {
+	lock, err := acquireLock(path, true)
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Close()
+
+	records, _, err := readJournal(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	for i, record := range records {
+		if record.Version < formatVersion {
+			records[i] = upgradeRecord(record)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if err := replaceFile(path, records); err != nil {
+		return 0, err
+	}
+	log.Info().Msgf("Filestorage upgraded: %d of %d records converted", n, len(records))
+	return n, nil
+}
@@ -0,0 +1,88 @@
+package filestorage
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+)
+
+// legacyData - файл хранилища прежнего формата, записанный с другим префиксом коротких URL.
+const legacyData = `{"uuid":"1","short_url":"http://old.example.com/AAAA","original_url":"https://a.com","user_id":"user-1"}
+{"uuid":"2","short_url":"http://old.example.com/s/BBBB","original_url":"https://b.com"}
+{"uuid":"3","short_url":"http://old.example.com/s/BBBB","original_url":"","is_deleted":true}
+{"uuid":"4","short_url":"CCCC","original_url":"https://c.com"}
+`
+
+func TestLegacyShortID(t *testing.T) {
+	assert.Equal(t, "AAAA", legacyShortID("http://localhost:8080/AAAA"))
+	assert.Equal(t, "AAAA", legacyShortID("https://example.com/prefix/AAAA"))
+	assert.Equal(t, "AAAA", legacyShortID("AAAA"))
+	assert.Equal(t, "", legacyShortID(""))
+}
+
+func TestOpenLegacy(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir() + "/file-storage.txt"
+	assert.NoError(t, os.WriteFile(path, []byte(legacyData), 0644))
+
+	f, err := Open(path, Options{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+
+	record, err := f.Get(ctx, "AAAA")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://a.com", record.OriginalURL)
+	assert.Equal(t, "user-1", record.UserID)
+	record, err = f.Get(ctx, "BBBB")
+	assert.NoError(t, err)
+	assert.True(t, record.IsDeleted)
+	_, err = f.Get(ctx, "CCCC")
+	assert.NoError(t, err)
+
+	// Новые записи пишутся в текущем формате
+	_, _, err = f.Save(ctx, repository.Record{ShortID: "DDDD", OriginalURL: "https://d.com"})
+	assert.NoError(t, err)
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Contains(t, lines[len(lines)-1], `"v":2`)
+	assert.Contains(t, lines[len(lines)-1], `"short_id":"DDDD"`)
+	assert.Contains(t, lines[len(lines)-1], `"created_at"`)
+}
+
+func TestUpgrade(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir() + "/file-storage.txt"
+	assert.NoError(t, os.WriteFile(path, []byte(legacyData), 0644))
+
+	n, err := Upgrade(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, strings.Count(string(data), `"v":2`))
+	assert.NotContains(t, string(data), "short_url")
+
+	// Повторный перевод ничего не меняет
+	n, err = Upgrade(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	f, err := Open(path, Options{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+	_, err = f.Get(ctx, "AAAA")
+	assert.NoError(t, err)
+	record, err := f.Get(ctx, "BBBB")
+	assert.NoError(t, err)
+	assert.True(t, record.IsDeleted)
+}
@@ -0,0 +1,258 @@
+// Description: gRPC API сервиса (см. api/shortenerpb/shortener.proto).
+// Методы повторяют HTTP-обработчики пакета handlers и работают с тем же приложением app.App:
+// ответы HTTP со статусами 4xx и 5xx соответствуют ошибкам gRPC с кодами из пакета codes.
+
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/vadim-ivlev/url-shortener/api/shortenerpb"
+	"github.com/vadim-ivlev/url-shortener/internal/analytics"
+	"github.com/vadim-ivlev/url-shortener/internal/app"
+	"github.com/vadim-ivlev/url-shortener/internal/auth"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+	"github.com/vadim-ivlev/url-shortener/internal/shortener"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Server - реализация gRPC-сервиса Shortener. Работает с приложением, переданным в New.
+type Server struct {
+	shortenerpb.UnimplementedShortenerServer
+	app *app.App
+}
+
+// New создает реализацию сервиса для приложения a.
+func New(a *app.App) *Server {
+	return &Server{app: a}
+}
+
+// NewServer создает gRPC-сервер с сервисом Shortener приложения a и перехватчиками
+// логирования, восстановления после паники и аутентификации.
+// Параметры:
+// - a - приложение
+// - opts - дополнительные параметры сервера, например grpc.Creds для TLS
+func NewServer(a *app.App, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.ChainUnaryInterceptor(logRequest, recoverPanic, authenticate))
+	srv := grpc.NewServer(opts...)
+	shortenerpb.RegisterShortenerServer(srv, New(a))
+	return srv
+}
+
+// errorStatus - возвращает ошибку gRPC для ошибки хранилища:
+// UNAVAILABLE, если хранилище временно недоступно, и INTERNAL в остальных случаях.
+func errorStatus(err error) error {
+	if errors.Is(err, repository.ErrUnavailable) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// userID - возвращает идентификатор пользователя с действительным токеном.
+// Пользователь, которому токен выдан в этом запросе, не может иметь сокращенных URL.
+func userID(ctx context.Context) (string, error) {
+	userID, ok := auth.UserID(ctx)
+	if !ok || auth.IsNewUser(ctx) {
+		return "", status.Error(codes.Unauthenticated, "Unauthorized")
+	}
+	return userID, nil
+}
+
+// Shorten - сокращает URL, как APIShortenHandler.
+func (s *Server) Shorten(ctx context.Context, req *shortenerpb.ShortenRequest) (*shortenerpb.ShortenResponse, error) {
+	if req.GetUrl() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Empty URL")
+	}
+	if req.GetAlias() != "" {
+		if err := shortener.ValidateAlias(req.GetAlias()); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	// Определить срок действия короткого URL, если он задан
+	var expiresAt *time.Time
+	if req.ExpiresAt != nil {
+		t := req.ExpiresAt.AsTime()
+		expiresAt = &t
+	}
+	expiry, err := app.ParseExpiry(req.ExpiresIn, expiresAt)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	shortURL, aNewOne, err := s.app.Shorten(ctx, req.GetUrl(), req.GetAlias(), expiry)
+	if errors.Is(err, app.ErrAliasTaken) {
+		return nil, status.Errorf(codes.AlreadyExists, "alias %q is already taken", req.GetAlias())
+	}
+	if errors.Is(err, app.ErrURLShortened) {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	}
+	// Конфликт с записью другого экземпляра сервиса - это тот же ответ с существующим коротким URL
+	if err != nil && !errors.Is(err, repository.ErrConflict) {
+		return nil, errorStatus(err)
+	}
+	return &shortenerpb.ShortenResponse{ShortUrl: shortURL, Created: aNewOne}, nil
+}
+
+// ShortenBatch - сокращает несколько URL, как APIShortenBatchHandler.
+// Пустой original_url получает пустой short_url и не сохраняется.
+func (s *Server) ShortenBatch(ctx context.Context, req *shortenerpb.ShortenBatchRequest) (*shortenerpb.ShortenBatchResponse, error) {
+	inputItems := req.GetItems()
+	if len(inputItems) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Empty batch")
+	}
+
+	// Проверить алиасы до сохранения, чтобы не сохранять батч частично
+	for _, item := range inputItems {
+		if item.GetAlias() == "" {
+			continue
+		}
+		if err := shortener.ValidateAlias(item.GetAlias()); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "correlation_id "+item.GetCorrelationId()+": "+err.Error())
+		}
+	}
+
+	items := make([]app.BatchItem, 0, len(inputItems))
+	// Индексы записей пачки во входном массиве
+	indexes := make([]int, 0, len(inputItems))
+	for i, item := range inputItems {
+		if item.GetOriginalUrl() != "" {
+			items = append(items, app.BatchItem{OriginalURL: item.GetOriginalUrl(), Alias: item.GetAlias()})
+			indexes = append(indexes, i)
+		}
+	}
+	results, err := s.app.ShortenBatch(ctx, items)
+	if errors.Is(err, app.ErrAliasTaken) || errors.Is(err, app.ErrURLShortened) {
+		for j, result := range results {
+			if result.Err == nil {
+				continue
+			}
+			item := inputItems[indexes[j]]
+			if errors.Is(result.Err, app.ErrURLShortened) {
+				return nil, status.Errorf(codes.AlreadyExists, "correlation_id %s: %v", item.GetCorrelationId(), result.Err)
+			}
+			return nil, status.Errorf(codes.AlreadyExists, "correlation_id %s: alias %q is already taken", item.GetCorrelationId(), item.GetAlias())
+		}
+	}
+	if err != nil {
+		return nil, errorStatus(err)
+	}
+
+	resp := &shortenerpb.ShortenBatchResponse{Results: make([]*shortenerpb.BatchResult, len(inputItems))}
+	for i, item := range inputItems {
+		resp.Results[i] = &shortenerpb.BatchResult{CorrelationId: item.GetCorrelationId()}
+	}
+	for j, result := range results {
+		resp.Results[indexes[j]].ShortUrl = result.ShortURL
+	}
+	return resp, nil
+}
+
+// Resolve - возвращает оригинальный URL, как RedirectHandler, и записывает переход.
+func (s *Server) Resolve(ctx context.Context, req *shortenerpb.ResolveRequest) (*shortenerpb.ResolveResponse, error) {
+	id := req.GetShortId()
+	if id == "" {
+		return nil, status.Error(codes.InvalidArgument, "Empty short id")
+	}
+	originalURL, err := s.app.Resolve(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "URL not found")
+	}
+	if errors.Is(err, app.ErrGone) {
+		return nil, status.Error(codes.FailedPrecondition, "URL deleted or expired")
+	}
+	if err != nil {
+		return nil, errorStatus(err)
+	}
+
+	s.app.RecordClick(newClick(ctx, id))
+	return &shortenerpb.ResolveResponse{OriginalUrl: originalURL}, nil
+}
+
+// newClick - создает событие перехода по данным gRPC-запроса, как analytics.NewClick для HTTP.
+// Адрес клиента берется из адреса соединения или, если соединение установлено доверенным обратным прокси,
+// из метаданных x-real-ip (см. analytics.ClientIP).
+func newClick(ctx context.Context, shortID string) analytics.Click {
+	var userAgent, realIP, remoteAddr string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		userAgent = strings.Join(md.Get("user-agent"), " ")
+		if v := md.Get("x-real-ip"); len(v) > 0 {
+			realIP = v[0]
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remoteAddr = p.Addr.String()
+	}
+	return analytics.Click{
+		ShortID:   shortID,
+		Time:      time.Now().UTC(),
+		UserAgent: userAgent,
+		ClientIP:  analytics.CoarseIP(analytics.ClientIP(remoteAddr, realIP)),
+	}
+}
+
+// ListUserURLs - возвращает страницу URL пользователя, как APIUserURLsHandler.
+func (s *Server) ListUserURLs(ctx context.Context, req *shortenerpb.ListUserURLsRequest) (*shortenerpb.ListUserURLsResponse, error) {
+	userID, err := userID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := int(req.GetLimit())
+	if limit == 0 {
+		limit = app.DefaultPageLimit
+	}
+	if limit < 0 || limit > app.MaxPageLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "limit must be an integer in range 1..%d", app.MaxPageLimit)
+	}
+
+	urls, nextCursor, err := s.app.ListUserURLs(ctx, userID, req.GetCursor(), limit)
+	if errors.Is(err, app.ErrInvalidCursor) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, errorStatus(err)
+	}
+
+	resp := &shortenerpb.ListUserURLsResponse{
+		Urls:       make([]*shortenerpb.UserURL, 0, len(urls)),
+		NextCursor: nextCursor,
+	}
+	for _, u := range urls {
+		resp.Urls = append(resp.Urls, &shortenerpb.UserURL{ShortUrl: s.app.ShortURL(u.ShortID), OriginalUrl: u.OriginalURL})
+	}
+	return resp, nil
+}
+
+// DeleteURLs - ставит в очередь удаление URL пользователя, как APIDeleteUserURLsHandler.
+func (s *Server) DeleteURLs(ctx context.Context, req *shortenerpb.DeleteURLsRequest) (*shortenerpb.DeleteURLsResponse, error) {
+	userID, err := userID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.GetShortIds()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Empty batch")
+	}
+	if err := s.app.QueueDelete(ctx, userID, req.GetShortIds()); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &shortenerpb.DeleteURLsResponse{}, nil
+}
+
+// Ping - проверяет доступность хранилища, как PingHandler.
+func (s *Server) Ping(ctx context.Context, req *shortenerpb.PingRequest) (*shortenerpb.PingResponse, error) {
+	if err := s.app.Repo.Ping(ctx); err != nil {
+		return nil, status.Error(codes.Unavailable, "No connection to storage")
+	}
+	return &shortenerpb.PingResponse{}, nil
+}
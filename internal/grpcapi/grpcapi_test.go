@@ -0,0 +1,224 @@
+package grpcapi
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vadim-ivlev/url-shortener/api/shortenerpb"
+	"github.com/vadim-ivlev/url-shortener/internal/analytics"
+	"github.com/vadim-ivlev/url-shortener/internal/app"
+	"github.com/vadim-ivlev/url-shortener/internal/auth"
+	"github.com/vadim-ivlev/url-shortener/internal/shortener"
+	"github.com/vadim-ivlev/url-shortener/internal/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestMain(m *testing.M) {
+	auth.Init()
+	os.Exit(m.Run())
+}
+
+// newTestClient запускает gRPC сервер независимого приложения, хранящего данные в памяти,
+// на соединении в памяти процесса и возвращает клиента.
+func newTestClient(t *testing.T) shortenerpb.ShortenerClient {
+	a := app.New(storage.NewMemory(), shortener.HashGenerator{}, "http://localhost:8080")
+	a.Start()
+	t.Cleanup(a.Stop)
+
+	ln := bufconn.Listen(1 << 20)
+	srv := NewServer(a)
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return shortenerpb.NewShortenerClient(conn)
+}
+
+// login выполняет запрос без токена и возвращает контекст с выданным сервером токеном.
+func login(t *testing.T, client shortenerpb.ShortenerClient) context.Context {
+	var header metadata.MD
+	_, err := client.Ping(context.Background(), &shortenerpb.PingRequest{}, grpc.Header(&header))
+	assert.NoError(t, err)
+	tokens := header.Get(TokenMetadataKey)
+	if !assert.Len(t, tokens, 1) {
+		t.FailNow()
+	}
+	return metadata.AppendToOutgoingContext(context.Background(), TokenMetadataKey, tokens[0])
+}
+
+func TestShorten(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	resp, err := client.Shorten(ctx, &shortenerpb.ShortenRequest{Url: "https://example.com"})
+	assert.NoError(t, err)
+	assert.True(t, resp.GetCreated())
+	assert.Contains(t, resp.GetShortUrl(), "http://localhost:8080/")
+
+	// Повторное сокращение возвращает тот же короткий URL
+	again, err := client.Shorten(ctx, &shortenerpb.ShortenRequest{Url: "https://example.com"})
+	assert.NoError(t, err)
+	assert.False(t, again.GetCreated())
+	assert.Equal(t, resp.GetShortUrl(), again.GetShortUrl())
+
+	// Алиас
+	resp, err = client.Shorten(ctx, &shortenerpb.ShortenRequest{Url: "https://example.com/sale", Alias: "summer-sale"})
+	assert.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080/summer-sale", resp.GetShortUrl())
+	_, err = client.Shorten(ctx, &shortenerpb.ShortenRequest{Url: "https://example.com/other", Alias: "summer-sale"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	// URL уже сокращен под другим id
+	_, err = client.Shorten(ctx, &shortenerpb.ShortenRequest{Url: "https://example.com/sale", Alias: "winter-sale"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "http://localhost:8080/summer-sale")
+
+	// Неверные запросы
+	expiresIn := int64(60)
+	tests := []struct {
+		name string
+		req  *shortenerpb.ShortenRequest
+	}{
+		{name: "empty URL", req: &shortenerpb.ShortenRequest{}},
+		{name: "invalid alias", req: &shortenerpb.ShortenRequest{Url: "https://example.com", Alias: "a/b"}},
+		{name: "both expiry fields", req: &shortenerpb.ShortenRequest{Url: "https://example.com", ExpiresIn: &expiresIn, ExpiresAt: timestamppb.Now()}},
+		{name: "expires in the past", req: &shortenerpb.ShortenRequest{Url: "https://example.com", ExpiresAt: timestamppb.New(time.Now().Add(-time.Hour))}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.Shorten(ctx, tt.req)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		})
+	}
+}
+
+func TestShortenBatch(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	resp, err := client.ShortenBatch(ctx, &shortenerpb.ShortenBatchRequest{Items: []*shortenerpb.BatchItem{
+		{CorrelationId: "1", OriginalUrl: "https://a.com"},
+		{CorrelationId: "2", OriginalUrl: ""},
+		{CorrelationId: "3", OriginalUrl: "https://c.com", Alias: "c-com"},
+	}})
+	assert.NoError(t, err)
+	if assert.Len(t, resp.GetResults(), 3) {
+		assert.Equal(t, "1", resp.GetResults()[0].GetCorrelationId())
+		assert.NotEmpty(t, resp.GetResults()[0].GetShortUrl())
+		assert.Empty(t, resp.GetResults()[1].GetShortUrl())
+		assert.Equal(t, "http://localhost:8080/c-com", resp.GetResults()[2].GetShortUrl())
+	}
+
+	_, err = client.ShortenBatch(ctx, &shortenerpb.ShortenBatchRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.ShortenBatch(ctx, &shortenerpb.ShortenBatchRequest{Items: []*shortenerpb.BatchItem{
+		{CorrelationId: "1", OriginalUrl: "https://d.com", Alias: "c-com"},
+	}})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+}
+
+func TestResolve(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	_, err := client.Resolve(ctx, &shortenerpb.ResolveRequest{ShortId: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.Resolve(ctx, &shortenerpb.ResolveRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Shorten(ctx, &shortenerpb.ShortenRequest{Url: "https://example.com", Alias: "example"})
+	assert.NoError(t, err)
+	resp, err := client.Resolve(ctx, &shortenerpb.ResolveRequest{ShortId: "example"})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com", resp.GetOriginalUrl())
+}
+
+func TestUserURLs(t *testing.T) {
+	client := newTestClient(t)
+
+	// Без токена пользователь не может иметь сокращенных URL
+	_, err := client.ListUserURLs(context.Background(), &shortenerpb.ListUserURLsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.DeleteURLs(context.Background(), &shortenerpb.DeleteURLsRequest{ShortIds: []string{"a"}})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := login(t, client)
+	for _, alias := range []string{"url-1", "url-2", "url-3"} {
+		_, err := client.Shorten(ctx, &shortenerpb.ShortenRequest{Url: "https://example.com/" + alias, Alias: alias})
+		assert.NoError(t, err)
+	}
+
+	// Постраничное чтение
+	page, err := client.ListUserURLs(ctx, &shortenerpb.ListUserURLsRequest{Limit: 2})
+	assert.NoError(t, err)
+	assert.Len(t, page.GetUrls(), 2)
+	assert.NotEmpty(t, page.GetNextCursor())
+	page, err = client.ListUserURLs(ctx, &shortenerpb.ListUserURLsRequest{Limit: 2, Cursor: page.GetNextCursor()})
+	assert.NoError(t, err)
+	if assert.Len(t, page.GetUrls(), 1) {
+		assert.Equal(t, "http://localhost:8080/url-3", page.GetUrls()[0].GetShortUrl())
+		assert.Equal(t, "https://example.com/url-3", page.GetUrls()[0].GetOriginalUrl())
+	}
+	assert.Empty(t, page.GetNextCursor())
+
+	_, err = client.ListUserURLs(ctx, &shortenerpb.ListUserURLsRequest{Limit: app.MaxPageLimit + 1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.ListUserURLs(ctx, &shortenerpb.ListUserURLsRequest{Cursor: "!"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Удаление выполняется асинхронно
+	_, err = client.DeleteURLs(ctx, &shortenerpb.DeleteURLsRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.DeleteURLs(ctx, &shortenerpb.DeleteURLsRequest{ShortIds: []string{"url-1"}})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		_, err := client.Resolve(ctx, &shortenerpb.ResolveRequest{ShortId: "url-1"})
+		return status.Code(err) == codes.FailedPrecondition
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestPing(t *testing.T) {
+	client := newTestClient(t)
+	_, err := client.Ping(context.Background(), &shortenerpb.PingRequest{})
+	assert.NoError(t, err)
+}
+
+func TestRecoverPanic(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/shortener.v1.Shortener/Ping"}
+	_, err := recoverPanic(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+		panic("boom")
+	})
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestNewClick(t *testing.T) {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 5555}})
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("user-agent", "test-agent", "x-real-ip", "172.16.5.6"))
+
+	// Адрес от недоверенного клиента не учитывается
+	c := newClick(ctx, "abc")
+	assert.Equal(t, "abc", c.ShortID)
+	assert.Equal(t, "test-agent", c.UserAgent)
+	assert.Equal(t, "10.1.2.0", c.ClientIP)
+
+	// Адрес от доверенного обратного прокси
+	assert.NoError(t, analytics.SetTrustedProxies("10.1.0.0/16"))
+	t.Cleanup(func() { analytics.SetTrustedProxies("") })
+	assert.Equal(t, "172.16.5.0", newClick(ctx, "abc").ClientIP)
+}
@@ -0,0 +1,71 @@
+package grpcapi
+
+import (
+	"context"
+	"runtime/debug"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vadim-ivlev/url-shortener/internal/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// TokenMetadataKey - ключ метаданных с токеном пользователя, аналог cookie auth.CookieName в HTTP.
+const TokenMetadataKey = auth.CookieName
+
+// logRequest - перехватчик, записывающий в лог метод, время выполнения и код ответа, как logger.RequestLogger.
+func logRequest(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	log.Info().
+		Str("method", info.FullMethod).
+		Dur("duration", time.Since(start)).
+		Str("code", status.Code(err).String()).
+		Msg("")
+	return resp, err
+}
+
+// recoverPanic - перехватчик, превращающий панику обработчика в ошибку INTERNAL,
+// чтобы один запрос не останавливал весь сервер.
+func recoverPanic(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error().
+				Str("method", info.FullMethod).
+				Interface("panic", r).
+				Bytes("stack", debug.Stack()).
+				Msg("gRPC handler panicked")
+			err = status.Error(codes.Internal, "Internal server error")
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// authenticate - перехватчик, определяющий пользователя по подписанному токену в метаданных TokenMetadataKey,
+// как middleware authenticate HTTP-сервера. Если токена нет или он недействителен, то пользователю выдается
+// новый идентификатор, а новый токен возвращается в заголовке ответа TokenMetadataKey.
+// Идентификатор пользователя передается дальше в контексте, откуда его можно получить функцией auth.UserID.
+func authenticate(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if tokens := md.Get(TokenMetadataKey); len(tokens) > 0 {
+			if userID, err := auth.ParseToken(tokens[0]); err == nil {
+				return handler(auth.WithUserID(ctx, userID, false), req)
+			}
+		}
+	}
+
+	// Выдать новый идентификатор пользователя
+	userID := auth.NewUserID()
+	token, err := auth.BuildToken(userID)
+	if err != nil {
+		log.Error().Err(err).Msg("authenticate(). Cannot build token")
+		return nil, status.Error(codes.Internal, "Internal server error")
+	}
+	if err := grpc.SetHeader(ctx, metadata.Pairs(TokenMetadataKey, token)); err != nil {
+		log.Error().Err(err).Msg("authenticate(). Cannot send token")
+	}
+	return handler(auth.WithUserID(ctx, userID, true), req)
+}
@@ -1,47 +1,69 @@
 package handlers
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"strconv"
 	"strings"
+	"time"
 
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/vadim-ivlev/url-shortener/internal/analytics"
 	"github.com/vadim-ivlev/url-shortener/internal/app"
-	"github.com/vadim-ivlev/url-shortener/internal/db"
+	"github.com/vadim-ivlev/url-shortener/internal/auth"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
 	"github.com/vadim-ivlev/url-shortener/internal/shortener"
-	"github.com/vadim-ivlev/url-shortener/internal/storage"
 )
 
-// generateAndSaveShortURL - генерирует короткий URL и сохраняет его в хранилище.
-// Параметры:
-// ctx - контекст
-// originalURL - оригинальный URL.
-// Возвращает:
-// shortURL - короткий URL
-// aNewOne -  флаг, новый ли это короткий URL. Если true, то это новый короткий URL.
-// err - ошибка.
-func generateAndSaveShortURL(ctx context.Context, originalURL string) (shortURL string, aNewOne bool, err error) {
-	// Сгенерировать короткий id
-	shortID := shortener.Shorten(originalURL)
-	// Cохранить короткий id в хранилище в RAM
-	savedID, aNewOne := storage.Set(shortID, originalURL)
-
-	// Если это новый savedID, то есть aNewOne == true,
-	// то сохранить savedID и оригинальный URL в базу данных и/или в файловое хранилище
-	if aNewOne {
-		err = app.AddToStore(ctx, savedID, originalURL)
-	}
-	return app.ShortURL(savedID), aNewOne, err
+// Handlers - HTTP-обработчики сервиса. Работают с приложением, переданным в New.
+type Handlers struct {
+	app *app.App
+}
+
+// New создает обработчики для приложения a.
+func New(a *app.App) *Handlers {
+	return &Handlers{app: a}
+}
+
+// writeJSONError - отправляет ответ с кодом status и телом `{"error":"<message>"}`.
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// bodyTooLarge - если тело запроса превысило ограничение размера (см. http.MaxBytesReader),
+// отвечает 413 с ошибкой в JSON и возвращает true.
+func bodyTooLarge(w http.ResponseWriter, err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	if !errors.As(err, &maxBytesErr) {
+		return false
+	}
+	writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body is larger than %d bytes", maxBytesErr.Limit))
+	return true
+}
+
+// errorStatus - возвращает статус ответа для ошибки хранилища:
+// 503, если хранилище временно недоступно (например, нет соединения с базой данных), и 500 в остальных случаях.
+func errorStatus(err error) int {
+	if errors.Is(err, repository.ErrUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
 }
 
 // ShortenURLHandler обрабатывает POST-запросы для создания короткого URL.
-func ShortenURLHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) ShortenURLHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	body, err := io.ReadAll(r.Body)
+	if bodyTooLarge(w, err) {
+		return
+	}
 	if err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
@@ -53,9 +75,11 @@ func ShortenURLHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Сгенерировать короткий id и сохранить его
-	shortURL, aNewOne, err := generateAndSaveShortURL(ctx, originalURL)
-	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	shortURL, aNewOne, err := h.app.Shorten(ctx, originalURL, "", time.Time{})
+	// Конфликт с записью другого экземпляра сервиса - это тот же ответ 409 с существующим коротким URL
+	if err != nil && !errors.Is(err, repository.ErrConflict) {
+		status := errorStatus(err)
+		http.Error(w, http.StatusText(status), status)
 		return
 	}
 
@@ -72,7 +96,7 @@ func ShortenURLHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // RedirectHandler обрабатывает GET-запросы для перенаправления на оригинальный URL.
-func RedirectHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) RedirectHandler(w http.ResponseWriter, r *http.Request) {
 
 	// если id пустой, то вернуть ошибку
 	id := chi.URLParam(r, "id")
@@ -81,20 +105,33 @@ func RedirectHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Получить оригинальный URL по id и перенаправить
-	originalURL := storage.Get(id)
-	if originalURL == "" {
+	// Получить оригинальный URL по id
+	originalURL, err := h.app.Resolve(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
 		http.Error(w, "URL not found", http.StatusBadRequest)
 		return
 	}
+	// Если URL удален владельцем или истек срок его действия, то вернуть статус 410
+	if errors.Is(err, app.ErrGone) {
+		http.Error(w, "URL deleted or expired", http.StatusGone)
+		return
+	}
+	if err != nil {
+		status := errorStatus(err)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	// Записать переход асинхронно, не задерживая ответ
+	h.app.RecordClick(analytics.NewClick(id, r))
 
 	http.Redirect(w, r, originalURL, http.StatusTemporaryRedirect)
 }
 
-// PingHandler - при запросе проверяет соединение с базой данных.
+// PingHandler - при запросе проверяет доступность хранилища, например соединение с базой данных.
 // При успешной проверке хендлер должен вернуть HTTP-статус `200 OK`, при неуспешной — `500 Internal Server Error`.
-func PingHandler(w http.ResponseWriter, r *http.Request) {
-	if db.IsConnected() {
+func (h *Handlers) PingHandler(w http.ResponseWriter, r *http.Request) {
+	if h.app.Repo.Ping(r.Context()) == nil {
 		w.WriteHeader(http.StatusOK)
 	} else {
 		http.Error(w, "No connection do database", http.StatusInternalServerError)
@@ -106,6 +143,12 @@ APIShortenHandler - обрабатывает POST-запросы для созд
 Обслуживает эндпоинт POST /api/shorten,
 принимает в теле запроса JSON-объект `{"url":"<some_url>"}`
 и возвращает в ответ объект `{"result":"<short_url>"}`.
+Необязательное поле `"alias"` позволяет выбрать короткий id самостоятельно,
+например `{"url":"<some_url>","alias":"summer-sale"}`. Если алиас занят другим URL,
+возвращается статус 409 и объект `{"error":"<описание>"}`.
+Необязательные поля `"expires_in"` (срок действия в секундах) или `"expires_at"`
+(момент окончания действия в формате RFC 3339) ограничивают срок действия короткого URL.
+После окончания срока действия запрос короткого URL возвращает статус 410 Gone.
 Запрос может иметь такой вид:
 
 	POST http://localhost:8080/api/shorten HTTP/1.1
@@ -126,10 +169,13 @@ APIShortenHandler - обрабатывает POST-запросы для созд
 	"result": "http://localhost:8080/EwHXdJfB"
 	}
 */
-func APIShortenHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) APIShortenHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	body, err := io.ReadAll(r.Body)
+	if bodyTooLarge(w, err) {
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Header().Set("Content-Type", "application/json")
@@ -138,7 +184,10 @@ func APIShortenHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		URL string `json:"url"`
+		URL       string     `json:"url"`
+		Alias     string     `json:"alias,omitempty"`
+		ExpiresIn *int64     `json:"expires_in,omitempty"`
+		ExpiresAt *time.Time `json:"expires_at,omitempty"`
 	}
 	err = json.Unmarshal(body, &req)
 	if err != nil {
@@ -156,10 +205,34 @@ func APIShortenHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Сгенерировать короткий id и сохранить его
-	shortURL, aNewOne, err := generateAndSaveShortURL(ctx, originalURL)
+	// Проверить алиас, если он задан
+	if req.Alias != "" {
+		if err := shortener.ValidateAlias(req.Alias); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	// Определить срок действия короткого URL, если он задан
+	expiresAt, err := app.ParseExpiry(req.ExpiresIn, req.ExpiresAt)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Сохранить URL под алиасом или под сгенерированным коротким id
+	shortURL, aNewOne, err := h.app.Shorten(ctx, originalURL, req.Alias, expiresAt)
+	if errors.Is(err, app.ErrAliasTaken) {
+		writeJSONError(w, http.StatusConflict, `alias "`+req.Alias+`" is already taken`)
+		return
+	}
+	if errors.Is(err, app.ErrURLShortened) {
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+	// Конфликт с записью другого экземпляра сервиса - это тот же ответ 409 с существующим коротким URL
+	if err != nil && !errors.Is(err, repository.ErrConflict) {
+		w.WriteHeader(errorStatus(err))
 		w.Header().Set("Content-Type", "application/json")
 		w.Write([]byte(`{"error":"` + strings.ReplaceAll(err.Error(), `"`, ` `) + `"}`))
 		return
@@ -196,6 +269,7 @@ func APIShortenHandler(w http.ResponseWriter, r *http.Request) {
 type inpRec struct {
 	CorrelationID string `json:"correlation_id"`
 	OriginalURL   string `json:"original_url"`
+	Alias         string `json:"alias,omitempty"`
 }
 
 // Тип записи выходных данных
@@ -211,7 +285,8 @@ APIShortenBatchHandler - принимает в теле запроса множ
 
 	{
 		"correlation_id": "<строковый идентификатор>",
-		"original_url": "<URL для сокращения>"
+		"original_url": "<URL для сокращения>",
+		"alias": "<необязательный короткий id, выбранный пользователем>"
 	},
 	...
 
@@ -242,11 +317,14 @@ APIShortenBatchHandler - принимает в теле запроса множ
 - изменение в базе можно выполнять в рамках одной транзакции или одного запроса;
 - необходимо избегать формирования условий для возникновения состояния гонки (race condition).
 */
-func APIShortenBatchHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) APIShortenBatchHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	// Прочитать тело запроса
 	body, err := io.ReadAll(r.Body)
+	if bodyTooLarge(w, err) {
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Header().Set("Content-Type", "application/json")
@@ -275,28 +353,56 @@ func APIShortenBatchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Массив выходных данных ответа c емкостью равной длине входного массива
-	outputRecords := make([]outRec, 0, len(inputRecords))
-
-	// Обработать каждый элемент массива входных данных
+	// Проверить алиасы до сохранения, чтобы не сохранять батч частично
 	for _, r := range inputRecords {
-		originalURL := r.OriginalURL
-
-		// Если originalURL пустой, то вернуть пустой shortURL, не сохраняя его в хранилище и БД
-		if originalURL == "" {
-			outputRecords = append(outputRecords, outRec{CorrelationID: r.CorrelationID, ShortURL: ""})
+		if r.Alias == "" {
 			continue
 		}
-		// Сгенерировать короткий id и сохранить его в хранилище и в БД
-		shortURL, _, err := generateAndSaveShortURL(ctx, originalURL)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Header().Set("Content-Type", "application/json")
-			w.Write([]byte(`{"error":"` + strings.ReplaceAll(err.Error(), `"`, ` `) + `"}`))
+		if err := shortener.ValidateAlias(r.Alias); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "correlation_id "+r.CorrelationID+": "+err.Error())
 			return
 		}
+	}
 
-		outputRecords = append(outputRecords, outRec{CorrelationID: r.CorrelationID, ShortURL: shortURL})
+	// Сократить непустые URL одной пачкой.
+	// Пустой originalURL получает пустой shortURL и не сохраняется в хранилище и БД.
+	items := make([]app.BatchItem, 0, len(inputRecords))
+	// Индексы записей пачки во входном массиве
+	indexes := make([]int, 0, len(inputRecords))
+	for i, r := range inputRecords {
+		if r.OriginalURL != "" {
+			items = append(items, app.BatchItem{OriginalURL: r.OriginalURL, Alias: r.Alias})
+			indexes = append(indexes, i)
+		}
+	}
+	results, err := h.app.ShortenBatch(ctx, items)
+	if errors.Is(err, app.ErrAliasTaken) || errors.Is(err, app.ErrURLShortened) {
+		for j, result := range results {
+			if result.Err != nil {
+				r := inputRecords[indexes[j]]
+				msg := `alias "` + r.Alias + `" is already taken`
+				if errors.Is(result.Err, app.ErrURLShortened) {
+					msg = result.Err.Error()
+				}
+				writeJSONError(w, http.StatusConflict, "correlation_id "+r.CorrelationID+": "+msg)
+				return
+			}
+		}
+	}
+	if err != nil {
+		w.WriteHeader(errorStatus(err))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"error":"` + strings.ReplaceAll(err.Error(), `"`, ` `) + `"}`))
+		return
+	}
+
+	// Массив выходных данных ответа c емкостью равной длине входного массива
+	outputRecords := make([]outRec, len(inputRecords))
+	for i, r := range inputRecords {
+		outputRecords[i] = outRec{CorrelationID: r.CorrelationID}
+	}
+	for j, result := range results {
+		outputRecords[indexes[j]].ShortURL = result.ShortURL
 	}
 
 	// Подготовливаем тело ответа
@@ -313,3 +419,194 @@ func APIShortenBatchHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(respBody)
 }
+
+// NextCursorHeader - заголовок ответа, в котором передается курсор следующей страницы.
+const NextCursorHeader = "X-Next-Cursor"
+
+// Тип записи выходных данных для APIUserURLsHandler
+type userURLRec struct {
+	ShortURL    string `json:"short_url"`
+	OriginalURL string `json:"original_url"`
+}
+
+/*
+APIUserURLsHandler - возвращает короткие URL, сокращенные текущим пользователем, в формате:
+```json
+[
+
+	{
+		"short_url": "http://...",
+		"original_url": "http://..."
+	},
+	...
+
+]
+```
+
+Поддерживается постраничное чтение с параметрами запроса:
+- `limit` - максимальное количество записей на странице (по умолчанию 1000, не более 10000);
+- `cursor` - курсор страницы из заголовка `X-Next-Cursor` предыдущего ответа.
+
+Если у пользователя нет сокращенных URL, возвращается статус 204.
+Если запрос пришел без действительной cookie пользователя, возвращается статус 401.
+*/
+func (h *Handlers) APIUserURLsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Пользователь без действительного токена не может иметь сокращенных URL
+	userID, ok := auth.UserID(ctx)
+	if !ok || auth.IsNewUser(ctx) {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	// Разобрать параметры постраничного чтения
+	limit := app.DefaultPageLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > app.MaxPageLimit {
+			writeJSONError(w, http.StatusBadRequest, "limit must be an integer in range 1.."+strconv.Itoa(app.MaxPageLimit))
+			return
+		}
+		limit = n
+	}
+	cursor := r.URL.Query().Get("cursor")
+
+	urls, nextCursor, err := h.app.ListUserURLs(ctx, userID, cursor, limit)
+	if errors.Is(err, app.ErrInvalidCursor) {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeJSONError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	if len(urls) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	outputRecords := make([]userURLRec, 0, len(urls))
+	for _, u := range urls {
+		outputRecords = append(outputRecords, userURLRec{ShortURL: h.app.ShortURL(u.ShortID), OriginalURL: u.OriginalURL})
+	}
+
+	// Подготовливаем тело ответа
+	respBody, err := json.Marshal(outputRecords)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Marshal error")
+		return
+	}
+
+	// Отправляем ответ
+	if nextCursor != "" {
+		w.Header().Set(NextCursorHeader, nextCursor)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(respBody)
+}
+
+/*
+APIDeleteUserURLsHandler - принимает в теле запроса список коротких id для удаления в формате:
+```json
+["6qxTVvsy", "RTfd56hn", "Jlfd67ds"]
+```
+
+Удаление выполняется асинхронно: хендлер сразу возвращает статус 202,
+а короткие URL помечаются удаленными фоновым обработчиком.
+Удаляются только короткие URL, принадлежащие текущему пользователю.
+После удаления запрос короткого URL возвращает статус 410 Gone.
+Если запрос пришел без действительной cookie пользователя, возвращается статус 401.
+*/
+func (h *Handlers) APIDeleteUserURLsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Пользователь без действительного токена не может иметь сокращенных URL
+	userID, ok := auth.UserID(ctx)
+	if !ok || auth.IsNewUser(ctx) {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	// Прочитать тело запроса
+	body, err := io.ReadAll(r.Body)
+	if bodyTooLarge(w, err) {
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Распарсить тело запроса в список коротких id
+	shortIDs := []string{}
+	err = json.Unmarshal(body, &shortIDs)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(shortIDs) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "Empty batch")
+		return
+	}
+
+	// Поставить запрос в очередь на удаление
+	err = h.app.QueueDelete(ctx, userID, shortIDs)
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+/*
+APIURLStatsHandler - возвращает статистику переходов по короткому URL с id из пути запроса
+`GET /api/urls/{id}/stats` в формате:
+```json
+{
+
+	"total_clicks": 42,
+	"unique_visitors": 17,
+	"daily": [
+		{"date": "2024-06-01", "clicks": 30},
+		{"date": "2024-06-02", "clicks": 12}
+	]
+
+}
+```
+
+Если короткий URL неизвестен, возвращается статус 404.
+*/
+func (h *Handlers) APIURLStatsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id := chi.URLParam(r, "id")
+	_, err := h.app.Repo.Get(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "URL not found")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	stats, err := h.app.ClickStats(ctx, id)
+	if err != nil {
+		writeJSONError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	respBody, err := json.Marshal(stats)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Marshal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(respBody)
+}
@@ -0,0 +1,96 @@
+// Description: Метрики сервиса в формате Prometheus.
+// Метрики запросов собирает Middleware, переходы и результаты сокращения URL учитывает приложение
+// для HTTP и gRPC одинаково (см. ObserveShorten и ObserveRedirect),
+// метрики хранилища читаются в момент опроса (см. RegisterRepository).
+// Все метрики регистрируются в собственном реестре Registry и отдаются обработчиком Handler по адресу /metrics.
+
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace - префикс имен метрик сервиса.
+const namespace = "shortener"
+
+// Registry - реестр метрик сервиса.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// requestsTotal - количество обработанных запросов по маршрутам и статусам ответа
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests by method, chi route pattern and response status.",
+	}, []string{"method", "route", "status"})
+
+	// requestDuration - время обработки запросов по маршрутам и статусам ответа
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method, chi route pattern and response status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// redirectsTotal - количество выполненных переходов по коротким URL
+	redirectsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redirects_total",
+		Help:      "Number of redirects to original URLs.",
+	})
+
+	// shortenTotal - количество запросов на сокращение URL: new - создан новый короткий URL,
+	// conflict - URL был сокращен ранее
+	shortenTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "shorten_requests_total",
+		Help:      "Number of successful shorten requests by result: new or conflict.",
+	}, []string{"result"})
+
+	// FileWriteDuration - время записи в файловое хранилище, включая сброс на диск
+	FileWriteDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "file_write_duration_seconds",
+		Help:      "Latency of appending records to the file storage, including fsync.",
+		Buckets:   []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requestsTotal,
+		requestDuration,
+		redirectsTotal,
+		shortenTotal,
+		FileWriteDuration,
+	)
+	// Результаты сокращения видны в метриках с нулевыми значениями до первого запроса
+	shortenTotal.WithLabelValues("new")
+	shortenTotal.WithLabelValues("conflict")
+}
+
+// ObserveShorten - учитывает успешное сокращение URL: isNew - создан новый короткий URL,
+// иначе URL был сокращен ранее.
+func ObserveShorten(isNew bool) {
+	if isNew {
+		shortenTotal.WithLabelValues("new").Inc()
+		return
+	}
+	shortenTotal.WithLabelValues("conflict").Inc()
+}
+
+// ObserveRedirect - учитывает переход по короткому URL.
+func ObserveRedirect() {
+	redirectsTotal.Inc()
+}
+
+// Handler - обработчик, отдающий метрики в текстовом формате Prometheus.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+	"github.com/vadim-ivlev/url-shortener/internal/storage"
+)
+
+func TestMiddleware(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://example.com", http.StatusTemporaryRedirect)
+	})
+	r.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/AAAA", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/BBBB", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ping", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/a/b/c", nil))
+
+	// Запросы считаются по шаблону маршрута, а не по пути
+	assert.Equal(t, 2.0, testutil.ToFloat64(requestsTotal.WithLabelValues(http.MethodGet, "/{id}", "307")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(requestsTotal.WithLabelValues(http.MethodGet, "/ping", "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(requestsTotal.WithLabelValues(http.MethodGet, unmatchedRoute, "404")))
+}
+
+func TestObserve(t *testing.T) {
+	redirects := testutil.ToFloat64(redirectsTotal)
+	created := testutil.ToFloat64(shortenTotal.WithLabelValues("new"))
+	conflicts := testutil.ToFloat64(shortenTotal.WithLabelValues("conflict"))
+
+	ObserveShorten(true)
+	ObserveShorten(true)
+	ObserveShorten(false)
+	ObserveRedirect()
+
+	assert.Equal(t, redirects+1, testutil.ToFloat64(redirectsTotal))
+	assert.Equal(t, created+2, testutil.ToFloat64(shortenTotal.WithLabelValues("new")))
+	assert.Equal(t, conflicts+1, testutil.ToFloat64(shortenTotal.WithLabelValues("conflict")))
+}
+
+func TestHandler(t *testing.T) {
+	repo := storage.NewMemory()
+	_, _, err := repo.Save(context.Background(), repository.Record{ShortID: "AAAA", OriginalURL: "https://a.com"})
+	assert.NoError(t, err)
+	assert.NoError(t, RegisterRepository(storage.NewDualWrite(repo, storage.NewMemory())))
+	FileWriteDuration.Observe(0.001)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain"))
+	body := rec.Body.String()
+	assert.Contains(t, body, "shortener_storage_records 1\n")
+	assert.Contains(t, body, "shortener_file_write_duration_seconds_count 1\n")
+	assert.Contains(t, body, `shortener_shorten_requests_total{result="conflict"}`)
+	assert.Contains(t, body, "go_goroutines")
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// unmatchedRoute - метка запросов, не попавших ни в один маршрут.
+// Путь запроса в метку не попадает, чтобы количество временных рядов не зависело от клиентов.
+const unmatchedRoute = "unmatched"
+
+// statusResponseWriter - http.ResponseWriter, запоминающий код статуса ответа.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader запоминает код статуса.
+func (w *statusResponseWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write запоминает код статуса 200, если он не был записан явно.
+func (w *statusResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Middleware - middleware, собирающий метрики запросов: количество и время обработки по шаблону маршрута chi
+// и статусу ответа.
+// Подключается к маршрутизатору chi через Use, так как шаблон маршрута известен только после маршрутизации.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusResponseWriter{ResponseWriter: w}
+
+		next.ServeHTTP(sw, r)
+
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		statusLabel := strconv.Itoa(status)
+		requestsTotal.WithLabelValues(r.Method, route, statusLabel).Inc()
+		requestDuration.WithLabelValues(r.Method, route, statusLabel).Observe(time.Since(start).Seconds())
+	})
+}
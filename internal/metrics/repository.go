@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+	"github.com/vadim-ivlev/url-shortener/internal/storage"
+)
+
+var (
+	storageRecordsDesc = prometheus.NewDesc(namespace+"_storage_records",
+		"Number of records held in the in-memory storage or cache, including deleted ones.", nil, nil)
+	cacheHitsDesc = prometheus.NewDesc(namespace+"_cache_hits_total",
+		"Number of lookups answered by the read-through cache.", nil, nil)
+	cacheMissesDesc = prometheus.NewDesc(namespace+"_cache_misses_total",
+		"Number of lookups not found in the read-through cache.", nil, nil)
+	cacheHitRatioDesc = prometheus.NewDesc(namespace+"_cache_hit_ratio",
+		"Share of lookups answered by the read-through cache since start.", nil, nil)
+	cacheCapacityDesc = prometheus.NewDesc(namespace+"_cache_capacity",
+		"Maximal number of records in the read-through cache.", nil, nil)
+)
+
+// repositoryCollector - собирает метрики хранилища в момент опроса.
+type repositoryCollector struct {
+	repo repository.Repository
+}
+
+// Describe - описывает метрики хранилища.
+func (c repositoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+// Collect - читает счетчики и размер кеша или, если кеша нет, размер хранилища в памяти.
+func (c repositoryCollector) Collect(ch chan<- prometheus.Metric) {
+	if cache, ok := c.repo.(interface{ CacheStats() storage.CacheStats }); ok {
+		stats := cache.CacheStats()
+		ratio := 0.0
+		if total := stats.Hits + stats.Misses; total > 0 {
+			ratio = float64(stats.Hits) / float64(total)
+		}
+		ch <- prometheus.MustNewConstMetric(storageRecordsDesc, prometheus.GaugeValue, float64(stats.Size))
+		ch <- prometheus.MustNewConstMetric(cacheHitsDesc, prometheus.CounterValue, float64(stats.Hits))
+		ch <- prometheus.MustNewConstMetric(cacheMissesDesc, prometheus.CounterValue, float64(stats.Misses))
+		ch <- prometheus.MustNewConstMetric(cacheHitRatioDesc, prometheus.GaugeValue, ratio)
+		ch <- prometheus.MustNewConstMetric(cacheCapacityDesc, prometheus.GaugeValue, float64(stats.Capacity))
+		return
+	}
+	if m, ok := c.repo.(interface{ Map() *storage.DoubleMap }); ok {
+		ch <- prometheus.MustNewConstMetric(storageRecordsDesc, prometheus.GaugeValue, float64(m.Map().Len()))
+	}
+}
+
+// RegisterRepository - регистрирует метрики хранилища repo: размер хранилища в памяти,
+// счетчики кеша (для PostgreSQL в режиме lru) и статистику пула соединений с базой данных.
+// В режиме двойной записи метрики собираются по основному хранилищу.
+// Вызывается один раз для хранилища, созданного по конфигурации.
+func RegisterRepository(repo repository.Repository) error {
+	if dual, ok := repo.(*storage.DualWrite); ok {
+		repo = dual.Primary
+	}
+	if err := Registry.Register(repositoryCollector{repo: repo}); err != nil {
+		return err
+	}
+	if c, ok := repo.(interface{ Conn() *sqlx.DB }); ok {
+		return Registry.Register(collectors.NewDBStatsCollector(c.Conn().DB, c.Conn().DriverName()))
+	}
+	return nil
+}
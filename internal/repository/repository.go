@@ -0,0 +1,136 @@
+// Description: Интерфейс хранилища коротких URL.
+// Реализации:
+// - storage.Memory     - хранение в памяти;
+// - filestorage.File   - хранение в файле в формате JSON Lines с индексом в памяти;
+// - db.Postgres        - хранение в PostgreSQL с кешем в памяти;
+// - db.SQLite          - хранение во встроенной базе данных SQLite с кешем в памяти.
+// Обработчики работают с хранилищем только через этот интерфейс,
+// поэтому новое хранилище можно добавить, не меняя обработчики.
+
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/vadim-ivlev/url-shortener/internal/analytics"
+)
+
+// ErrNotFound - запись с указанным ключом не найдена.
+var ErrNotFound = errors.New("record not found")
+
+// ErrShortIDTaken - короткий id уже занят другим URL.
+var ErrShortIDTaken = errors.New("short id is already taken by another url")
+
+// ErrUnavailable - хранилище временно недоступно, например, потеряно соединение с базой данных.
+var ErrUnavailable = errors.New("storage is temporarily unavailable")
+
+// ErrConflict - оригинальный URL уже сохранен под другим коротким id.
+var ErrConflict = errors.New("original url is already shortened")
+
+// ConflictError - ошибка сохранения записи, оригинальный URL которой уже сохранен,
+// например другим экземпляром сервиса, использующим ту же базу данных.
+// errors.Is(err, ErrConflict) истинно для ConflictError.
+type ConflictError struct {
+	// Existing - ранее сохраненная запись
+	Existing Record
+}
+
+// Error возвращает текст ошибки с коротким id сохраненной записи.
+func (e *ConflictError) Error() string {
+	return ErrConflict.Error() + " as " + e.Existing.ShortID
+}
+
+// Unwrap возвращает ErrConflict.
+func (e *ConflictError) Unwrap() error {
+	return ErrConflict
+}
+
+// Record - запись о коротком URL.
+type Record struct {
+	// ShortID - короткий id
+	ShortID string
+	// OriginalURL - оригинальный URL
+	OriginalURL string
+	// UserID - идентификатор владельца. Пустая строка - владельца нет.
+	UserID string
+	// IsDeleted - короткий URL удален владельцем или по истечении срока действия
+	IsDeleted bool
+	// ExpiresAt - момент, после которого короткий URL перестает работать. Нулевое значение - бессрочно.
+	ExpiresAt time.Time
+}
+
+// IsExpired возвращает true, если срок действия записи истек к моменту now.
+func (r Record) IsExpired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && !now.Before(r.ExpiresAt)
+}
+
+// SaveResult - результат сохранения одной записи пачки.
+type SaveResult struct {
+	// Record - сохраненная запись или существующая запись с тем же оригинальным URL
+	Record Record
+	// IsNew - true, если запись новая
+	IsNew bool
+	// Err - ошибка сохранения записи, например ErrShortIDTaken
+	Err error
+}
+
+// Repository - хранилище коротких URL.
+type Repository interface {
+	// Save сохраняет запись.
+	// Если оригинальный URL уже сохранен, возвращает существующую запись и isNew == false.
+	// Если короткий id занят другим URL, возвращает ErrShortIDTaken.
+	Save(ctx context.Context, record Record) (saved Record, isNew bool, err error)
+	// SaveBatch сохраняет пачку записей. Результат для каждой записи возвращается в results
+	// в том же порядке. Ошибка err означает, что пачку не удалось сохранить целиком.
+	SaveBatch(ctx context.Context, records []Record) (results []SaveResult, err error)
+	// Get возвращает запись по короткому id, в том числе удаленную. ErrNotFound, если записи нет.
+	Get(ctx context.Context, shortID string) (Record, error)
+	// GetByOriginal возвращает запись по оригинальному URL. ErrNotFound, если записи нет.
+	GetByOriginal(ctx context.Context, originalURL string) (Record, error)
+	// ListByUser возвращает не более limit неудаленных и непросроченных записей пользователя
+	// с коротким id больше afterShortID, упорядоченных по короткому id.
+	ListByUser(ctx context.Context, userID, afterShortID string, limit int) ([]Record, error)
+	// Delete помечает удаленными записи пользователя userID с указанными короткими id.
+	// Записи других пользователей не изменяются.
+	Delete(ctx context.Context, userID string, shortIDs []string) error
+	// DeleteExpired помечает удаленными записи, срок действия которых истек к моменту now.
+	// Возвращает количество удаленных записей или 0 и ошибку, если удаление не удалось сохранить.
+	DeleteExpired(ctx context.Context, now time.Time) (n int, err error)
+	// Ping проверяет доступность хранилища.
+	Ping(ctx context.Context) error
+	// Close освобождает ресурсы хранилища.
+	Close() error
+
+	ClickRepository
+}
+
+// ClickRepository - хранилище переходов по коротким URL.
+type ClickRepository interface {
+	// SaveClicks сохраняет переходы.
+	SaveClicks(ctx context.Context, clicks []analytics.Click) error
+	// ClickStats возвращает статистику переходов по короткому URL.
+	ClickStats(ctx context.Context, shortID string) (analytics.Stats, error)
+}
+
+// Sequencer - хранилище, предоставляющее монотонный счетчик для генераторов коротких id.
+type Sequencer interface {
+	// NextSequence возвращает следующее значение счетчика.
+	NextSequence(ctx context.Context) (uint64, error)
+}
+
+// SequenceAdvancer - хранилище, счетчик которого можно продвинуть, например после импорта записей,
+// чтобы генераторы на основе счетчика не выдавали уже занятые id.
+type SequenceAdvancer interface {
+	// AdvanceSequence устанавливает счетчик так, чтобы следующее значение было больше n.
+	// Счетчик, уже превысивший n, не изменяется.
+	AdvanceSequence(ctx context.Context, n uint64) error
+}
+
+// Exporter - хранилище, которое перечисляет свои записи для переноса в другое хранилище.
+type Exporter interface {
+	// Export вызывает fn для каждой неудаленной и непросроченной записи.
+	// Перечисление прекращается, если fn вернула ошибку, и Export возвращает эту ошибку.
+	Export(ctx context.Context, fn func(Record) error) error
+}
@@ -1,6 +1,12 @@
 package server
 
-import "net/http"
+import (
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vadim-ivlev/url-shortener/internal/auth"
+	"github.com/vadim-ivlev/url-shortener/internal/config"
+)
 
 // middleware для установки Content-Type в значение application/json
 // https://github.com/oapi-codegen/oapi-codegen/issues/97
@@ -10,3 +16,54 @@ func contentTypeJSON(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// limitBody - middleware, ограничивающий размер тела запроса n байтами. 0 означает отсутствие ограничения.
+// Чтение тела сверх ограничения завершается ошибкой *http.MaxBytesError, на которую обработчики отвечают 413.
+// Подключается после распаковки gzip, поэтому ограничивает размер распакованного тела.
+func limitBody(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if n <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// authenticate - middleware, определяющий пользователя по подписанной cookie auth.CookieName.
+// Если cookie нет или она недействительна, то пользователю выдается новый идентификатор
+// и новая cookie. Идентификатор пользователя передается дальше в контексте запроса,
+// откуда его можно получить функцией auth.UserID.
+// Cookie выдается с SameSite=Lax и, если включен HTTPS, с признаком Secure.
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie(auth.CookieName); err == nil {
+			if userID, err := auth.ParseToken(cookie.Value); err == nil {
+				next.ServeHTTP(w, r.WithContext(auth.WithUserID(r.Context(), userID, false)))
+				return
+			}
+		}
+
+		// Выдать новый идентификатор пользователя
+		userID := auth.NewUserID()
+		token, err := auth.BuildToken(userID)
+		if err != nil {
+			log.Error().Err(err).Msg("authenticate(). Cannot build token")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     auth.CookieName,
+			Value:    token,
+			Path:     "/",
+			MaxAge:   int(auth.TokenTTL.Seconds()),
+			HttpOnly: true,
+			// Cookie не передается в межсайтовых POST и DELETE запросах, а при HTTPS - по незащищенному соединению
+			SameSite: http.SameSiteLaxMode,
+			Secure:   config.Params.EnableHTTPS,
+		})
+		next.ServeHTTP(w, r.WithContext(auth.WithUserID(r.Context(), userID, true)))
+	})
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vadim-ivlev/url-shortener/internal/auth"
+	"github.com/vadim-ivlev/url-shortener/internal/config"
+)
+
+func TestAuthenticate(t *testing.T) {
+	var gotUserID string
+	var gotIsNew bool
+	handler := authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUserID, _ = auth.UserID(r.Context())
+		gotIsNew = auth.IsNewUser(r.Context())
+	}))
+
+	// Запрос без cookie - выдается новый пользователь
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	cookies := rec.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.Equal(t, auth.CookieName, cookies[0].Name)
+	assert.Equal(t, http.SameSiteLaxMode, cookies[0].SameSite)
+	assert.False(t, cookies[0].Secure)
+	assert.NotEmpty(t, gotUserID)
+	assert.True(t, gotIsNew)
+	firstUserID := gotUserID
+
+	// Запрос с действительной cookie - тот же пользователь, новая cookie не выдается
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Empty(t, rec.Result().Cookies())
+	assert.Equal(t, firstUserID, gotUserID)
+	assert.False(t, gotIsNew)
+
+	// Запрос с поддельной cookie - выдается новый пользователь
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: auth.CookieName, Value: "forged"})
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Len(t, rec.Result().Cookies(), 1)
+	assert.NotEqual(t, firstUserID, gotUserID)
+	assert.True(t, gotIsNew)
+
+	// При HTTPS cookie передается только по защищенному соединению
+	config.Params.EnableHTTPS = true
+	defer func() { config.Params.EnableHTTPS = false }()
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	cookies = rec.Result().Cookies()
+	if assert.Len(t, cookies, 1) {
+		assert.True(t, cookies[0].Secure)
+	}
+}
+
+// TestAuthenticateRoutes - cookie пользователя выдается только на маршрутах, которым нужен пользователь.
+func TestAuthenticateRoutes(t *testing.T) {
+	router := newTestRouter(t)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://example.com")))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Len(t, rec.Result().Cookies(), 1)
+	shortID := strings.TrimPrefix(rec.Body.String(), "http://localhost:8080/")
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/user/urls", nil))
+	assert.Len(t, rec.Result().Cookies(), 1)
+
+	for _, target := range []string{"/" + shortID, "/ping", "/metrics", "/api/urls/" + shortID + "/stats"} {
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Empty(t, rec.Result().Cookies(), target)
+	}
+}
+
+func TestBodyLimits(t *testing.T) {
+	router := newTestRouter(t)
+
+	// Тело больше ограничения - 413 с ошибкой в JSON
+	long := "https://example.com/" + strings.Repeat("a", int(config.Params.MaxBodyBytes))
+	for _, target := range []string{"/", "/api/shorten"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"url":"`+long+`"}`)))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code, target)
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"), target)
+		assert.Contains(t, rec.Body.String(), `"error"`, target)
+	}
+
+	// Для пачек ограничение больше
+	batch := `[{"correlation_id":"1","original_url":"` + long + `"}]`
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(batch)))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = httptest.NewRecorder()
+	huge := strings.Repeat(" ", int(config.Params.MaxBatchBodyBytes)+1)
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(huge)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
@@ -1,36 +1,172 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/rs/zerolog/log"
+	"github.com/vadim-ivlev/url-shortener/internal/app"
 	"github.com/vadim-ivlev/url-shortener/internal/compression"
 	"github.com/vadim-ivlev/url-shortener/internal/config"
+	"github.com/vadim-ivlev/url-shortener/internal/grpcapi"
 	"github.com/vadim-ivlev/url-shortener/internal/handlers"
 	"github.com/vadim-ivlev/url-shortener/internal/logger"
+	"github.com/vadim-ivlev/url-shortener/internal/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
-// ServeChi запускает сервер на порту, указанном в конфигурации.
-func ServeChi() {
+// NewRouter создает маршрутизатор с обработчиками h.
+func NewRouter(h *handlers.Handlers) chi.Router {
 	r := chi.NewRouter()
 
 	r.Use(logger.RequestLogger)
+	r.Use(metrics.Middleware)
 	r.Use(compression.GzipMiddleware)
-	r.Post("/", handlers.ShortenURLHandler)
-	r.Get("/{id}", handlers.RedirectHandler)
-	r.Get("/ping", handlers.PingHandler)
+	// Ограничения размера тела запроса. Пачки ограничиваются отдельно
+	limit := limitBody(config.Params.MaxBodyBytes)
+	batchLimit := limitBody(config.Params.MaxBatchBodyBytes)
+
+	// Cookie пользователя выдается только на маршрутах, которым нужен пользователь
+	r.With(authenticate, limit).Post("/", h.ShortenURLHandler)
+	r.Get("/{id}", h.RedirectHandler)
+	r.Get("/ping", h.PingHandler)
+	r.Method(http.MethodGet, "/metrics", metrics.Handler())
 
 	r.Route("/api", func(r chi.Router) {
 		r.Use(contentTypeJSON)
-		r.Post("/shorten", handlers.APIShortenHandler)
-		r.Post("/shorten/batch", handlers.APIShortenBatchHandler)
+		r.Get("/urls/{id}/stats", h.APIURLStatsHandler)
+		r.Group(func(r chi.Router) {
+			r.Use(authenticate)
+			r.With(limit).Post("/shorten", h.APIShortenHandler)
+			r.With(batchLimit).Post("/shorten/batch", h.APIShortenBatchHandler)
+			r.Get("/user/urls", h.APIUserURLsHandler)
+			r.With(batchLimit).Delete("/user/urls", h.APIDeleteUserURLsHandler)
+		})
 	})
+	return r
+}
 
+// ServeChi запускает сервер приложения a на порту, указанном в конфигурации, и обслуживает запросы
+// по HTTP или, если config.Params.EnableHTTPS, по HTTPS (см. NewTLSConfig),
+// пока не отменен контекст ctx (например, по сигналу SIGINT или SIGTERM).
+// Если задан config.Params.GRPCAddress, то на нем одновременно запускается gRPC сервер (см. grpcapi.NewServer),
+// защищенный тем же сертификатом, что и HTTPS.
+// После отмены контекста серверы перестают принимать соединения, дожидаются завершения активных запросов,
+// останавливают фоновые обработчики приложения и закрывают хранилище (см. app.App.Shutdown).
+// Завершение запросов и остановка фоновых обработчиков ограничены config.Params.ShutdownTimeout каждое.
+// Возвращает nil после штатной остановки и ошибку, если сервер не удалось запустить или остановить.
+func ServeChi(ctx context.Context, a *app.App) error {
 	address := config.Params.ServerAddress
-	log.Info().Str("address", address).Msg("Starting the server at the ...")
-	err := http.ListenAndServe(address, r)
+	ln, err := net.Listen("tcp", address)
 	if err != nil {
-		panic(err)
+		return errors.Join(err, a.Shutdown(context.Background()))
+	}
+	// Размер сообщения gRPC ограничен так же, как размер тела пачки в HTTP
+	var grpcOpts []grpc.ServerOption
+	if config.Params.MaxBatchBodyBytes > 0 {
+		grpcOpts = append(grpcOpts, grpc.MaxRecvMsgSize(int(config.Params.MaxBatchBodyBytes)))
+	}
+	if config.Params.EnableHTTPS {
+		tlsConfig, err := NewTLSConfig()
+		if err != nil {
+			ln.Close()
+			return errors.Join(err, a.Shutdown(context.Background()))
+		}
+		grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		ln = tls.NewListener(ln, tlsConfig)
+		log.Info().Str("address", address).Msg("Starting the HTTPS server at the ...")
+	} else {
+		log.Info().Str("address", address).Msg("Starting the server at the ...")
+	}
+
+	var grpcLn net.Listener
+	if config.Params.GRPCAddress != "" {
+		grpcLn, err = net.Listen("tcp", config.Params.GRPCAddress)
+		if err != nil {
+			ln.Close()
+			return errors.Join(err, a.Shutdown(context.Background()))
+		}
+		log.Info().Str("address", config.Params.GRPCAddress).Msg("Starting the gRPC server at the ...")
+	}
+	return serve(ctx, a, ln, grpcLn, grpcOpts...)
+}
+
+// serve - обслуживает запросы к приложению a на ln и, если grpcLn не nil, gRPC запросы на grpcLn
+// до отмены ctx и останавливает серверы и приложение.
+func serve(ctx context.Context, a *app.App, ln, grpcLn net.Listener, grpcOpts ...grpc.ServerOption) error {
+	// Таймауты защищают от клиентов, которые держат соединения, медленно передавая запрос (slowloris)
+	srv := &http.Server{
+		Handler:           NewRouter(handlers.New(a)),
+		ReadHeaderTimeout: config.Params.ReadHeaderTimeout,
+		ReadTimeout:       config.Params.ReadTimeout,
+		WriteTimeout:      config.Params.WriteTimeout,
+		IdleTimeout:       config.Params.IdleTimeout,
+		MaxHeaderBytes:    config.Params.MaxHeaderBytes,
+	}
+
+	serveErr := make(chan error, 2)
+	go func() {
+		serveErr <- srv.Serve(ln)
+	}()
+	var grpcSrv *grpc.Server
+	if grpcLn != nil {
+		grpcSrv = grpcapi.NewServer(a, grpcOpts...)
+		go func() {
+			serveErr <- grpcSrv.Serve(grpcLn)
+		}()
+	}
+
+	var err error
+	select {
+	case err = <-serveErr:
+		log.Error().Err(err).Msg("Server failed")
+	case <-ctx.Done():
+		log.Info().Msg("Shutting down the server ...")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Params.ShutdownTimeout)
+	defer cancel()
+	// Перестать принимать соединения и дождаться завершения активных запросов
+	if shutdownErr := srv.Shutdown(shutdownCtx); shutdownErr != nil {
+		err = errors.Join(err, fmt.Errorf("server shutdown: %w", shutdownErr))
+	}
+	if grpcSrv != nil {
+		if shutdownErr := stopGRPC(shutdownCtx, grpcSrv); shutdownErr != nil {
+			err = errors.Join(err, fmt.Errorf("gRPC server shutdown: %w", shutdownErr))
+		}
+	}
+	// Время на остановку фоновых обработчиков отсчитывается заново, даже если запросы завершались до таймаута.
+	// Запросы, не завершенные до таймаута, не могут поставить задачи в закрытые очереди.
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), config.Params.ShutdownTimeout)
+	defer cancelDrain()
+	if shutdownErr := a.Shutdown(drainCtx); shutdownErr != nil {
+		err = errors.Join(err, fmt.Errorf("app shutdown: %w", shutdownErr))
+	}
+	if err == nil {
+		log.Info().Msg("Server stopped")
+	}
+	return err
+}
+
+// stopGRPC - останавливает gRPC сервер, дождавшись завершения активных запросов.
+// Если запросы не завершились до отмены ctx, то соединения закрываются принудительно.
+func stopGRPC(ctx context.Context, srv *grpc.Server) error {
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		srv.Stop()
+		return ctx.Err()
 	}
 }
@@ -1,14 +1,35 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/vadim-ivlev/url-shortener/api/shortenerpb"
+	"github.com/vadim-ivlev/url-shortener/internal/app"
+	"github.com/vadim-ivlev/url-shortener/internal/auth"
 	"github.com/vadim-ivlev/url-shortener/internal/config"
+	"github.com/vadim-ivlev/url-shortener/internal/handlers"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+	"github.com/vadim-ivlev/url-shortener/internal/shortener"
+	"github.com/vadim-ivlev/url-shortener/internal/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 func TestMain(m *testing.M) {
 	config.ParseCommandLine()
+	auth.Init()
 	os.Exit(m.Run())
 }
 
@@ -20,7 +41,197 @@ func TestServe(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ServeChi()
+			ServeChi(context.Background(), nil)
 		})
 	}
 }
+
+// newTestRouter создает маршрутизатор с независимым приложением, хранящим данные в памяти.
+func newTestRouter(t *testing.T) http.Handler {
+	a := app.New(storage.NewMemory(), shortener.HashGenerator{}, "http://localhost:8080")
+	a.Start()
+	t.Cleanup(a.Stop)
+	return NewRouter(handlers.New(a))
+}
+
+func TestIndependentRouters(t *testing.T) {
+	first, second := newTestRouter(t), newTestRouter(t)
+
+	// Сокращаем URL на первом сервере
+	rec := httptest.NewRecorder()
+	first.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://example.com")))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	shortID := strings.TrimPrefix(rec.Body.String(), "http://localhost:8080/")
+
+	// Первый сервер перенаправляет по короткому URL
+	rec = httptest.NewRecorder()
+	first.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+shortID, nil))
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+
+	// Второй сервер ничего не знает о коротком URL первого
+	rec = httptest.NewRecorder()
+	second.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+shortID, nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Тот же URL на втором сервере сохраняется как новый
+	rec = httptest.NewRecorder()
+	second.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://example.com")))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+}
+
+// blockingRepository - хранилище, чтение из которого ждет разрешения, чтобы запрос оставался активным.
+type blockingRepository struct {
+	*storage.Memory
+	// entered - закрывается, когда запрос начал чтение
+	entered chan struct{}
+	// release - закрывается, чтобы завершить чтение
+	release chan struct{}
+	closed  atomic.Bool
+}
+
+func (r *blockingRepository) Get(ctx context.Context, shortID string) (repository.Record, error) {
+	close(r.entered)
+	<-r.release
+	return r.Memory.Get(ctx, shortID)
+}
+
+func (r *blockingRepository) Close() error {
+	r.closed.Store(true)
+	return nil
+}
+
+// TestGracefulShutdown - после отмены контекста сервер дожидается активного запроса и закрывает хранилище.
+func TestGracefulShutdown(t *testing.T) {
+	repo := &blockingRepository{Memory: storage.NewMemory(), entered: make(chan struct{}), release: make(chan struct{})}
+	_, _, err := repo.Save(context.Background(), repository.Record{ShortID: "AAAA", OriginalURL: "https://example.com"})
+	assert.NoError(t, err)
+	a := app.New(repo, shortener.HashGenerator{}, "http://localhost:8080")
+	a.Start()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, a, ln, nil) }()
+
+	// Активный запрос
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	status := make(chan int, 1)
+	go func() {
+		resp, err := client.Get("http://" + ln.Addr().String() + "/AAAA")
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	<-repo.entered
+
+	cancel()
+	select {
+	case <-served:
+		t.Fatal("server stopped before the active request finished")
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.False(t, repo.closed.Load())
+
+	close(repo.release)
+	assert.Equal(t, http.StatusTemporaryRedirect, <-status)
+	assert.NoError(t, <-served)
+	assert.True(t, repo.closed.Load())
+
+	// Новые соединения не принимаются
+	_, err = client.Get("http://" + ln.Addr().String() + "/AAAA")
+	assert.Error(t, err)
+}
+
+func TestReadHeaderTimeout(t *testing.T) {
+	saved := config.Params.ReadHeaderTimeout
+	config.Params.ReadHeaderTimeout = 100 * time.Millisecond
+	defer func() { config.Params.ReadHeaderTimeout = saved }()
+
+	a := app.New(storage.NewMemory(), shortener.HashGenerator{}, "http://localhost:8080")
+	a.Start()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, a, ln, nil) }()
+	defer func() {
+		cancel()
+		assert.NoError(t, <-served)
+	}()
+
+	// Клиент отправляет заголовки слишком медленно - сервер закрывает соединение
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /ping HTTP/1.1\r\nHost: localhost\r\n"))
+	assert.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	for err == nil {
+		_, err = conn.Read(buf)
+	}
+	var netErr net.Error
+	assert.False(t, errors.As(err, &netErr) && netErr.Timeout(), "connection was not closed by the server")
+}
+
+func TestMetrics(t *testing.T) {
+	router := newTestRouter(t)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://metrics.example.com")))
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `shortener_http_requests_total{method="POST",route="/",status="201"}`)
+	assert.Contains(t, rec.Body.String(), `shortener_http_request_duration_seconds_bucket{method="POST",route="/",status="201"`)
+}
+
+func TestServeGRPC(t *testing.T) {
+	a := app.New(storage.NewMemory(), shortener.HashGenerator{}, "http://localhost:8080")
+	a.Start()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	grpcLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, a, ln, grpcLn) }()
+
+	conn, err := grpc.NewClient(grpcLn.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if !assert.NoError(t, err) {
+		cancel()
+		return
+	}
+	defer conn.Close()
+	client := shortenerpb.NewShortenerClient(conn)
+	resp, err := client.Shorten(context.Background(), &shortenerpb.ShortenRequest{Url: "https://grpc.example.com"})
+	assert.NoError(t, err)
+	assert.True(t, resp.GetCreated())
+
+	// Короткий URL, созданный через gRPC, доступен через HTTP
+	httpClient := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	httpResp, err := httpClient.Get("http://" + ln.Addr().String() + "/" + a.ShortID(resp.GetShortUrl()))
+	if assert.NoError(t, err) {
+		httpResp.Body.Close()
+		assert.Equal(t, http.StatusTemporaryRedirect, httpResp.StatusCode)
+	}
+
+	cancel()
+	assert.NoError(t, <-served)
+	_, err = client.Ping(context.Background(), &shortenerpb.PingRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
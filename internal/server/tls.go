@@ -0,0 +1,175 @@
+// Description: Настройки TLS сервера.
+// Сертификат и ключ берутся из файлов, указанных в конфигурации. Если они не указаны, то для разработки
+// используется самоподписанный сертификат, который создается в директории кеша и переиспользуется при следующих запусках.
+// Браузеры и клиенты не доверяют самоподписанному сертификату, поэтому в рабочей среде нужно указывать настоящий.
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vadim-ivlev/url-shortener/internal/config"
+)
+
+// Параметры самоподписанного сертификата
+const (
+	// selfSignedValidity - срок действия сертификата
+	selfSignedValidity = 365 * 24 * time.Hour
+	// selfSignedRenewBefore - сертификат создается заново, если до окончания его срока осталось меньше
+	selfSignedRenewBefore = 24 * time.Hour
+	// selfSignedCertFile, selfSignedKeyFile - имена файлов сертификата и ключа в директории кеша
+	selfSignedCertFile = "cert.pem"
+	selfSignedKeyFile  = "key.pem"
+)
+
+// NewTLSConfig - создает настройки TLS с сертификатом из конфигурации:
+// из файлов config.Params.TLSCertFile и config.Params.TLSKeyFile или, если они не указаны,
+// самоподписанным сертификатом из директории config.Params.TLSCacheDir.
+func NewTLSConfig() (*tls.Config, error) {
+	certFile, keyFile := config.Params.TLSCertFile, config.Params.TLSKeyFile
+	switch {
+	case certFile != "" && keyFile != "":
+	case certFile != "" || keyFile != "":
+		return nil, errors.New("both TLS certificate and key files must be specified")
+	default:
+		var err error
+		certFile, keyFile, err = selfSignedCertificate(config.Params.TLSCacheDir, certificateHosts())
+		if err != nil {
+			return nil, err
+		}
+		log.Warn().Str("cert", certFile).Msg("Using a self-signed TLS certificate. Do not use it in production")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return tlsConfig(cert), nil
+}
+
+// tlsConfig - возвращает настройки TLS с сертификатом cert:
+// не ниже TLS 1.2 и, для TLS 1.2, только наборы шифров с прямой секретностью и шифрованием AEAD.
+// Наборы шифров TLS 1.3 не настраиваются и все безопасны.
+func tlsConfig(cert tls.Certificate) *tls.Config {
+	return &tls.Config{
+		Certificates:     []tls.Certificate{cert},
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+	}
+}
+
+// certificateHosts - возвращает имена и адреса, для которых выдается самоподписанный сертификат:
+// localhost, адреса обратной петли, хост адреса сервера и хост BaseURL.
+func certificateHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if host, _, err := net.SplitHostPort(config.Params.ServerAddress); err == nil && host != "" {
+		hosts = append(hosts, host)
+	}
+	if u, err := url.Parse(config.Params.BaseURL); err == nil && u.Hostname() != "" {
+		hosts = append(hosts, u.Hostname())
+	}
+	return hosts
+}
+
+// selfSignedCertificate - возвращает пути к самоподписанному сертификату и ключу в директории dir.
+// Сертификат создается, если его нет, его срок скоро истекает или он не подходит для одного из хостов hosts.
+func selfSignedCertificate(dir string, hosts []string) (certFile, keyFile string, err error) {
+	certFile = filepath.Join(dir, selfSignedCertFile)
+	keyFile = filepath.Join(dir, selfSignedKeyFile)
+	if cachedCertificateValid(certFile, keyFile, hosts) {
+		return certFile, keyFile, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", err
+	}
+	if err := generateSelfSigned(certFile, keyFile, hosts); err != nil {
+		return "", "", err
+	}
+	log.Info().Str("cert", certFile).Strs("hosts", hosts).Msg("Self-signed TLS certificate generated")
+	return certFile, keyFile, nil
+}
+
+// cachedCertificateValid - проверяет, что сертификат из кеша можно использовать для хостов hosts.
+func cachedCertificateValid(certFile, keyFile string, hosts []string) bool {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return false
+	}
+	if time.Until(cert.NotAfter) < selfSignedRenewBefore {
+		return false
+	}
+	for _, host := range hosts {
+		if cert.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// generateSelfSigned - создает самоподписанный сертификат для хостов hosts с ключом ECDSA P-256
+// и записывает сертификат в certFile, а ключ в keyFile (доступный только владельцу).
+func generateSelfSigned(certFile, keyFile string, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"url-shortener development"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vadim-ivlev/url-shortener/internal/app"
+	"github.com/vadim-ivlev/url-shortener/internal/shortener"
+	"github.com/vadim-ivlev/url-shortener/internal/storage"
+)
+
+func TestSelfSignedCertificate(t *testing.T) {
+	dir := t.TempDir() + "/tls"
+	hosts := []string{"localhost", "127.0.0.1"}
+
+	certFile, keyFile, err := selfSignedCertificate(dir, hosts)
+	if !assert.NoError(t, err) {
+		return
+	}
+	info, err := os.Stat(keyFile)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	first, err := os.ReadFile(certFile)
+	assert.NoError(t, err)
+
+	// Сертификат из кеша переиспользуется
+	_, _, err = selfSignedCertificate(dir, hosts)
+	assert.NoError(t, err)
+	second, err := os.ReadFile(certFile)
+	assert.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	// Для нового хоста сертификат создается заново
+	_, _, err = selfSignedCertificate(dir, append(hosts, "short.example.com"))
+	assert.NoError(t, err)
+	third, err := os.ReadFile(certFile)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, third)
+	assert.True(t, cachedCertificateValid(certFile, keyFile, []string{"short.example.com"}))
+}
+
+// TestServeTLS - сервер с самоподписанным сертификатом отвечает по HTTPS не ниже TLS 1.2.
+func TestServeTLS(t *testing.T) {
+	certFile, keyFile, err := selfSignedCertificate(t.TempDir(), []string{"localhost", "127.0.0.1"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	a := app.New(storage.NewMemory(), shortener.HashGenerator{}, "https://localhost:8080")
+	a.Start()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, a, tls.NewListener(ln, tlsConfig(cert)), nil) }()
+	defer func() {
+		cancel()
+		assert.NoError(t, <-served)
+	}()
+
+	pem, err := os.ReadFile(certFile)
+	assert.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(pem)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+
+	resp, err := client.Post("https://"+ln.Addr().String()+"/", "text/plain", strings.NewReader("https://example.com"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(string(body), "https://localhost:8080/"))
+	assert.GreaterOrEqual(t, resp.TLS.Version, uint16(tls.VersionTLS12))
+
+	// Устаревшие версии TLS не поддерживаются
+	old := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, MaxVersion: tls.VersionTLS11}}}
+	_, err = old.Get("https://" + ln.Addr().String() + "/ping")
+	assert.Error(t, err)
+}
@@ -0,0 +1,55 @@
+// Description: Проверка пользовательских коротких id (алиасов).
+
+package shortener
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Ограничения на длину алиаса
+const (
+	AliasMinLength = 3
+	AliasMaxLength = 64
+)
+
+// ErrInvalidAlias - алиас содержит недопустимые символы или имеет недопустимую длину.
+var ErrInvalidAlias = fmt.Errorf("alias must be %d-%d characters long and contain only letters, digits, '-' and '_'", AliasMinLength, AliasMaxLength)
+
+// ErrReservedAlias - алиас совпадает с зарезервированным словом.
+var ErrReservedAlias = errors.New("alias is reserved")
+
+// ReservedAliases - зарезервированные слова, которые нельзя использовать в качестве алиаса,
+// так как они совпадают с путями эндпоинтов сервиса. Сравнение без учета регистра.
+var ReservedAliases = map[string]bool{
+	"api":     true,
+	"ping":    true,
+	"metrics": true,
+	"health":  true,
+}
+
+// ValidateAlias проверяет, может ли alias использоваться как короткий id.
+// Возвращает ErrInvalidAlias или ErrReservedAlias, если не может.
+func ValidateAlias(alias string) error {
+	if len(alias) < AliasMinLength || len(alias) > AliasMaxLength {
+		return ErrInvalidAlias
+	}
+	for _, c := range alias {
+		if !isAliasChar(c) {
+			return ErrInvalidAlias
+		}
+	}
+	if ReservedAliases[strings.ToLower(alias)] {
+		return ErrReservedAlias
+	}
+	return nil
+}
+
+// isAliasChar - проверяет, допустим ли символ в алиасе.
+func isAliasChar(c rune) bool {
+	return c >= 'a' && c <= 'z' ||
+		c >= 'A' && c <= 'Z' ||
+		c >= '0' && c <= '9' ||
+		c == '-' || c == '_'
+}
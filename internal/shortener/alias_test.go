@@ -0,0 +1,35 @@
+package shortener
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateAlias(t *testing.T) {
+	tests := []struct {
+		name    string
+		alias   string
+		wantErr error
+	}{
+		{name: "valid", alias: "summer-sale", wantErr: nil},
+		{name: "underscore and digits", alias: "Promo_2024", wantErr: nil},
+		{name: "too short", alias: "ab", wantErr: ErrInvalidAlias},
+		{name: "too long", alias: strings.Repeat("a", AliasMaxLength+1), wantErr: ErrInvalidAlias},
+		{name: "slash", alias: "a/b/c", wantErr: ErrInvalidAlias},
+		{name: "non ascii", alias: "привет", wantErr: ErrInvalidAlias},
+		{name: "reserved", alias: "ping", wantErr: ErrReservedAlias},
+		{name: "reserved upper case", alias: "API", wantErr: ErrReservedAlias},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAlias(tt.alias)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+}
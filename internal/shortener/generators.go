@@ -0,0 +1,250 @@
+// Description: Стратегии генерации коротких id.
+// Все стратегии реализуют интерфейс Generator и выбираются в конфигурации приложения.
+// - hash    - FNV-хеш оригинального URL (по умолчанию);
+// - counter - монотонный счетчик в кодировке base62;
+// - random  - криптографически случайный id заданной длины и алфавита;
+// - sqids   - обратимая кодировка счетчика в стиле Sqids/Hashids.
+
+package shortener
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sqids/sqids-go"
+)
+
+// Base62Alphabet - алфавит по умолчанию для коротких id.
+const Base62Alphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// Generator - генератор коротких id.
+type Generator interface {
+	// Generate возвращает короткий id для значения value.
+	// attempt - номер попытки, начиная с 0. Увеличивается при коллизии сгенерированного id.
+	Generate(ctx context.Context, value string, attempt int) (key string, err error)
+}
+
+// Counter - источник монотонно возрастающих чисел для генераторов на основе счетчика.
+type Counter interface {
+	// Next возвращает следующее значение счетчика.
+	Next(ctx context.Context) (uint64, error)
+}
+
+// Decoder - генератор, id которого преобразуются обратно в значения счетчика.
+type Decoder interface {
+	// Decode возвращает значение счетчика, из которого получен id key.
+	// Возвращает false, если id не является корректным id этого генератора.
+	Decode(key string) (n uint64, ok bool)
+}
+
+// CounterFunc - адаптер, позволяющий использовать обычную функцию как Counter.
+type CounterFunc func(ctx context.Context) (uint64, error)
+
+// Next вызывает f(ctx).
+func (f CounterFunc) Next(ctx context.Context) (uint64, error) {
+	return f(ctx)
+}
+
+// MemoryCounter - счетчик в памяти. Используется, если нет постоянного хранилища.
+type MemoryCounter struct {
+	n atomic.Uint64
+}
+
+// Next возвращает следующее значение счетчика.
+func (c *MemoryCounter) Next(ctx context.Context) (uint64, error) {
+	return c.n.Add(1), nil
+}
+
+// isReserved - проверяет, совпадает ли сгенерированный id с зарезервированным словом (см. ReservedAliases).
+// Генераторы пропускают такие id, так как они совпадают с путями эндпоинтов сервиса.
+func isReserved(key string) bool {
+	return ReservedAliases[strings.ToLower(key)]
+}
+
+// HashGenerator - генерирует id как FNV-хеш значения. При коллизиях добавляет соль.
+type HashGenerator struct{}
+
+// Generate возвращает ShortenAttempt(value, attempt).
+// Если id совпадает с зарезервированным словом, то используется следующий номер попытки.
+func (HashGenerator) Generate(ctx context.Context, value string, attempt int) (string, error) {
+	key := ShortenAttempt(value, attempt)
+	for isReserved(key) {
+		attempt++
+		key = ShortenAttempt(value, attempt)
+	}
+	return key, nil
+}
+
+// CounterGenerator - генерирует последовательные id, кодируя значение счетчика в base62.
+type CounterGenerator struct {
+	Counter Counter
+}
+
+// Generate возвращает следующее значение счетчика в кодировке base62.
+// Значения, которые кодируются зарезервированными словами, пропускаются.
+func (g CounterGenerator) Generate(ctx context.Context, value string, attempt int) (string, error) {
+	for {
+		n, err := g.Counter.Next(ctx)
+		if err != nil {
+			return "", err
+		}
+		if key := EncodeBase62(n); !isReserved(key) {
+			return key, nil
+		}
+	}
+}
+
+// Decode восстанавливает значение счетчика по id.
+// Возвращает false, если id не является корректным id этого генератора.
+func (g CounterGenerator) Decode(key string) (n uint64, ok bool) {
+	return DecodeBase62(key)
+}
+
+// EncodeBase62 кодирует число в строку в алфавите Base62Alphabet.
+func EncodeBase62(n uint64) string {
+	if n == 0 {
+		return Base62Alphabet[:1]
+	}
+	buf := make([]byte, 0, 11)
+	for n > 0 {
+		buf = append(buf, Base62Alphabet[n%62])
+		n /= 62
+	}
+	// Разворачиваем, чтобы старшие разряды были в начале
+	for i, j := 0, len(buf)-1; i < j; i, j = i+1, j-1 {
+		buf[i], buf[j] = buf[j], buf[i]
+	}
+	return string(buf)
+}
+
+// DecodeBase62 декодирует строку, полученную EncodeBase62.
+// Возвращает false, если строка содержит символы не из Base62Alphabet, начинается с незначащего нуля
+// или не помещается в uint64.
+func DecodeBase62(s string) (n uint64, ok bool) {
+	if s == "" || (len(s) > 1 && s[0] == Base62Alphabet[0]) {
+		return 0, false
+	}
+	for i := 0; i < len(s); i++ {
+		digit := strings.IndexByte(Base62Alphabet, s[i])
+		if digit < 0 || n > (math.MaxUint64-uint64(digit))/62 {
+			return 0, false
+		}
+		n = n*62 + uint64(digit)
+	}
+	return n, true
+}
+
+// RandomGenerator - генерирует криптографически случайные id.
+type RandomGenerator struct {
+	// Length - длина id
+	Length int
+	// Alphabet - символы, из которых состоит id
+	Alphabet string
+}
+
+// NewRandomGenerator создает RandomGenerator, проверяя параметры.
+// Параметры:
+// - length - длина id
+// - alphabet - символы, из которых состоит id. Если пустой, то используется Base62Alphabet.
+// Алфавит должен состоять из неповторяющихся символов, допустимых в алиасе: латинских букв, цифр, '-' и '_',
+// чтобы id не требовал экранирования в пути URL.
+func NewRandomGenerator(length int, alphabet string) (*RandomGenerator, error) {
+	if alphabet == "" {
+		alphabet = Base62Alphabet
+	}
+	if length <= 0 {
+		return nil, errors.New("random generator: length must be positive")
+	}
+	if len(alphabet) < 2 {
+		return nil, errors.New("random generator: alphabet must contain at least 2 characters")
+	}
+	seen := make(map[rune]bool, len(alphabet))
+	for _, c := range alphabet {
+		if !isAliasChar(c) {
+			return nil, fmt.Errorf("random generator: alphabet character %q is not allowed, use only letters, digits, '-' and '_'", c)
+		}
+		if seen[c] {
+			return nil, fmt.Errorf("random generator: alphabet character %q is repeated", c)
+		}
+		seen[c] = true
+	}
+	return &RandomGenerator{Length: length, Alphabet: alphabet}, nil
+}
+
+// Generate возвращает случайный id. Значение и номер попытки не используются.
+// Если id совпадает с зарезервированным словом, то генерируется новый.
+func (g *RandomGenerator) Generate(ctx context.Context, value string, attempt int) (string, error) {
+	size := big.NewInt(int64(len(g.Alphabet)))
+	buf := make([]byte, g.Length)
+	for {
+		for i := range buf {
+			n, err := rand.Int(rand.Reader, size)
+			if err != nil {
+				return "", err
+			}
+			buf[i] = g.Alphabet[n.Int64()]
+		}
+		if key := string(buf); !isReserved(key) {
+			return key, nil
+		}
+	}
+}
+
+// SqidsGenerator - генерирует обратимые id, кодируя значение счетчика алгоритмом Sqids.
+// В отличие от CounterGenerator, соседние id не выглядят последовательными.
+type SqidsGenerator struct {
+	Counter Counter
+	sqids   *sqids.Sqids
+}
+
+// NewSqidsGenerator создает SqidsGenerator.
+// Параметры:
+// - counter - источник значений счетчика
+// - minLength - минимальная длина id
+// - alphabet - символы, из которых состоит id. Если пустой, то используется алфавит Sqids по умолчанию.
+func NewSqidsGenerator(counter Counter, minLength int, alphabet string) (*SqidsGenerator, error) {
+	if minLength < 0 || minLength > 255 {
+		return nil, errors.New("sqids generator: min length must be in range 0..255")
+	}
+	s, err := sqids.New(sqids.Options{Alphabet: alphabet, MinLength: uint8(minLength)})
+	if err != nil {
+		return nil, err
+	}
+	return &SqidsGenerator{Counter: counter, sqids: s}, nil
+}
+
+// Generate возвращает следующее значение счетчика в кодировке Sqids.
+// Значения, которые кодируются зарезервированными словами, пропускаются.
+func (g *SqidsGenerator) Generate(ctx context.Context, value string, attempt int) (string, error) {
+	for {
+		n, err := g.Counter.Next(ctx)
+		if err != nil {
+			return "", err
+		}
+		key, err := g.sqids.Encode([]uint64{n})
+		if err != nil || !isReserved(key) {
+			return key, err
+		}
+	}
+}
+
+// Decode восстанавливает значение счетчика по id.
+// Возвращает false, если id не является корректным id этого генератора.
+func (g *SqidsGenerator) Decode(key string) (n uint64, ok bool) {
+	numbers := g.sqids.Decode(key)
+	if len(numbers) != 1 {
+		return 0, false
+	}
+	// Проверяем каноничность id, так как Sqids допускает несколько вариантов записи
+	canonical, err := g.sqids.Encode(numbers)
+	if err != nil || canonical != key {
+		return 0, false
+	}
+	return numbers[0], true
+}
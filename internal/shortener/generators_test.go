@@ -0,0 +1,120 @@
+package shortener
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeBase62(t *testing.T) {
+	tests := []struct {
+		name string
+		n    uint64
+		want string
+	}{
+		{name: "zero", n: 0, want: "0"},
+		{name: "one", n: 1, want: "1"},
+		{name: "last digit", n: 61, want: "Z"},
+		{name: "two digits", n: 62, want: "10"},
+		{name: "big", n: 3843, want: "ZZ"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, EncodeBase62(tt.n))
+			n, ok := DecodeBase62(tt.want)
+			assert.True(t, ok)
+			assert.Equal(t, tt.n, n)
+		})
+	}
+
+	// Строки, которые EncodeBase62 не возвращает, не декодируются
+	for _, s := range []string{"", "01", "a-b", "zzzzzzzzzzzzzzzzzzzz"} {
+		_, ok := DecodeBase62(s)
+		assert.False(t, ok, s)
+	}
+}
+
+func TestHashGenerator(t *testing.T) {
+	g := HashGenerator{}
+	for attempt := 0; attempt < 3; attempt++ {
+		key, err := g.Generate(context.Background(), "https://www.google.com", attempt)
+		assert.NoError(t, err)
+		assert.Equal(t, ShortenAttempt("https://www.google.com", attempt), key)
+	}
+}
+
+func TestCounterGenerator(t *testing.T) {
+	g := CounterGenerator{Counter: &MemoryCounter{}}
+	want := []string{"1", "2", "3"}
+	for _, w := range want {
+		key, err := g.Generate(context.Background(), "https://www.google.com", 0)
+		assert.NoError(t, err)
+		assert.Equal(t, w, key)
+	}
+}
+
+func TestCounterGeneratorReserved(t *testing.T) {
+	// 40008 в base62 - "api", 6028834 - "ping"
+	for _, n := range []uint64{40008, 6028834} {
+		counter := &MemoryCounter{}
+		counter.n.Store(n - 1)
+		g := CounterGenerator{Counter: counter}
+		key, err := g.Generate(context.Background(), "https://www.google.com", 0)
+		assert.NoError(t, err)
+		assert.Equal(t, EncodeBase62(n+1), key)
+	}
+}
+
+func TestRandomGenerator(t *testing.T) {
+	_, err := NewRandomGenerator(0, "")
+	assert.Error(t, err)
+	for _, alphabet := range []string{"a", "abca", "ab/", "ab?", "ab#", "abé"} {
+		_, err = NewRandomGenerator(8, alphabet)
+		assert.Error(t, err, alphabet)
+	}
+
+	g, err := NewRandomGenerator(12, "abc")
+	assert.NoError(t, err)
+	seen := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		key, err := g.Generate(context.Background(), "https://www.google.com", 0)
+		assert.NoError(t, err)
+		assert.Len(t, key, 12)
+		assert.Empty(t, strings.Trim(key, "abc"))
+		seen[key] = true
+	}
+	// Вероятность совпадения 12-символьных id из 3^12 вариантов пренебрежимо мала
+	assert.Greater(t, len(seen), 1)
+
+	// Зарезервированное слово не выдается
+	g, err = NewRandomGenerator(3, "api")
+	assert.NoError(t, err)
+	for i := 0; i < 200; i++ {
+		key, err := g.Generate(context.Background(), "https://www.google.com", 0)
+		assert.NoError(t, err)
+		assert.NotEqual(t, "api", key)
+	}
+}
+
+func TestSqidsGenerator(t *testing.T) {
+	_, err := NewSqidsGenerator(&MemoryCounter{}, 300, "")
+	assert.Error(t, err)
+
+	g, err := NewSqidsGenerator(&MemoryCounter{}, 6, "")
+	assert.NoError(t, err)
+	for want := uint64(1); want <= 3; want++ {
+		key, err := g.Generate(context.Background(), "https://www.google.com", 0)
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, len(key), 6)
+
+		// id обратим
+		n, ok := g.Decode(key)
+		assert.True(t, ok)
+		assert.Equal(t, want, n)
+	}
+
+	_, ok := g.Decode("not a sqid!")
+	assert.False(t, ok)
+}
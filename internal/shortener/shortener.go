@@ -3,11 +3,32 @@ package shortener
 import (
 	"fmt"
 	"hash/fnv"
+	"strconv"
 )
 
-// Shorten генерирует укороченный ключ для данного значения.
-func Shorten(value string) (key string) {
+// HashFunc - хеш-функция, используемая для генерации коротких ключей.
+// Вынесена в переменную, чтобы в тестах можно было подменить её и смоделировать коллизии.
+var HashFunc = func(value string) uint32 {
 	hash := fnv.New32()
 	hash.Write([]byte(value))
-	return fmt.Sprintf("%X", hash.Sum32())
+	return hash.Sum32()
+}
+
+// Shorten генерирует укороченный ключ для данного значения.
+func Shorten(value string) (key string) {
+	return fmt.Sprintf("%X", HashFunc(value))
+}
+
+// ShortenAttempt генерирует укороченный ключ для данного значения с учетом номера попытки.
+// Попытка 0 дает тот же ключ, что и Shorten. Для последующих попыток к значению
+// добавляется соль с номером попытки, что позволяет детерминированно получить
+// новый ключ при коллизии.
+// Параметры:
+// - value - исходное значение
+// - attempt - номер попытки, начиная с 0
+func ShortenAttempt(value string, attempt int) (key string) {
+	if attempt == 0 {
+		return Shorten(value)
+	}
+	return Shorten(value + "#" + strconv.Itoa(attempt))
 }
@@ -43,3 +43,19 @@ func TestShorten(t *testing.T) {
 		})
 	}
 }
+
+func TestShortenAttempt(t *testing.T) {
+	value := "https://www.google.com"
+
+	// Попытка 0 совпадает с Shorten
+	assert.Equal(t, Shorten(value), ShortenAttempt(value, 0))
+
+	// Последующие попытки дают разные, но детерминированные ключи
+	seen := map[string]bool{}
+	for attempt := 0; attempt < 5; attempt++ {
+		key := ShortenAttempt(value, attempt)
+		assert.False(t, seen[key], "duplicate key %v on attempt %d", key, attempt)
+		seen[key] = true
+		assert.Equal(t, key, ShortenAttempt(value, attempt))
+	}
+}
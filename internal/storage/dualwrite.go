@@ -0,0 +1,160 @@
+// Description: Хранилище с двойной записью для переноса данных между хранилищами без остановки сервиса.
+// Основное хранилище (например, PostgreSQL) обслуживает чтение и запись и определяет результат операций.
+// Изменения, успешно сохраненные в основном хранилище, повторяются во вторичном (например, в файле),
+// поэтому на вторичное хранилище можно переключиться в любой момент.
+// Ошибки вторичного хранилища пишутся в лог и не влияют на ответ клиенту.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vadim-ivlev/url-shortener/internal/analytics"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+)
+
+// DualWrite - хранилище, повторяющее изменения основного хранилища во вторичном.
+type DualWrite struct {
+	// Primary - основное хранилище
+	Primary repository.Repository
+	// Secondary - вторичное хранилище
+	Secondary repository.Repository
+}
+
+// NewDualWrite создает хранилище с основным хранилищем primary и вторичным secondary.
+func NewDualWrite(primary, secondary repository.Repository) *DualWrite {
+	return &DualWrite{Primary: primary, Secondary: secondary}
+}
+
+// secondaryFailed - пишет в лог ошибку вторичного хранилища.
+func secondaryFailed(err error, op string) {
+	if err != nil {
+		log.Warn().Err(err).Str("op", op).Msg("DualWrite. Secondary storage write failed")
+	}
+}
+
+// Save сохраняет запись в основном хранилище и, если она новая, во вторичном.
+func (d *DualWrite) Save(ctx context.Context, record repository.Record) (saved repository.Record, isNew bool, err error) {
+	saved, isNew, err = d.Primary.Save(ctx, record)
+	if err != nil || !isNew {
+		return saved, isNew, err
+	}
+	_, _, secondaryErr := d.Secondary.Save(ctx, saved)
+	if errors.Is(secondaryErr, repository.ErrConflict) {
+		secondaryErr = nil
+	}
+	secondaryFailed(secondaryErr, "save")
+	return saved, isNew, nil
+}
+
+// SaveBatch сохраняет пачку в основном хранилище и новые записи пачки во вторичном.
+func (d *DualWrite) SaveBatch(ctx context.Context, records []repository.Record) (results []repository.SaveResult, err error) {
+	results, err = d.Primary.SaveBatch(ctx, records)
+	if err != nil {
+		return nil, err
+	}
+	created := make([]repository.Record, 0, len(results))
+	for _, result := range results {
+		if result.Err == nil && result.IsNew {
+			created = append(created, result.Record)
+		}
+	}
+	if len(created) > 0 {
+		_, secondaryErr := d.Secondary.SaveBatch(ctx, created)
+		secondaryFailed(secondaryErr, "save batch")
+	}
+	return results, nil
+}
+
+// Get возвращает запись из основного хранилища.
+func (d *DualWrite) Get(ctx context.Context, shortID string) (repository.Record, error) {
+	return d.Primary.Get(ctx, shortID)
+}
+
+// GetByOriginal возвращает запись из основного хранилища.
+func (d *DualWrite) GetByOriginal(ctx context.Context, originalURL string) (repository.Record, error) {
+	return d.Primary.GetByOriginal(ctx, originalURL)
+}
+
+// ListByUser возвращает страницу записей пользователя из основного хранилища.
+func (d *DualWrite) ListByUser(ctx context.Context, userID, afterShortID string, limit int) ([]repository.Record, error) {
+	return d.Primary.ListByUser(ctx, userID, afterShortID, limit)
+}
+
+// Delete помечает удаленными записи пользователя в основном и во вторичном хранилищах.
+func (d *DualWrite) Delete(ctx context.Context, userID string, shortIDs []string) error {
+	if err := d.Primary.Delete(ctx, userID, shortIDs); err != nil {
+		return err
+	}
+	secondaryFailed(d.Secondary.Delete(ctx, userID, shortIDs), "delete")
+	return nil
+}
+
+// DeleteExpired удаляет просроченные записи в основном и во вторичном хранилищах.
+// Возвращает количество записей, удаленных в основном хранилище.
+func (d *DualWrite) DeleteExpired(ctx context.Context, now time.Time) (n int, err error) {
+	n, err = d.Primary.DeleteExpired(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	_, secondaryErr := d.Secondary.DeleteExpired(ctx, now)
+	secondaryFailed(secondaryErr, "delete expired")
+	return n, nil
+}
+
+// Ping проверяет доступность основного хранилища.
+func (d *DualWrite) Ping(ctx context.Context) error {
+	return d.Primary.Ping(ctx)
+}
+
+// Close закрывает оба хранилища.
+func (d *DualWrite) Close() error {
+	return errors.Join(d.Primary.Close(), d.Secondary.Close())
+}
+
+// SaveClicks сохраняет переходы в основном и во вторичном хранилищах.
+func (d *DualWrite) SaveClicks(ctx context.Context, clicks []analytics.Click) error {
+	if err := d.Primary.SaveClicks(ctx, clicks); err != nil {
+		return err
+	}
+	secondaryFailed(d.Secondary.SaveClicks(ctx, clicks), "save clicks")
+	return nil
+}
+
+// ClickStats возвращает статистику переходов из основного хранилища.
+func (d *DualWrite) ClickStats(ctx context.Context, shortID string) (analytics.Stats, error) {
+	return d.Primary.ClickStats(ctx, shortID)
+}
+
+// NextSequence возвращает следующее значение счетчика основного хранилища.
+// Если основное хранилище не предоставляет счетчик, возвращает ошибку.
+func (d *DualWrite) NextSequence(ctx context.Context) (uint64, error) {
+	seq, ok := d.Primary.(repository.Sequencer)
+	if !ok {
+		return 0, errors.New("primary storage does not provide a sequence")
+	}
+	return seq.NextSequence(ctx)
+}
+
+// AdvanceSequence продвигает счетчик основного хранилища.
+// Если основное хранилище не предоставляет счетчик, возвращает ошибку.
+func (d *DualWrite) AdvanceSequence(ctx context.Context, n uint64) error {
+	seq, ok := d.Primary.(repository.SequenceAdvancer)
+	if !ok {
+		return errors.New("primary storage does not provide a sequence")
+	}
+	return seq.AdvanceSequence(ctx, n)
+}
+
+// Export перечисляет записи основного хранилища.
+// Если основное хранилище не поддерживает перечисление, возвращает ошибку.
+func (d *DualWrite) Export(ctx context.Context, fn func(repository.Record) error) error {
+	exporter, ok := d.Primary.(repository.Exporter)
+	if !ok {
+		return errors.New("primary storage does not support export")
+	}
+	return exporter.Export(ctx, fn)
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+)
+
+func TestDualWrite(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := NewMemory(), NewMemory()
+	d := NewDualWrite(primary, secondary)
+
+	// Новая запись сохраняется в обоих хранилищах
+	_, isNew, err := d.Save(ctx, repository.Record{ShortID: "AAAA", OriginalURL: "https://a.com", UserID: "user-1"})
+	assert.NoError(t, err)
+	assert.True(t, isNew)
+	_, err = secondary.Get(ctx, "AAAA")
+	assert.NoError(t, err)
+
+	results, err := d.SaveBatch(ctx, []repository.Record{
+		{ShortID: "BBBB", OriginalURL: "https://b.com", UserID: "user-1"},
+		{ShortID: "CCCC", OriginalURL: "https://c.com", ExpiresAt: time.Now().Add(time.Second)},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+	_, err = secondary.Get(ctx, "BBBB")
+	assert.NoError(t, err)
+
+	// Удаление повторяется во вторичном хранилище
+	assert.NoError(t, d.Delete(ctx, "user-1", []string{"BBBB"}))
+	record, err := secondary.Get(ctx, "BBBB")
+	assert.NoError(t, err)
+	assert.True(t, record.IsDeleted)
+	n, err := d.DeleteExpired(ctx, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	record, err = secondary.Get(ctx, "CCCC")
+	assert.NoError(t, err)
+	assert.True(t, record.IsDeleted)
+
+	// Ошибка вторичного хранилища не влияет на результат
+	_, _, err = secondary.Save(ctx, repository.Record{ShortID: "DDDD", OriginalURL: "https://other.com"})
+	assert.NoError(t, err)
+	saved, isNew, err := d.Save(ctx, repository.Record{ShortID: "DDDD", OriginalURL: "https://d.com"})
+	assert.NoError(t, err)
+	assert.True(t, isNew)
+	assert.Equal(t, "https://d.com", saved.OriginalURL)
+	record, err = d.Get(ctx, "DDDD")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://d.com", record.OriginalURL)
+
+	// Счетчик основного хранилища
+	seq, err := d.NextSequence(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), seq)
+}
@@ -0,0 +1,227 @@
+// Description: Ограниченный кеш записей о коротких URL с вытеснением давно не использованных записей (LRU).
+// Используется хранилищем в базе данных в режиме чтения через кеш, когда в памяти держатся
+// только часто запрашиваемые записи, а не вся таблица.
+// Кеш помнит и отсутствующие короткие id (отрицательное кеширование), чтобы запросы
+// несуществующих коротких URL не доходили до базы данных. Такие записи живут не дольше negativeTTL,
+// потому что короткий id может быть создан другим экземпляром сервиса.
+
+package storage
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+)
+
+// ErrCacheMiss - записи нет в кеше.
+var ErrCacheMiss = errors.New("cache miss")
+
+// CacheStats - счетчики кеша.
+type CacheStats struct {
+	// Hits - количество запросов, на которые ответил кеш, в том числе отрицательными записями
+	Hits int64
+	// Misses - количество запросов, не найденных в кеше
+	Misses int64
+	// Size - количество записей в кеше
+	Size int
+	// Capacity - максимальное количество записей в кеше
+	Capacity int
+}
+
+// lruEntry - запись кеша.
+type lruEntry struct {
+	record repository.Record
+	// notFound - отрицательная запись: короткого id нет в хранилище
+	notFound bool
+	// expiresAt - момент, после которого отрицательная запись недействительна
+	expiresAt time.Time
+}
+
+// live - запись о неудаленном коротком URL, которую можно найти по оригинальному URL.
+func (e *lruEntry) live() bool {
+	return !e.notFound && !e.record.IsDeleted
+}
+
+// LRU - потокобезопасный кеш записей с ограниченной емкостью.
+type LRU struct {
+	mutex    sync.Mutex
+	capacity int
+	// negativeTTL - время жизни отрицательных записей
+	negativeTTL time.Duration
+	// order - записи от недавно использованных к давно использованным
+	order *list.List
+	// byID - элементы order по короткому id
+	// byOriginal - элементы order с неудаленными записями по оригинальному URL
+	byID       map[string]*list.Element
+	byOriginal map[string]*list.Element
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// NewLRU создает пустой кеш емкостью capacity записей (не меньше 1).
+// Отрицательные записи живут negativeTTL.
+func NewLRU(capacity int, negativeTTL time.Duration) *LRU {
+	return &LRU{
+		capacity:    max(capacity, 1),
+		negativeTTL: negativeTTL,
+		order:       list.New(),
+		byID:        make(map[string]*list.Element),
+		byOriginal:  make(map[string]*list.Element),
+	}
+}
+
+// Get возвращает запись по короткому id.
+// Возвращает repository.ErrNotFound, если в кеше есть отрицательная запись, и ErrCacheMiss, если записи нет.
+func (c *LRU) Get(shortID string) (repository.Record, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	el, ok := c.byID[shortID]
+	if ok {
+		entry := el.Value.(*lruEntry)
+		if entry.notFound && time.Now().After(entry.expiresAt) {
+			c.remove(el)
+			ok = false
+		}
+	}
+	if !ok {
+		c.misses.Add(1)
+		return repository.Record{}, ErrCacheMiss
+	}
+	c.hits.Add(1)
+	c.order.MoveToFront(el)
+	entry := el.Value.(*lruEntry)
+	if entry.notFound {
+		return repository.Record{}, repository.ErrNotFound
+	}
+	return entry.record, nil
+}
+
+// GetByOriginal возвращает неудаленную запись по оригинальному URL. Возвращает ErrCacheMiss, если записи нет.
+func (c *LRU) GetByOriginal(originalURL string) (repository.Record, error) {
+	return c.getByOriginal(originalURL, true)
+}
+
+// PeekByOriginal возвращает запись по оригинальному URL так же, как GetByOriginal, но не учитывает запрос
+// в счетчиках попаданий и промахов. Используется для проверки перед сохранением, которая для нового URL
+// всегда промахивается и исказила бы долю попаданий.
+func (c *LRU) PeekByOriginal(originalURL string) (repository.Record, error) {
+	return c.getByOriginal(originalURL, false)
+}
+
+// getByOriginal возвращает запись по оригинальному URL. count - учитывать ли запрос в счетчиках.
+func (c *LRU) getByOriginal(originalURL string, count bool) (repository.Record, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	el, ok := c.byOriginal[originalURL]
+	if !ok {
+		if count {
+			c.misses.Add(1)
+		}
+		return repository.Record{}, ErrCacheMiss
+	}
+	if count {
+		c.hits.Add(1)
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*lruEntry).record, nil
+}
+
+// Put добавляет или заменяет запись, вытесняя давно не использованные записи, если кеш заполнен.
+func (c *LRU) Put(record repository.Record) {
+	c.put(&lruEntry{record: record})
+}
+
+// PutNotFound запоминает, что короткого id shortID нет в хранилище.
+// Запись о коротком id, уже добавленная в кеш (например, одновременным сохранением), не заменяется.
+func (c *LRU) PutNotFound(shortID string) {
+	c.put(&lruEntry{record: repository.Record{ShortID: shortID}, notFound: true, expiresAt: time.Now().Add(c.negativeTTL)})
+}
+
+// put добавляет запись в кеш.
+func (c *LRU) put(entry *lruEntry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if el, ok := c.byID[entry.record.ShortID]; ok {
+		// Отрицательная запись могла быть прочитана до сохранения записи и не должна ее заменять
+		if entry.notFound && !el.Value.(*lruEntry).notFound {
+			return
+		}
+		c.remove(el)
+	}
+	el := c.order.PushFront(entry)
+	c.byID[entry.record.ShortID] = el
+	// Удаленная запись остается в кеше для ответа по короткому id, но не заменяет действующую запись URL
+	if entry.live() {
+		c.byOriginal[entry.record.OriginalURL] = el
+	}
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+	}
+}
+
+// Remove удаляет запись с коротким id shortID из кеша.
+func (c *LRU) Remove(shortID string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if el, ok := c.byID[shortID]; ok {
+		c.remove(el)
+	}
+}
+
+// Clear удаляет из кеша все записи. Счетчики запросов сохраняются.
+func (c *LRU) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.order.Init()
+	clear(c.byID)
+	clear(c.byOriginal)
+}
+
+// RemoveExpired удаляет из кеша записи, срок действия которых истек к моменту now.
+// Возвращает количество удаленных записей.
+func (c *LRU) RemoveExpired(now time.Time) (n int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		if entry := el.Value.(*lruEntry); !entry.notFound && entry.record.IsExpired(now) {
+			c.remove(el)
+			n++
+		}
+		el = next
+	}
+	return n
+}
+
+// remove удаляет элемент из кеша. Вызывается под мьютексом.
+func (c *LRU) remove(el *list.Element) {
+	entry := c.order.Remove(el).(*lruEntry)
+	delete(c.byID, entry.record.ShortID)
+	if c.byOriginal[entry.record.OriginalURL] == el {
+		delete(c.byOriginal, entry.record.OriginalURL)
+	}
+}
+
+// Stats возвращает счетчики кеша.
+func (c *LRU) Stats() CacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return CacheStats{
+		Hits:     c.hits.Load(),
+		Misses:   c.misses.Load(),
+		Size:     c.order.Len(),
+		Capacity: c.capacity,
+	}
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+)
+
+func TestLRU(t *testing.T) {
+	c := NewLRU(2, time.Hour)
+
+	_, err := c.Get("AAAA")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+
+	c.Put(repository.Record{ShortID: "AAAA", OriginalURL: "https://a.com"})
+	c.Put(repository.Record{ShortID: "BBBB", OriginalURL: "https://b.com"})
+	record, err := c.Get("AAAA")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://a.com", record.OriginalURL)
+	record, err = c.GetByOriginal("https://b.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "BBBB", record.ShortID)
+
+	// Вытесняется давно не использованная запись AAAA
+	c.Get("BBBB")
+	c.Put(repository.Record{ShortID: "CCCC", OriginalURL: "https://c.com"})
+	_, err = c.Get("AAAA")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+	_, err = c.GetByOriginal("https://a.com")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+	_, err = c.Get("BBBB")
+	assert.NoError(t, err)
+
+	// Отрицательная запись
+	c.PutNotFound("DDDD")
+	_, err = c.Get("DDDD")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	// Сохранение записи заменяет отрицательную
+	c.Put(repository.Record{ShortID: "DDDD", OriginalURL: "https://d.com"})
+	record, err = c.Get("DDDD")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://d.com", record.OriginalURL)
+	// Отрицательная запись, прочитанная до сохранения, не заменяет запись
+	c.PutNotFound("DDDD")
+	record, err = c.Get("DDDD")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://d.com", record.OriginalURL)
+
+	// Проверка перед сохранением не учитывается в счетчиках
+	_, err = c.PeekByOriginal("https://e.com")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+	record, err = c.PeekByOriginal("https://d.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "DDDD", record.ShortID)
+
+	c.Remove("DDDD")
+	_, err = c.Get("DDDD")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+
+	stats := c.Stats()
+	assert.Equal(t, int64(7), stats.Hits)
+	assert.Equal(t, int64(4), stats.Misses)
+	assert.Equal(t, 1, stats.Size)
+	assert.Equal(t, 2, stats.Capacity)
+}
+
+func TestLRUNegativeTTL(t *testing.T) {
+	c := NewLRU(10, time.Millisecond)
+	c.PutNotFound("AAAA")
+	time.Sleep(5 * time.Millisecond)
+	_, err := c.Get("AAAA")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+	assert.Equal(t, 0, c.Stats().Size)
+}
+
+func TestLRURemoveExpired(t *testing.T) {
+	c := NewLRU(10, time.Hour)
+	now := time.Now()
+	c.Put(repository.Record{ShortID: "AAAA", OriginalURL: "https://a.com", ExpiresAt: now.Add(-time.Second)})
+	c.Put(repository.Record{ShortID: "BBBB", OriginalURL: "https://b.com", ExpiresAt: now.Add(time.Hour)})
+	c.Put(repository.Record{ShortID: "CCCC", OriginalURL: "https://c.com"})
+	c.PutNotFound("DDDD")
+
+	assert.Equal(t, 1, c.RemoveExpired(now))
+	_, err := c.Get("AAAA")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+	assert.Equal(t, 3, c.Stats().Size)
+
+	c.Clear()
+	assert.Equal(t, 0, c.Stats().Size)
+	_, err = c.GetByOriginal("https://b.com")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+}
+
+func TestLRUDeleted(t *testing.T) {
+	c := NewLRU(10, time.Hour)
+	c.Put(repository.Record{ShortID: "AAAA", OriginalURL: "https://a.com", IsDeleted: true})
+	c.Put(repository.Record{ShortID: "BBBB", OriginalURL: "https://a.com"})
+
+	// Удаленная запись отвечает по короткому id, но не по оригинальному URL
+	record, err := c.Get("AAAA")
+	assert.NoError(t, err)
+	assert.True(t, record.IsDeleted)
+	record, err = c.GetByOriginal("https://a.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "BBBB", record.ShortID)
+
+	// Повторно прочитанная удаленная запись не отнимает URL у действующей
+	c.Put(repository.Record{ShortID: "AAAA", OriginalURL: "https://a.com", IsDeleted: true})
+	record, err = c.GetByOriginal("https://a.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "BBBB", record.ShortID)
+
+	// Удаление действующей записи из кеша освобождает URL
+	c.Remove("BBBB")
+	_, err = c.GetByOriginal("https://a.com")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+	_, err = c.Get("AAAA")
+	assert.NoError(t, err)
+}
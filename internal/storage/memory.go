@@ -0,0 +1,283 @@
+// Description: Хранилище коротких URL в памяти, реализующее repository.Repository.
+// Используется самостоятельно, если постоянное хранилище не задано,
+// а также как индекс в памяти для файлового хранилища и кеш для базы данных.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/vadim-ivlev/url-shortener/internal/analytics"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+)
+
+// Memory - хранилище коротких URL в памяти.
+type Memory struct {
+	dm       *DoubleMap
+	clicks   *analytics.Aggregator
+	sequence atomic.Uint64
+}
+
+// NewMemory создает пустое хранилище в памяти.
+func NewMemory() *Memory {
+	return &Memory{
+		dm:     New(),
+		clicks: analytics.NewAggregator(),
+	}
+}
+
+// Map возвращает карту, в которой хранятся записи.
+func (m *Memory) Map() *DoubleMap {
+	return m.dm
+}
+
+// Load добавляет запись, прочитанную из постоянного хранилища, без каких-либо проверок.
+// Ранее загруженная запись с тем же коротким id заменяется.
+// Неудаленная запись становится действующей записью своего оригинального URL,
+// а удаленная хранится только для ответа на запрос по короткому id.
+func (m *Memory) Load(record repository.Record) {
+	m.dm.Put(record.ShortID, record.OriginalURL, record.IsDeleted)
+	m.dm.SetOwner(record.ShortID, record.UserID)
+	m.dm.SetExpiry(record.ShortID, record.ExpiresAt)
+}
+
+// Reload заменяет все записи в памяти записями records, прочитанными из постоянного хранилища.
+// Записи загружаются в новую карту, которая затем подменяет прежнюю,
+// поэтому чтение не видит частично загруженного состояния. Статистика переходов и счетчик не изменяются.
+func (m *Memory) Reload(records []repository.Record) {
+	fresh := NewMemory()
+	for _, record := range records {
+		fresh.Load(record)
+	}
+	m.dm.Replace(fresh.dm)
+}
+
+// Remove удаляет запись без пометки об удалении.
+// Используется для отката записи, если ее не удалось сохранить в постоянном хранилище.
+func (m *Memory) Remove(shortID string) {
+	m.dm.Delete(shortID)
+}
+
+// record собирает запись по короткому id.
+func (m *Memory) record(shortID string) repository.Record {
+	return repository.Record{
+		ShortID:     shortID,
+		OriginalURL: m.dm.Get(shortID),
+		UserID:      m.dm.GetOwner(shortID),
+		IsDeleted:   m.dm.IsDeleted(shortID),
+		ExpiresAt:   m.dm.GetExpiry(shortID),
+	}
+}
+
+// Save сохраняет запись в памяти.
+// Удаленная или просроченная запись с тем же оригинальным URL не мешает сохранению:
+// она остается удаленной или просроченной, а URL сохраняется под новым коротким id.
+// Короткий id удаленной или очищенной просроченной записи повторно не выдается.
+func (m *Memory) Save(ctx context.Context, record repository.Record) (saved repository.Record, isNew bool, err error) {
+	savedID, isNew, err := m.dm.SetUnique(record.ShortID, record.OriginalURL)
+	if errors.Is(err, ErrKeyCollision) {
+		return repository.Record{}, false, repository.ErrShortIDTaken
+	}
+	if !isNew {
+		return m.record(savedID), false, nil
+	}
+	m.dm.SetOwner(savedID, record.UserID)
+	m.dm.SetExpiry(savedID, record.ExpiresAt)
+	return m.record(savedID), true, nil
+}
+
+// SaveBatch сохраняет пачку записей в памяти.
+func (m *Memory) SaveBatch(ctx context.Context, records []repository.Record) (results []repository.SaveResult, err error) {
+	results = make([]repository.SaveResult, 0, len(records))
+	for _, record := range records {
+		saved, isNew, err := m.Save(ctx, record)
+		results = append(results, repository.SaveResult{Record: saved, IsNew: isNew, Err: err})
+	}
+	return results, nil
+}
+
+// PlanBatch определяет результат сохранения каждой записи пачки, не изменяя хранилище.
+// Результаты такие же, как при последовательном сохранении записей методом Save.
+// Используется постоянными хранилищами, которые добавляют записи в память (методом Load)
+// только после того, как пачка записана.
+func (m *Memory) PlanBatch(records []repository.Record) []repository.SaveResult {
+	results := make([]repository.SaveResult, 0, len(records))
+	// Новые записи пачки по оригинальным URL и их короткие id
+	batch := make(map[string]repository.Record, len(records))
+	batchIDs := make(map[string]bool, len(records))
+	for _, record := range records {
+		if shortID := m.dm.GetKey(record.OriginalURL); shortID != "" && !m.dm.IsExpired(shortID) {
+			results = append(results, repository.SaveResult{Record: m.record(shortID)})
+			continue
+		}
+		if saved, ok := batch[record.OriginalURL]; ok {
+			results = append(results, repository.SaveResult{Record: saved})
+			continue
+		}
+		if m.dm.Get(record.ShortID) != "" || m.dm.IsDeleted(record.ShortID) || batchIDs[record.ShortID] {
+			results = append(results, repository.SaveResult{Err: repository.ErrShortIDTaken})
+			continue
+		}
+		batch[record.OriginalURL] = record
+		batchIDs[record.ShortID] = true
+		results = append(results, repository.SaveResult{Record: record, IsNew: true})
+	}
+	return results
+}
+
+// Get возвращает запись по короткому id.
+// Для записи, удаленной по истечении срока действия, возвращается только короткий id и пометка об удалении.
+func (m *Memory) Get(ctx context.Context, shortID string) (repository.Record, error) {
+	if m.dm.Get(shortID) == "" && !m.dm.IsDeleted(shortID) {
+		return repository.Record{}, repository.ErrNotFound
+	}
+	return m.record(shortID), nil
+}
+
+// GetByOriginal возвращает действующую (неудаленную) запись по оригинальному URL.
+func (m *Memory) GetByOriginal(ctx context.Context, originalURL string) (repository.Record, error) {
+	shortID := m.dm.GetKey(originalURL)
+	if shortID == "" {
+		return repository.Record{}, repository.ErrNotFound
+	}
+	return m.record(shortID), nil
+}
+
+// ListByUser возвращает страницу записей пользователя.
+func (m *Memory) ListByUser(ctx context.Context, userID, afterShortID string, limit int) ([]repository.Record, error) {
+	keys := m.dm.KeysByUser(userID)
+	sort.Strings(keys)
+	// Пропускаем ключи до курсора включительно
+	start := sort.SearchStrings(keys, afterShortID)
+	if start < len(keys) && keys[start] == afterShortID {
+		start++
+	}
+
+	now := time.Now()
+	records := make([]repository.Record, 0, min(limit, len(keys)-start))
+	for _, key := range keys[start:] {
+		if len(records) == limit {
+			break
+		}
+		record := m.record(key)
+		if record.IsDeleted || record.IsExpired(now) {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// Delete помечает удаленными записи пользователя.
+func (m *Memory) Delete(ctx context.Context, userID string, shortIDs []string) error {
+	m.MarkDeleted(userID, shortIDs)
+	return nil
+}
+
+// MarkDeleted помечает удаленными неудаленные записи пользователя userID с указанными короткими id.
+// Возвращает короткие id помеченных записей.
+func (m *Memory) MarkDeleted(userID string, shortIDs []string) (deleted []string) {
+	deleted = m.Deletable(userID, shortIDs)
+	for _, shortID := range deleted {
+		m.dm.MarkDeleted(shortID)
+	}
+	return deleted
+}
+
+// Deletable возвращает короткие id неудаленных записей пользователя userID из shortIDs, не изменяя хранилище.
+// Используется постоянными хранилищами, которые помечают записи в памяти
+// только после того, как удаление записано.
+func (m *Memory) Deletable(userID string, shortIDs []string) []string {
+	deletable := make([]string, 0, len(shortIDs))
+	for _, shortID := range shortIDs {
+		if m.dm.GetOwner(shortID) == userID && !m.dm.IsDeleted(shortID) {
+			deletable = append(deletable, shortID)
+		}
+	}
+	return deletable
+}
+
+// DeleteExpired удаляет из памяти записи с истекшим сроком действия.
+func (m *Memory) DeleteExpired(ctx context.Context, now time.Time) (n int, err error) {
+	return len(m.PurgeExpired(now)), nil
+}
+
+// PurgeExpired удаляет из памяти записи с истекшим к моменту now сроком действия,
+// оставляя пометку об удалении. Возвращает короткие id удаленных записей.
+func (m *Memory) PurgeExpired(now time.Time) (purged []string) {
+	purged = m.Expired(now)
+	m.Purge(purged)
+	return purged
+}
+
+// Expired возвращает короткие id записей с истекшим к моменту now сроком действия, не изменяя хранилище.
+// Используется постоянными хранилищами, которые очищают записи в памяти
+// только после того, как удаление записано.
+func (m *Memory) Expired(now time.Time) []string {
+	return m.dm.ExpiredKeys(now)
+}
+
+// Purge удаляет из памяти записи с указанными короткими id, оставляя пометку об удалении.
+func (m *Memory) Purge(shortIDs []string) {
+	for _, shortID := range shortIDs {
+		m.dm.Purge(shortID)
+	}
+}
+
+// Export перечисляет неудаленные и непросроченные записи в порядке коротких id.
+func (m *Memory) Export(ctx context.Context, fn func(repository.Record) error) error {
+	now := time.Now()
+	for _, shortID := range m.dm.Keys() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		record := m.record(shortID)
+		if record.IsDeleted || record.IsExpired(now) {
+			continue
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ping всегда успешен для хранилища в памяти.
+func (m *Memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close ничего не делает для хранилища в памяти.
+func (m *Memory) Close() error {
+	return nil
+}
+
+// SaveClicks учитывает переходы в статистике в памяти.
+func (m *Memory) SaveClicks(ctx context.Context, clicks []analytics.Click) error {
+	m.clicks.Add(clicks...)
+	return nil
+}
+
+// ClickStats возвращает статистику переходов из памяти.
+func (m *Memory) ClickStats(ctx context.Context, shortID string) (analytics.Stats, error) {
+	return m.clicks.Get(shortID), nil
+}
+
+// NextSequence возвращает следующее значение счетчика в памяти.
+func (m *Memory) NextSequence(ctx context.Context) (uint64, error) {
+	return m.sequence.Add(1), nil
+}
+
+// AdvanceSequence устанавливает счетчик в памяти так, чтобы следующее значение было больше n.
+func (m *Memory) AdvanceSequence(ctx context.Context, n uint64) error {
+	for {
+		current := m.sequence.Load()
+		if current >= n || m.sequence.CompareAndSwap(current, n) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vadim-ivlev/url-shortener/internal/repository"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	// Новая запись
+	saved, isNew, err := m.Save(ctx, repository.Record{ShortID: "AAAA", OriginalURL: "https://a.com", UserID: "user-1"})
+	assert.NoError(t, err)
+	assert.True(t, isNew)
+	assert.Equal(t, "AAAA", saved.ShortID)
+
+	// Тот же URL возвращает существующую запись
+	saved, isNew, err = m.Save(ctx, repository.Record{ShortID: "BBBB", OriginalURL: "https://a.com", UserID: "user-2"})
+	assert.NoError(t, err)
+	assert.False(t, isNew)
+	assert.Equal(t, "AAAA", saved.ShortID)
+	assert.Equal(t, "user-1", saved.UserID)
+
+	// Занятый короткий id
+	_, _, err = m.Save(ctx, repository.Record{ShortID: "AAAA", OriginalURL: "https://b.com"})
+	assert.ErrorIs(t, err, repository.ErrShortIDTaken)
+
+	_, err = m.Get(ctx, "CCCC")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	record, err := m.GetByOriginal(ctx, "https://a.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "AAAA", record.ShortID)
+
+	m.Save(ctx, repository.Record{ShortID: "CCCC", OriginalURL: "https://c.com", UserID: "user-1"})
+	m.Save(ctx, repository.Record{ShortID: "DDDD", OriginalURL: "https://d.com", UserID: "user-1", ExpiresAt: time.Now().Add(-time.Second)})
+
+	// Просроченные записи в список не попадают, страница начинается после курсора
+	records, err := m.ListByUser(ctx, "user-1", "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	records, err = m.ListByUser(ctx, "user-1", "AAAA", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []repository.Record{{ShortID: "CCCC", OriginalURL: "https://c.com", UserID: "user-1"}}, records)
+
+	// Чужие записи не удаляются
+	assert.NoError(t, m.Delete(ctx, "user-2", []string{"AAAA"}))
+	record, _ = m.Get(ctx, "AAAA")
+	assert.False(t, record.IsDeleted)
+	assert.NoError(t, m.Delete(ctx, "user-1", []string{"AAAA"}))
+	record, _ = m.Get(ctx, "AAAA")
+	assert.True(t, record.IsDeleted)
+
+	// Удаленная запись остается удаленной, а URL получает новый короткий id нового владельца
+	saved, isNew, err = m.Save(ctx, repository.Record{ShortID: "EEEE", OriginalURL: "https://a.com", UserID: "user-2"})
+	assert.NoError(t, err)
+	assert.True(t, isNew)
+	assert.Equal(t, repository.Record{ShortID: "EEEE", OriginalURL: "https://a.com", UserID: "user-2"}, saved)
+	record, _ = m.Get(ctx, "AAAA")
+	assert.Equal(t, repository.Record{ShortID: "AAAA", OriginalURL: "https://a.com", UserID: "user-1", IsDeleted: true}, record)
+	record, err = m.GetByOriginal(ctx, "https://a.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "EEEE", record.ShortID)
+	// Короткий id удаленной записи не достается другому URL
+	_, _, err = m.Save(ctx, repository.Record{ShortID: "AAAA", OriginalURL: "https://e.com"})
+	assert.ErrorIs(t, err, repository.ErrShortIDTaken)
+
+	// Пачка планируется так же
+	assert.NoError(t, m.Delete(ctx, "user-2", []string{"EEEE"}))
+	plan := m.PlanBatch([]repository.Record{{ShortID: "FFFF", OriginalURL: "https://a.com"}, {ShortID: "GGGG", OriginalURL: "https://a.com"}, {ShortID: "EEEE", OriginalURL: "https://e.com"}})
+	assert.Equal(t, []repository.SaveResult{
+		{Record: repository.Record{ShortID: "FFFF", OriginalURL: "https://a.com"}, IsNew: true},
+		{Record: repository.Record{ShortID: "FFFF", OriginalURL: "https://a.com"}},
+		{Err: repository.ErrShortIDTaken},
+	}, plan)
+
+	// Загрузка удаленной записи не отнимает URL у действующей
+	m.Load(repository.Record{ShortID: "HHHH", OriginalURL: "https://c.com", IsDeleted: true})
+	record, _ = m.GetByOriginal(ctx, "https://c.com")
+	assert.Equal(t, "CCCC", record.ShortID)
+
+	// URL просроченной записи сохраняется под новым коротким id, а просроченная запись не продлевается
+	plan = m.PlanBatch([]repository.Record{{ShortID: "IIII", OriginalURL: "https://d.com"}})
+	assert.Equal(t, []repository.SaveResult{{Record: repository.Record{ShortID: "IIII", OriginalURL: "https://d.com"}, IsNew: true}}, plan)
+	saved, isNew, err = m.Save(ctx, repository.Record{ShortID: "IIII", OriginalURL: "https://d.com", UserID: "user-2"})
+	assert.NoError(t, err)
+	assert.True(t, isNew)
+	assert.Equal(t, "IIII", saved.ShortID)
+	record, _ = m.Get(ctx, "DDDD")
+	assert.True(t, record.IsExpired(time.Now()))
+	assert.Equal(t, "user-1", record.UserID)
+
+	// Просроченная запись удаляется, но остается помеченной
+	n, err := m.DeleteExpired(ctx, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	record, err = m.Get(ctx, "DDDD")
+	assert.NoError(t, err)
+	assert.True(t, record.IsDeleted)
+	assert.Equal(t, "", record.OriginalURL)
+	record, _ = m.GetByOriginal(ctx, "https://d.com")
+	assert.Equal(t, "IIII", record.ShortID)
+	// Короткий id очищенной записи повторно не выдается
+	_, _, err = m.Save(ctx, repository.Record{ShortID: "DDDD", OriginalURL: "https://dd.com"})
+	assert.ErrorIs(t, err, repository.ErrShortIDTaken)
+}
+
+func TestMemoryExport(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	_, _, err := m.Save(ctx, repository.Record{ShortID: "BBBB", OriginalURL: "https://b.com", UserID: "user-1"})
+	assert.NoError(t, err)
+	_, _, err = m.Save(ctx, repository.Record{ShortID: "AAAA", OriginalURL: "https://a.com"})
+	assert.NoError(t, err)
+	_, _, err = m.Save(ctx, repository.Record{ShortID: "CCCC", OriginalURL: "https://c.com", UserID: "user-1"})
+	assert.NoError(t, err)
+	_, _, err = m.Save(ctx, repository.Record{ShortID: "DDDD", OriginalURL: "https://d.com", ExpiresAt: time.Now().Add(-time.Minute)})
+	assert.NoError(t, err)
+	assert.NoError(t, m.Delete(ctx, "user-1", []string{"CCCC"}))
+
+	// Удаленные и просроченные записи не перечисляются, остальные - в порядке коротких id
+	var shortIDs []string
+	assert.NoError(t, m.Export(ctx, func(record repository.Record) error {
+		shortIDs = append(shortIDs, record.ShortID)
+		return nil
+	}))
+	assert.Equal(t, []string{"AAAA", "BBBB"}, shortIDs)
+}
+
+func TestMemoryReload(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	_, _, err := m.Save(ctx, repository.Record{ShortID: "AAAA", OriginalURL: "https://a.com", UserID: "user-1"})
+	assert.NoError(t, err)
+	_, _, err = m.Save(ctx, repository.Record{ShortID: "BBBB", OriginalURL: "https://b.com", UserID: "user-1"})
+	assert.NoError(t, err)
+
+	// Записи, которых нет среди загруженных, не остаются в памяти
+	m.Reload([]repository.Record{
+		{ShortID: "AAAA", OriginalURL: "https://a.com", UserID: "user-1", IsDeleted: true},
+		{ShortID: "CCCC", OriginalURL: "https://c.com", UserID: "user-2"},
+	})
+	_, err = m.Get(ctx, "BBBB")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	_, err = m.GetByOriginal(ctx, "https://b.com")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	record, err := m.Get(ctx, "AAAA")
+	assert.NoError(t, err)
+	assert.True(t, record.IsDeleted)
+	assert.Equal(t, []string{"AAAA"}, m.Map().KeysByUser("user-1"))
+	record, err = m.GetByOriginal(ctx, "https://c.com")
+	assert.NoError(t, err)
+	assert.Equal(t, repository.Record{ShortID: "CCCC", OriginalURL: "https://c.com", UserID: "user-2"}, record)
+}
@@ -1,79 +1,290 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/rs/zerolog/log"
 )
 
-// dm —  экземпляр DoubleMap.
-var dm *DoubleMap
+// ErrKeyCollision - ошибка, возвращаемая при попытке сохранить значение под ключом,
+// который уже занят другим значением.
+var ErrKeyCollision = errors.New("key is already taken by another value")
 
 // DoubleMap - двухсторонняя карта для хранения отображения между оригинальными значениями и их укороченными ключами.
-// valueToKey — это карта для хранения отображения от оригинальных значений к их укороченным ключам.
-// keyToValue — это карта для хранения отображения от укороченных ключей к их оригинальным значениям.
+// valueToKey — это карта для хранения отображения от оригинальных значений к их действующим (неудаленным) укороченным ключам.
+// keyToValue — это карта для хранения отображения от укороченных ключей, в том числе удаленных, к их оригинальным значениям.
+// keyToUser — это карта для хранения отображения от укороченных ключей к идентификаторам их владельцев.
+// userToKeys — это карта для хранения списка укороченных ключей пользователя в порядке их добавления.
+// deletedKeys — это множество укороченных ключей, удаленных их владельцами или по истечении срока действия.
+// keyToExpiry — это карта для хранения сроков действия укороченных ключей. Ключи без срока в карте отсутствуют.
 // mu — это мьютекс для обеспечения потокобезопасных операций с картами.
 // Эта реализация должна обеспечивать временную сложность O(1) для  операций Set и Get.
 type DoubleMap struct {
-	valueToKey map[string]string
-	keyToValue map[string]string
-	mutex      sync.Mutex
+	valueToKey  map[string]string
+	keyToValue  map[string]string
+	keyToUser   map[string]string
+	userToKeys  map[string][]string
+	deletedKeys map[string]bool
+	keyToExpiry map[string]time.Time
+	mutex       sync.Mutex
 }
 
-// Create порождает новый экземпляр DoubleMap.
-func Create() {
-	// Не был ли DoubleMap уже создан
-	if dm != nil {
-		return
-	}
-	dm = &DoubleMap{
-		valueToKey: make(map[string]string),
-		keyToValue: make(map[string]string),
-	}
-	log.Info().Msg("storage initialized")
-}
-
-// Clear очищает хранилище.
-func Clear() {
-	dm = &DoubleMap{
-		valueToKey: make(map[string]string),
-		keyToValue: make(map[string]string),
+// New возвращает пустой экземпляр DoubleMap.
+func New() *DoubleMap {
+	return &DoubleMap{
+		valueToKey:  make(map[string]string),
+		keyToValue:  make(map[string]string),
+		keyToUser:   make(map[string]string),
+		userToKeys:  make(map[string][]string),
+		deletedKeys: make(map[string]bool),
+		keyToExpiry: make(map[string]time.Time),
 	}
-	log.Info().Msg("storage cleared")
 }
 
 // Set сохраняет ключ и значени в DoubleMap.
 // Сначала проверяется, существует ли значение уже в карте valueToKey. Если да, то возвращается существующий ключ.
 // Если значение не существует, оно сохраняется с новым ключом и возвращается новый ключ.
 // Новые отображения добавляются в обе карты.
+// Если ключ уже занят другим значением, то запись не производится, а коллизия пишется в лог.
 // Возвращает ключ и флаг, указывающий, было ли новое значение добавлено в карту.
-func Set(key, value string) (savedKey string, newKeyAdded bool) {
+func (dm *DoubleMap) Set(key, value string) (savedKey string, newKeyAdded bool) {
+	savedKey, newKeyAdded, err := dm.SetUnique(key, value)
+	if err != nil {
+		log.Warn().Err(err).Str("key", key).Str("value", value).Msg("Set(). Key collision")
+	}
+	return savedKey, newKeyAdded
+}
+
+// SetUnique сохраняет ключ и значение в DoubleMap так же, как Set,
+// но не перезаписывает ключ, уже занятый другим значением.
+// В этом случае возвращается ошибка ErrKeyCollision и пустой ключ.
+// Удаленный ключ, в том числе очищенный методом Purge, тоже считается занятым и повторно не выдается.
+// Если срок действия ключа значения истек, то значение сохраняется под новым ключом,
+// а прежний ключ остается просроченным.
+// Возвращает ключ, флаг, указывающий, было ли новое значение добавлено в карту, и ошибку.
+func (dm *DoubleMap) SetUnique(key, value string) (savedKey string, newKeyAdded bool, err error) {
 	dm.mutex.Lock()
 	defer dm.mutex.Unlock()
 
-	// Проверяем, существует ли уже укороченное значение
-	if existingKey, exists := dm.valueToKey[value]; exists {
-		return existingKey, false
+	// Проверяем, существует ли уже действующее укороченное значение.
+	// Ключ с истекшим сроком действия остается за значением, но перестает быть его действующим ключом.
+	existingKey, exists := dm.valueToKey[value]
+	if exists && !dm.expired(existingKey, time.Now()) {
+		return existingKey, false, nil
+	}
+
+	// Проверяем, не занят ли ключ другим значением или удаленной записью
+	if _, exists := dm.keyToValue[key]; exists || dm.deletedKeys[key] {
+		return "", false, ErrKeyCollision
 	}
 
 	// Сохраняем новое значение и ключ в обе карты
 	dm.valueToKey[value] = key
 	dm.keyToValue[key] = value
 
-	return key, true
+	return key, true, nil
+}
+
+// Put сохраняет ключ и значение, прочитанные из постоянного хранилища, заменяя прежнее значение ключа.
+// Если deleted == false, то ключ становится действующим ключом значения, иначе ключ помечается как удаленный.
+// Используется для загрузки записей, среди которых у одного значения может быть несколько удаленных ключей.
+func (dm *DoubleMap) Put(key, value string, deleted bool) {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
+	if oldValue, exists := dm.keyToValue[key]; exists && oldValue != value {
+		dm.unlinkValue(oldValue, key)
+	}
+	dm.keyToValue[key] = value
+	if deleted {
+		dm.deletedKeys[key] = true
+		dm.unlinkValue(value, key)
+		return
+	}
+	delete(dm.deletedKeys, key)
+	dm.valueToKey[value] = key
+}
+
+// unlinkValue удаляет отображение значения value в ключ key, если значение отображается именно в него.
+// Вызывается под мьютексом.
+func (dm *DoubleMap) unlinkValue(value, key string) {
+	if dm.valueToKey[value] == key {
+		delete(dm.valueToKey, value)
+	}
+}
+
+// Replace заменяет содержимое DoubleMap содержимым other. После вызова other не должен использоваться.
+func (dm *DoubleMap) Replace(other *DoubleMap) {
+	other.mutex.Lock()
+	defer other.mutex.Unlock()
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
+	dm.valueToKey = other.valueToKey
+	dm.keyToValue = other.keyToValue
+	dm.keyToUser = other.keyToUser
+	dm.userToKeys = other.userToKeys
+	dm.deletedKeys = other.deletedKeys
+	dm.keyToExpiry = other.keyToExpiry
+}
+
+// Delete удаляет ключ и соответствующее ему значение из обеих карт.
+// Используется для отката записи, если ее не удалось сохранить в постоянном хранилище.
+func (dm *DoubleMap) Delete(key string) {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
+	dm.remove(key)
+	delete(dm.deletedKeys, key)
+}
+
+// Purge удаляет из хранилища значение ключа, его владельца и срок действия, освобождая память,
+// но оставляет ключ помеченным как удаленный, чтобы на запрос по ключу можно было ответить, что он удален.
+// Используется для удаления записей с истекшим сроком действия.
+func (dm *DoubleMap) Purge(key string) {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
+	dm.remove(key)
+	dm.deletedKeys[key] = true
+}
+
+// remove удаляет значение ключа, его владельца и срок действия. Вызывается под мьютексом.
+func (dm *DoubleMap) remove(key string) {
+	if value, exists := dm.keyToValue[key]; exists {
+		dm.unlinkValue(value, key)
+		delete(dm.keyToValue, key)
+	}
+	if userID, exists := dm.keyToUser[key]; exists {
+		delete(dm.keyToUser, key)
+		dm.userToKeys[userID] = removeKey(dm.userToKeys[userID], key)
+	}
+	delete(dm.keyToExpiry, key)
+}
+
+// SetExpiry сохраняет срок действия ключа. Нулевое значение expiresAt означает бессрочный ключ.
+func (dm *DoubleMap) SetExpiry(key string, expiresAt time.Time) {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
+	if expiresAt.IsZero() {
+		delete(dm.keyToExpiry, key)
+		return
+	}
+	dm.keyToExpiry[key] = expiresAt
+}
+
+// IsExpired возвращает true, если срок действия ключа истек.
+func (dm *DoubleMap) IsExpired(key string) bool {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
+	return dm.expired(key, time.Now())
+}
+
+// expired возвращает true, если срок действия ключа истек к моменту now. Вызывается под мьютексом.
+func (dm *DoubleMap) expired(key string, now time.Time) bool {
+	expiresAt, exists := dm.keyToExpiry[key]
+	return exists && !now.Before(expiresAt)
+}
+
+// ExpiredKeys возвращает ключи, срок действия которых истек к моменту now.
+func (dm *DoubleMap) ExpiredKeys(now time.Time) []string {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
+	keys := make([]string, 0)
+	for key, expiresAt := range dm.keyToExpiry {
+		if !now.Before(expiresAt) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// MarkDeleted помечает ключ как удаленный владельцем.
+// Запись остается в хранилище, чтобы на запрос по ключу можно было ответить, что он удален,
+// но перестает быть действующим ключом своего значения: повторно сохраненное значение получает новый ключ.
+func (dm *DoubleMap) MarkDeleted(key string) {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
+	if value, exists := dm.keyToValue[key]; exists {
+		dm.deletedKeys[key] = true
+		dm.unlinkValue(value, key)
+	}
+}
+
+// IsDeleted возвращает true, если ключ помечен как удаленный.
+func (dm *DoubleMap) IsDeleted(key string) bool {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
+	return dm.deletedKeys[key]
+}
+
+// removeKey удаляет key из среза keys, сохраняя порядок остальных элементов.
+func removeKey(keys []string, key string) []string {
+	for i, k := range keys {
+		if k == key {
+			return append(keys[:i], keys[i+1:]...)
+		}
+	}
+	return keys
+}
+
+// SetOwner сохраняет идентификатор владельца userID для ключа key.
+// Пустой userID игнорируется. Повторный вызов для того же ключа заменяет владельца.
+func (dm *DoubleMap) SetOwner(key, userID string) {
+	if userID == "" {
+		return
+	}
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
+	if oldUserID, exists := dm.keyToUser[key]; exists {
+		if oldUserID == userID {
+			return
+		}
+		dm.userToKeys[oldUserID] = removeKey(dm.userToKeys[oldUserID], key)
+	}
+	dm.keyToUser[key] = userID
+	dm.userToKeys[userID] = append(dm.userToKeys[userID], key)
+}
+
+// GetOwner возвращает идентификатор владельца ключа.
+// Если у ключа нет владельца, возвращается пустая строка.
+func (dm *DoubleMap) GetOwner(key string) (userID string) {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
+	return dm.keyToUser[key]
+}
+
+// KeysByUser возвращает копию списка ключей пользователя в порядке их добавления.
+func (dm *DoubleMap) KeysByUser(userID string) []string {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
+	keys := make([]string, len(dm.userToKeys[userID]))
+	copy(keys, dm.userToKeys[userID])
+	return keys
 }
 
 // LoadData - загружает данные из map[string]string, где ключ - short_id, значение - original_url, в storage.
-func LoadData(data map[string]string) {
+func (dm *DoubleMap) LoadData(data map[string]string) {
 	for shortID, originalURL := range data {
-		Set(shortID, originalURL)
+		dm.Set(shortID, originalURL)
 	}
 }
 
 // Get возвращает значение для данного ключа.
 // Если ключ не найден, возвращается пустая строка.
-func Get(key string) (value string) {
+func (dm *DoubleMap) Get(key string) (value string) {
 	dm.mutex.Lock()
 	defer dm.mutex.Unlock()
 
@@ -82,9 +293,50 @@ func Get(key string) (value string) {
 	return value
 }
 
+// GetKey возвращает ключ для данного значения.
+// Если значение не найдено, возвращается пустая строка.
+func (dm *DoubleMap) GetKey(value string) (key string) {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
+	return dm.valueToKey[value]
+}
+
+// GetExpiry возвращает срок действия ключа. Нулевое значение означает бессрочный ключ.
+func (dm *DoubleMap) GetExpiry(key string) time.Time {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
+	return dm.keyToExpiry[key]
+}
+
+// Keys возвращает упорядоченный список ключей, у которых есть значение.
+func (dm *DoubleMap) Keys() []string {
+	dm.mutex.Lock()
+	keys := make([]string, 0, len(dm.keyToValue))
+	for key := range dm.keyToValue {
+		keys = append(keys, key)
+	}
+	dm.mutex.Unlock()
+
+	sort.Strings(keys)
+	return keys
+}
+
+// Len возвращает количество записей в хранилище.
+func (dm *DoubleMap) Len() int {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
+	return len(dm.keyToValue)
+}
+
 // PrintContent выводит содержимое хранилища в консоль.
 // limit - количество элементов, которые будут выведены.
-func PrintContent(limit int) {
+func (dm *DoubleMap) PrintContent(limit int) {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
 	log.Info().Msgf("RAM Storage contains %d records", len(dm.keyToValue))
 	n := 0
 	for k, v := range dm.keyToValue {
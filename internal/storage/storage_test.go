@@ -4,12 +4,16 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// dm - карта, с которой работают тесты. Тесты, которым нужна пустая карта, создают ее заново.
+var dm *DoubleMap
+
 func TestMain(m *testing.M) {
-	Create()
+	dm = New()
 	os.Exit(m.Run())
 }
 
@@ -58,7 +62,7 @@ var tests = []struct {
 func TestSet(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, _ := Set(tt.args.key, tt.args.value)
+			got, _ := dm.Set(tt.args.key, tt.args.value)
 			assert.Equal(t, tt.want, got)
 			fmt.Printf("Name = %v, Key = %v, Value = %v, Want = %v, Got = %v\n", tt.name, tt.args.key, tt.args.value, tt.want, got)
 		})
@@ -69,11 +73,166 @@ func TestGet(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 
-			got, _ := Set(tt.args.key, tt.args.value)
+			got, _ := dm.Set(tt.args.key, tt.args.value)
 			assert.Equal(t, tt.want, got)
 
-			gotValue := Get(tt.want)
+			gotValue := dm.Get(tt.want)
 			assert.Equal(t, tt.args.value, gotValue)
 		})
 	}
 }
+
+func TestSetUnique(t *testing.T) {
+	dm = New()
+
+	// Новое значение
+	key, added, err := dm.SetUnique("AAAA", "https://a.com")
+	assert.NoError(t, err)
+	assert.True(t, added)
+	assert.Equal(t, "AAAA", key)
+
+	// То же значение возвращает существующий ключ
+	key, added, err = dm.SetUnique("BBBB", "https://a.com")
+	assert.NoError(t, err)
+	assert.False(t, added)
+	assert.Equal(t, "AAAA", key)
+
+	// Занятый ключ с другим значением - коллизия, запись не перезаписывается
+	key, added, err = dm.SetUnique("AAAA", "https://b.com")
+	assert.ErrorIs(t, err, ErrKeyCollision)
+	assert.False(t, added)
+	assert.Equal(t, "", key)
+	assert.Equal(t, "https://a.com", dm.Get("AAAA"))
+
+	// После удаления ключ снова свободен
+	dm.Delete("AAAA")
+	assert.Equal(t, "", dm.Get("AAAA"))
+	key, added, err = dm.SetUnique("AAAA", "https://b.com")
+	assert.NoError(t, err)
+	assert.True(t, added)
+	assert.Equal(t, "AAAA", key)
+}
+
+func TestOwners(t *testing.T) {
+	dm = New()
+
+	dm.Set("AAAA", "https://a.com")
+	dm.Set("BBBB", "https://b.com")
+	dm.Set("CCCC", "https://c.com")
+	dm.SetOwner("AAAA", "user-1")
+	dm.SetOwner("BBBB", "user-2")
+	dm.SetOwner("CCCC", "user-1")
+	// Пустой владелец игнорируется
+	dm.SetOwner("BBBB", "")
+
+	assert.Equal(t, "user-1", dm.GetOwner("AAAA"))
+	assert.Equal(t, "user-2", dm.GetOwner("BBBB"))
+	assert.Equal(t, []string{"AAAA", "CCCC"}, dm.KeysByUser("user-1"))
+	assert.Equal(t, []string{"BBBB"}, dm.KeysByUser("user-2"))
+	assert.Empty(t, dm.KeysByUser("user-3"))
+
+	// Удаление ключа удаляет его из списка пользователя
+	dm.Delete("AAAA")
+	assert.Equal(t, "", dm.GetOwner("AAAA"))
+	assert.Equal(t, []string{"CCCC"}, dm.KeysByUser("user-1"))
+}
+
+func TestMarkDeleted(t *testing.T) {
+	dm = New()
+
+	dm.Set("AAAA", "https://a.com")
+	assert.False(t, dm.IsDeleted("AAAA"))
+
+	dm.MarkDeleted("AAAA")
+	assert.True(t, dm.IsDeleted("AAAA"))
+	// Значение удаленного ключа сохраняется, но значение получает новый ключ
+	assert.Equal(t, "https://a.com", dm.Get("AAAA"))
+	assert.Equal(t, "", dm.GetKey("https://a.com"))
+	_, _, err := dm.SetUnique("AAAA", "https://b.com")
+	assert.ErrorIs(t, err, ErrKeyCollision)
+	key, added, err := dm.SetUnique("BBBB", "https://a.com")
+	assert.NoError(t, err)
+	assert.True(t, added)
+	assert.Equal(t, "BBBB", key)
+
+	// Несуществующий ключ не помечается
+	dm.MarkDeleted("CCCC")
+	assert.False(t, dm.IsDeleted("CCCC"))
+
+	// Delete снимает пометку и не трогает действующий ключ значения
+	dm.Delete("AAAA")
+	assert.False(t, dm.IsDeleted("AAAA"))
+	assert.Equal(t, "BBBB", dm.GetKey("https://a.com"))
+}
+
+func TestPut(t *testing.T) {
+	dm = New()
+
+	// Удаленный ключ хранит значение, но не становится ключом значения
+	dm.Put("AAAA", "https://a.com", true)
+	assert.True(t, dm.IsDeleted("AAAA"))
+	assert.Equal(t, "https://a.com", dm.Get("AAAA"))
+	assert.Equal(t, "", dm.GetKey("https://a.com"))
+
+	// Неудаленный ключ становится ключом значения, удаленный ключ не меняется
+	dm.Put("BBBB", "https://a.com", false)
+	assert.Equal(t, "BBBB", dm.GetKey("https://a.com"))
+	assert.True(t, dm.IsDeleted("AAAA"))
+	dm.Put("AAAA", "https://a.com", true)
+	assert.Equal(t, "BBBB", dm.GetKey("https://a.com"))
+
+	// Пометка об удалении действующего ключа освобождает значение
+	dm.Put("BBBB", "https://a.com", true)
+	assert.Equal(t, "", dm.GetKey("https://a.com"))
+	key, added, err := dm.SetUnique("CCCC", "https://a.com")
+	assert.NoError(t, err)
+	assert.True(t, added)
+	assert.Equal(t, "CCCC", key)
+}
+
+func TestExpiry(t *testing.T) {
+	dm = New()
+
+	dm.Set("AAAA", "https://a.com")
+	dm.Set("BBBB", "https://b.com")
+	dm.SetOwner("AAAA", "user-1")
+	assert.False(t, dm.IsExpired("AAAA"))
+
+	dm.SetExpiry("AAAA", time.Now().Add(-time.Second))
+	dm.SetExpiry("BBBB", time.Now().Add(time.Hour))
+	assert.True(t, dm.IsExpired("AAAA"))
+	assert.False(t, dm.IsExpired("BBBB"))
+	assert.Equal(t, []string{"AAAA"}, dm.ExpiredKeys(time.Now()))
+
+	// Нулевой срок снимает ограничение
+	dm.SetExpiry("BBBB", time.Time{})
+	assert.Equal(t, []string{"AAAA"}, dm.ExpiredKeys(time.Now().Add(2*time.Hour)))
+
+	// Purge освобождает запись, но оставляет пометку об удалении
+	dm.Purge("AAAA")
+	assert.Equal(t, "", dm.Get("AAAA"))
+	assert.Equal(t, "", dm.GetOwner("AAAA"))
+	assert.Empty(t, dm.KeysByUser("user-1"))
+	assert.True(t, dm.IsDeleted("AAAA"))
+	assert.False(t, dm.IsExpired("AAAA"))
+
+	// Очищенный ключ повторно не выдается
+	_, _, err := dm.SetUnique("AAAA", "https://a2.com")
+	assert.ErrorIs(t, err, ErrKeyCollision)
+	assert.True(t, dm.IsDeleted("AAAA"))
+
+	// Значение с просроченным ключом сохраняется под новым ключом, а прежний ключ остается просроченным
+	dm.Set("CCCC", "https://c.com")
+	dm.SetExpiry("CCCC", time.Now().Add(-time.Second))
+	key, added, err := dm.SetUnique("DDDD", "https://c.com")
+	assert.NoError(t, err)
+	assert.True(t, added)
+	assert.Equal(t, "DDDD", key)
+	assert.Equal(t, "DDDD", dm.GetKey("https://c.com"))
+	assert.Equal(t, "https://c.com", dm.Get("CCCC"))
+	assert.True(t, dm.IsExpired("CCCC"))
+
+	// Очистка просроченного ключа не затрагивает новый ключ значения
+	dm.Purge("CCCC")
+	assert.Equal(t, "DDDD", dm.GetKey("https://c.com"))
+}
@@ -0,0 +1,2 @@
+-- Столбцы из миграций 03-05 удаляются вместе с таблицей
+DROP TABLE IF EXISTS urls;
@@ -0,0 +1,13 @@
+
+-- urls - хранит список уникальных URL и их коротких ключей.
+-- Миграции SQLite появились, когда столбцы из миграций 03-05 уже существовали,
+-- поэтому таблица создается сразу с ними, а SQLite-версии миграций 03-05 только создают индексы.
+-- Откат столбцов не требуется: они удаляются вместе с таблицей в 01_tables.sqlite.down.sql.
+CREATE TABLE IF NOT EXISTS urls (
+    short_id TEXT PRIMARY KEY,                 -- Короткий ключ
+    original_url TEXT NOT NULL,                -- Оригинальный URL
+    user_id TEXT,                              -- Идентификатор пользователя, сократившего URL
+    is_deleted BOOLEAN NOT NULL DEFAULT FALSE, -- Признак удаления короткого URL
+    expires_at TIMESTAMP,                      -- Момент окончания действия (UTC). NULL - бессрочно.
+    UNIQUE (original_url)
+);
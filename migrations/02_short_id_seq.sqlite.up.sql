@@ -0,0 +1,7 @@
+
+-- short_id_seq - счетчик для генераторов коротких ключей counter и sqids.
+-- В SQLite нет последовательностей, поэтому счетчик хранится в таблице из одной строки.
+CREATE TABLE IF NOT EXISTS short_id_seq (
+    value INTEGER NOT NULL
+);
+INSERT INTO short_id_seq (value) SELECT 0 WHERE NOT EXISTS (SELECT 1 FROM short_id_seq);
@@ -0,0 +1,3 @@
+
+-- short_id_seq - счетчик для генераторов коротких ключей counter и sqids
+CREATE SEQUENCE IF NOT EXISTS short_id_seq;
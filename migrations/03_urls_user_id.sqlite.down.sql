@@ -0,0 +1,2 @@
+-- Столбец user_id удаляется вместе с таблицей в 01_tables.sqlite.down.sql
+DROP INDEX IF EXISTS urls_user_id_idx;
@@ -0,0 +1,3 @@
+
+-- Столбец user_id создается в 01_tables.sqlite.up.sql
+CREATE INDEX IF NOT EXISTS urls_user_id_idx ON urls (user_id);
@@ -0,0 +1,4 @@
+
+-- user_id - идентификатор пользователя, сократившего URL
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS user_id TEXT;
+CREATE INDEX IF NOT EXISTS urls_user_id_idx ON urls (user_id);
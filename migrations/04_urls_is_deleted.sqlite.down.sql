@@ -0,0 +1 @@
+-- Столбец is_deleted удаляется вместе с таблицей в 01_tables.sqlite.down.sql
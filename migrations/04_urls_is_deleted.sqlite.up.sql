@@ -0,0 +1,2 @@
+
+-- Столбец is_deleted создается в 01_tables.sqlite.up.sql
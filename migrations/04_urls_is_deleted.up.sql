@@ -0,0 +1,3 @@
+
+-- is_deleted - признак удаления короткого URL его владельцем
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS is_deleted BOOLEAN NOT NULL DEFAULT FALSE;
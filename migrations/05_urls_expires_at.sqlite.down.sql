@@ -0,0 +1,2 @@
+-- Столбец expires_at удаляется вместе с таблицей в 01_tables.sqlite.down.sql
+DROP INDEX IF EXISTS urls_expires_at_idx;
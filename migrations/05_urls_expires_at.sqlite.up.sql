@@ -0,0 +1,3 @@
+
+-- Столбец expires_at создается в 01_tables.sqlite.up.sql
+CREATE INDEX IF NOT EXISTS urls_expires_at_idx ON urls (expires_at) WHERE expires_at IS NOT NULL AND NOT is_deleted;
@@ -0,0 +1,4 @@
+
+-- expires_at - момент, после которого короткий URL перестает работать. NULL - бессрочно.
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS urls_expires_at_idx ON urls (expires_at) WHERE expires_at IS NOT NULL AND NOT is_deleted;
@@ -0,0 +1,11 @@
+
+-- clicks - хранит переходы по коротким URL
+CREATE TABLE IF NOT EXISTS clicks (
+    id BIGSERIAL PRIMARY KEY,
+    short_id TEXT NOT NULL,            -- Короткий ключ
+    clicked_at TIMESTAMPTZ NOT NULL,   -- Время перехода
+    referrer TEXT NOT NULL DEFAULT '', -- Заголовок Referer
+    user_agent TEXT NOT NULL DEFAULT '', -- Заголовок User-Agent
+    client_ip TEXT NOT NULL DEFAULT '' -- Огрубленный IP-адрес посетителя
+);
+CREATE INDEX IF NOT EXISTS clicks_short_id_idx ON clicks (short_id, clicked_at);
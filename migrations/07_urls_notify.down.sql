@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS urls_notify ON urls;
+DROP FUNCTION IF EXISTS urls_notify();
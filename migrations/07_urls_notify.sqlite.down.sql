@@ -0,0 +1 @@
+-- См. 07_urls_notify.sqlite.up.sql
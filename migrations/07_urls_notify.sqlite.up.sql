@@ -0,0 +1 @@
+-- В SQLite нет LISTEN/NOTIFY. Файл базы данных используется одним экземпляром сервиса.
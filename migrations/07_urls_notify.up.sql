@@ -0,0 +1,30 @@
+-- urls_notify - уведомляет экземпляры сервиса об изменениях таблицы urls через канал urls_changes.
+-- Уведомление содержит строку целиком. Если она не помещается в уведомление (8000 байт),
+-- то передается только короткий id, и строка читается из таблицы.
+CREATE OR REPLACE FUNCTION urls_notify() RETURNS trigger AS $$
+DECLARE
+    payload TEXT;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM pg_notify('urls_changes', json_build_object('op', TG_OP, 'short_id', OLD.short_id)::text);
+        RETURN OLD;
+    END IF;
+    payload := json_build_object(
+        'op', TG_OP,
+        'short_id', NEW.short_id,
+        'original_url', NEW.original_url,
+        'user_id', NEW.user_id,
+        'is_deleted', NEW.is_deleted,
+        'expires_at', NEW.expires_at
+    )::text;
+    IF octet_length(payload) > 7900 THEN
+        payload := json_build_object('op', TG_OP, 'short_id', NEW.short_id)::text;
+    END IF;
+    PERFORM pg_notify('urls_changes', payload);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS urls_notify ON urls;
+CREATE TRIGGER urls_notify AFTER INSERT OR UPDATE OR DELETE ON urls
+    FOR EACH ROW EXECUTE FUNCTION urls_notify();
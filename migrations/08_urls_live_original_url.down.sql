@@ -0,0 +1,7 @@
+-- Для каждого URL остается одна запись: неудаленная или, если ее нет, удаленная с наименьшим коротким id
+DELETE FROM urls u WHERE u.is_deleted AND EXISTS (
+    SELECT 1 FROM urls o
+    WHERE o.original_url = u.original_url AND (NOT o.is_deleted OR o.short_id < u.short_id)
+);
+DROP INDEX IF EXISTS urls_original_url_live_idx;
+ALTER TABLE urls ADD CONSTRAINT urls_original_url_key UNIQUE (original_url);
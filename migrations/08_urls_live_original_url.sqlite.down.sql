@@ -0,0 +1,20 @@
+-- Таблица пересоздается с ограничением UNIQUE (original_url), как в 01_tables.sqlite.up.sql.
+-- Для каждого URL остается одна запись: неудаленная или, если ее нет, удаленная с наименьшим коротким id.
+DELETE FROM urls WHERE is_deleted AND EXISTS (
+    SELECT 1 FROM urls o
+    WHERE o.original_url = urls.original_url AND (NOT o.is_deleted OR o.short_id < urls.short_id)
+);
+CREATE TABLE urls_old (
+    short_id TEXT PRIMARY KEY,                 -- Короткий ключ
+    original_url TEXT NOT NULL,                -- Оригинальный URL
+    user_id TEXT,                              -- Идентификатор пользователя, сократившего URL
+    is_deleted BOOLEAN NOT NULL DEFAULT FALSE, -- Признак удаления короткого URL
+    expires_at TIMESTAMP,                      -- Момент окончания действия (UTC). NULL - бессрочно.
+    UNIQUE (original_url)
+);
+INSERT INTO urls_old (short_id, original_url, user_id, is_deleted, expires_at)
+SELECT short_id, original_url, user_id, is_deleted, expires_at FROM urls;
+DROP TABLE urls;
+ALTER TABLE urls_old RENAME TO urls;
+CREATE INDEX IF NOT EXISTS urls_user_id_idx ON urls (user_id);
+CREATE INDEX IF NOT EXISTS urls_expires_at_idx ON urls (expires_at) WHERE expires_at IS NOT NULL AND NOT is_deleted;
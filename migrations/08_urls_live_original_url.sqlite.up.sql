@@ -0,0 +1,16 @@
+-- Оригинальный URL уникален только среди неудаленных записей (см. 08_urls_live_original_url.up.sql).
+-- В SQLite нельзя удалить ограничение UNIQUE таблицы, поэтому таблица пересоздается без него.
+CREATE TABLE urls_new (
+    short_id TEXT PRIMARY KEY,                 -- Короткий ключ
+    original_url TEXT NOT NULL,                -- Оригинальный URL
+    user_id TEXT,                              -- Идентификатор пользователя, сократившего URL
+    is_deleted BOOLEAN NOT NULL DEFAULT FALSE, -- Признак удаления короткого URL
+    expires_at TIMESTAMP                       -- Момент окончания действия (UTC). NULL - бессрочно.
+);
+INSERT INTO urls_new (short_id, original_url, user_id, is_deleted, expires_at)
+SELECT short_id, original_url, user_id, is_deleted, expires_at FROM urls;
+DROP TABLE urls;
+ALTER TABLE urls_new RENAME TO urls;
+CREATE INDEX IF NOT EXISTS urls_user_id_idx ON urls (user_id);
+CREATE INDEX IF NOT EXISTS urls_expires_at_idx ON urls (expires_at) WHERE expires_at IS NOT NULL AND NOT is_deleted;
+CREATE UNIQUE INDEX IF NOT EXISTS urls_original_url_live_idx ON urls (original_url) WHERE NOT is_deleted;
@@ -0,0 +1,4 @@
+-- Оригинальный URL уникален только среди неудаленных записей: удаленная запись остается в таблице
+-- под своим коротким id, а тот же URL, сокращенный заново, получает новый короткий id.
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_original_url_key;
+CREATE UNIQUE INDEX IF NOT EXISTS urls_original_url_live_idx ON urls (original_url) WHERE NOT is_deleted;
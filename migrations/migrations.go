@@ -0,0 +1,10 @@
+// Package migrations содержит файлы миграций базы данных, встроенные в исполняемый файл.
+// Формат имен файлов описан в пакете db.
+package migrations
+
+import "embed"
+
+// FS - файлы миграций.
+//
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,9 @@
+#!/bin/bash
+
+# Генерация кода gRPC API из api/shortenerpb/shortener.proto.
+# Требуются buf, protoc-gen-go и protoc-gen-go-grpc:
+#   go install github.com/bufbuild/buf/cmd/buf@v1.47.2
+#   go install google.golang.org/protobuf/cmd/protoc-gen-go@v1.35.1
+#   go install google.golang.org/grpc/cmd/protoc-gen-go-grpc@v1.5.1
+
+cd "$(dirname "$0")/../api" && buf generate